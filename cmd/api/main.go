@@ -1,44 +1,159 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	_ "time/tzdata"
 
 	"github.com/asaaitika/fleetmgm-tst/internal/config"
 	"github.com/asaaitika/fleetmgm-tst/internal/handlers"
+	"github.com/asaaitika/fleetmgm-tst/internal/health"
+	"github.com/asaaitika/fleetmgm-tst/internal/logging"
+	"github.com/asaaitika/fleetmgm-tst/internal/metrics"
 	"github.com/asaaitika/fleetmgm-tst/internal/repositories"
+	"github.com/asaaitika/fleetmgm-tst/internal/services"
 	"github.com/gin-gonic/gin"
 )
 
+var logger = logging.New("api")
+
 func main() {
-	cfg := config.LoadConfig()
+	flags := config.AddFlags(flag.CommandLine)
+	flag.Parse()
+	cfg := config.Setup("api", flags)
 
 	db, err := config.ConnectDB(cfg)
 	if err != nil {
-		log.Fatal("[API][DB][ERROR] >>> Failed to connect to database:", err)
+		logging.Fatal(logger, "Failed to connect to database", "subsystem", "db", "error", err)
 	}
 	defer db.Close()
 
-	log.Println("[API][DB][INFO] >>> Connected to PostgreSQL")
+	logger.Info("Connected to PostgreSQL", "subsystem", "db")
 
 	vehicleRepo := repositories.NewVehicleRepository(db)
+	routeRepo := repositories.NewRouteRepository(db)
+	gtfsRepo := repositories.NewGTFSRepository(db)
+	stopVisitRepo := repositories.NewStopVisitRepository(db)
+	speedingRepo := repositories.NewSpeedingRepository(db)
+	idleRepo := repositories.NewIdleRepository(db)
+	harshRepo := repositories.NewHarshEventRepository(db)
+	driverRepo := repositories.NewDriverRepository(db)
+
+	timezone, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		logging.Fatal(logger, "Invalid TIMEZONE", "timezone", cfg.Timezone, "error", err)
+	}
+
+	presence := services.PresencePolicy{
+		StaleAfter:   cfg.StaleAfter,
+		OfflineAfter: cfg.OfflineAfter,
+	}
 
-	vehicleHandler := handlers.NewVehicleHandler(vehicleRepo)
+	vehicleHandler := handlers.NewVehicleHandler(vehicleRepo, presence, cfg.APIPositionSource)
+	routeHandler := handlers.NewRouteHandler(routeRepo, gtfsRepo)
+	realtimeHandler := handlers.NewGTFSRealtimeHandler(services.NewGTFSRealtimeService(
+		vehicleRepo, routeRepo, gtfsRepo, presence, cfg.APIPositionSource, timezone))
+	etaHandler := handlers.NewETAHandler(services.NewETAService(vehicleRepo, routeRepo, presence, cfg.APIPositionSource,
+		services.ETAConfig{
+			HistoryWindow:   cfg.ETAHistoryWindow,
+			DefaultSpeedKmh: cfg.ETADefaultSpeedKmh,
+			Dwell:           cfg.ETADwell,
+			CacheTTL:        cfg.ETACacheTTL,
+		}), vehicleRepo)
+	analyticsHandler := handlers.NewAnalyticsHandler(stopVisitRepo, speedingRepo, idleRepo, harshRepo, cfg.OnTimeEarly, cfg.OnTimeLate, cfg.Timezone)
+	driverHandler := handlers.NewDriverHandler(driverRepo)
+	speedZoneHandler := handlers.NewSpeedLimitZoneHandler(speedingRepo)
+	headwayHandler := handlers.NewHeadwayHandler(routeRepo, stopVisitRepo, services.HeadwayPolicy{
+		Bunching: cfg.HeadwayBunching,
+		Gap:      cfg.HeadwayGap,
+	}, cfg.HeadwayWindow)
 
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Recovery(), logging.GinMiddleware(logger.With("subsystem", "http")), metrics.GinMiddleware())
 
 	router.GET("/vehicles/:vehicle_id/location", vehicleHandler.GetLastLocation)
 	router.GET("/vehicles/:vehicle_id/history", vehicleHandler.GetLocationHistory)
+	router.GET("/vehicles/:vehicle_id/route", routeHandler.GetVehicleRoute)
+	router.PUT("/vehicles/:vehicle_id/route", routeHandler.AssignVehicle)
+	router.DELETE("/vehicles/:vehicle_id/route", routeHandler.UnassignVehicle)
+	router.GET("/vehicles/:vehicle_id/eta", etaHandler.GetVehicleETA)
+	router.GET("/vehicles/:vehicle_id/stop-visits", analyticsHandler.GetStopVisits)
+	router.GET("/vehicles/:vehicle_id/harsh-events", analyticsHandler.GetHarshEvents)
+	router.GET("/vehicles/:vehicle_id/driver", driverHandler.GetVehicleDriver)
+
+	router.GET("/stops/:stop_id/arrivals", etaHandler.GetStopArrivals)
+
+	router.GET("/routes", routeHandler.ListRoutes)
+	router.POST("/routes", routeHandler.CreateRoute)
+	router.GET("/routes/:route_id", routeHandler.GetRoute)
+	router.PUT("/routes/:route_id", routeHandler.UpdateRoute)
+	router.DELETE("/routes/:route_id", routeHandler.DeleteRoute)
+	router.PUT("/routes/:route_id/stops", routeHandler.ReplaceStops)
+	router.PUT("/routes/:route_id/shape", routeHandler.ReplaceShape)
+	router.GET("/routes/:route_id/headways", headwayHandler.GetRouteHeadways)
+
+	router.GET("/drivers", driverHandler.ListDrivers)
+	router.POST("/drivers", driverHandler.CreateDriver)
+	router.GET("/drivers/:driver_id", driverHandler.GetDriver)
+	router.PUT("/drivers/:driver_id", driverHandler.UpdateDriver)
+	router.DELETE("/drivers/:driver_id", driverHandler.DeleteDriver)
+	router.POST("/drivers/:driver_id/login", driverHandler.Login)
+	router.POST("/drivers/:driver_id/logout", driverHandler.Logout)
+	router.GET("/drivers/:driver_id/shifts", driverHandler.GetDriverShifts)
+
+	router.GET("/speed-limit-zones", speedZoneHandler.ListZones)
+	router.POST("/speed-limit-zones", speedZoneHandler.CreateZone)
+	router.DELETE("/speed-limit-zones/:zone_id", speedZoneHandler.DeleteZone)
 
-	// Check health
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{"status": "healthy"})
-	})
+	router.GET("/analytics/on-time-performance", analyticsHandler.GetOnTimePerformance)
+	router.GET("/analytics/speeding", analyticsHandler.GetSpeeding)
+	router.GET("/analytics/idle", analyticsHandler.GetIdle)
+	router.GET("/analytics/safety-scores", analyticsHandler.GetSafetyScores)
+
+	router.GET("/gtfs-rt/vehicle-positions.pb", realtimeHandler.VehiclePositions)
+	router.GET("/gtfs-rt/trip-updates.pb", realtimeHandler.TripUpdates)
+
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	// Liveness and readiness, /health is kept for existing clients and
+	// answers like /readyz
+	checker := health.NewChecker(cfg.HealthCheckTimeout)
+	checker.Add("db", db.PingContext)
+	router.GET("/livez", gin.WrapF(checker.Livez))
+	router.GET("/readyz", gin.WrapF(checker.Readyz))
+	router.GET("/health", gin.WrapF(checker.Readyz))
 
 	addr := fmt.Sprintf(":%s", cfg.ServerPort)
-	log.Printf("[API][APP][INFO] >>> API Server starting on %s", addr)
+	server := &http.Server{Addr: addr, Handler: router}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info("API Server starting", "addr", addr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErr:
+		logging.Fatal(logger, "Failed to start server", "error", err)
+	case <-sigChan:
+	}
+
+	logger.Info("Shutting down", "timeout", cfg.ShutdownTimeout.String())
+
+	// Stop accepting connections and wait for the requests in progress
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
 
-	if err := router.Run(addr); err != nil {
-		log.Fatal("[API][APP][ERROR] >>> Failed to start server:", err)
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Warn("Requests still in progress at the deadline", "error", err)
 	}
 }
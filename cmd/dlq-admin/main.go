@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/config"
+	"github.com/asaaitika/fleetmgm-tst/internal/logging"
+	"github.com/asaaitika/fleetmgm-tst/internal/services"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const usage = `Usage: dlq-admin <command> [flags]
+
+Commands:
+  list      Show parked messages without removing them
+  requeue   Move parked messages back to geofence_alerts
+  purge     Delete parked messages
+
+Flags:
+  -limit N        Maximum number of messages to handle (default 20, 0 = all)
+  -id ID          Only handle the message with this message ID
+  -config FILE    YAML or TOML config file (or CONFIG_FILE)
+  -print-config   Print the configuration and exit
+`
+
+var logger = logging.New("dlq-admin")
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	command := os.Args[1]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	limit := flags.Int("limit", 20, "maximum number of messages (0 = all)")
+	messageID := flags.String("id", "", "only handle the message with this message ID")
+	configFlags := config.AddFlags(flags)
+	flags.Parse(os.Args[2:])
+	cfg := config.Setup("dlq-admin", configFlags)
+
+	conn, err := amqp.Dial(cfg.RabbitMQURL)
+	if err != nil {
+		logging.Fatal(logger, "Failed to connect to RabbitMQ", "subsystem", "rabbitmq", "error", err)
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		logging.Fatal(logger, "Failed to open channel", "subsystem", "rabbitmq", "error", err)
+	}
+	defer ch.Close()
+
+	if err := ch.Confirm(false); err != nil {
+		logging.Fatal(logger, "Failed to enable publisher confirms", "subsystem", "rabbitmq", "error", err)
+	}
+
+	var handled int
+	switch command {
+	case "list":
+		handled, err = walk(ch, *limit, *messageID, func(msg amqp.Delivery) (bool, error) {
+			printMessage(msg)
+			return true, nil
+		})
+	case "requeue":
+		handled, err = walk(ch, *limit, *messageID, func(msg amqp.Delivery) (bool, error) {
+			if err := requeue(ch, msg); err != nil {
+				return true, err
+			}
+			return false, msg.Ack(false)
+		})
+	case "purge":
+		handled, err = walk(ch, *limit, *messageID, func(msg amqp.Delivery) (bool, error) {
+			return false, msg.Ack(false)
+		})
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		logging.Fatal(logger, "Command failed", "command", command, "error", err)
+	}
+
+	logger.Info("Messages handled", "command", command, "handled", handled)
+}
+
+// walk fetches parked messages one by one and passes them to fn. Messages that
+// don't match the ID filter, or that fn asks to keep, are put back when done so
+// the same message is not fetched twice.
+func walk(ch *amqp.Channel, limit int, messageID string, fn func(amqp.Delivery) (bool, error)) (int, error) {
+	queue, err := ch.QueueDeclarePassive(services.GeofenceParkingQueue, true, false, false, false, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect parking-lot queue: %v", err)
+	}
+
+	var kept []amqp.Delivery
+	defer func() {
+		for _, msg := range kept {
+			msg.Nack(false, true)
+		}
+	}()
+
+	handled := 0
+	// Only look at the messages present when we started, requeued ones would loop forever
+	for i := 0; i < queue.Messages; i++ {
+		if limit > 0 && handled >= limit {
+			break
+		}
+
+		msg, ok, err := ch.Get(services.GeofenceParkingQueue, false)
+		if err != nil {
+			return handled, fmt.Errorf("failed to get message: %v", err)
+		}
+		if !ok {
+			break
+		}
+
+		if messageID != "" && msg.MessageId != messageID {
+			kept = append(kept, msg)
+			continue
+		}
+
+		keep, err := fn(msg)
+		if keep {
+			kept = append(kept, msg)
+		}
+		if err != nil {
+			return handled, err
+		}
+		handled++
+	}
+
+	return handled, nil
+}
+
+// requeue publishes a parked message back to geofence_alerts with a fresh retry count
+func requeue(ch *amqp.Channel, msg amqp.Delivery) error {
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		switch k {
+		case services.RetryCountHeader, services.LastErrorHeader, "x-death", "x-first-death-exchange", "x-first-death-queue", "x-first-death-reason":
+			continue
+		}
+		headers[k] = v
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(
+		ctx,
+		"",                           // default exchange
+		services.GeofenceAlertsQueue, // routing key
+		false,                        // mandatory
+		false,                        // immediate
+		amqp.Publishing{
+			ContentType:  msg.ContentType,
+			MessageId:    msg.MessageId,
+			Headers:      headers,
+			Body:         msg.Body,
+			DeliveryMode: amqp.Persistent,
+			Timestamp:    msg.Timestamp,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to requeue message %s: %v", msg.MessageId, err)
+	}
+
+	ok, err := confirm.WaitContext(ctx)
+	if err != nil || !ok {
+		return fmt.Errorf("broker did not confirm requeue of message %s: %v", msg.MessageId, err)
+	}
+
+	return nil
+}
+
+func printMessage(msg amqp.Delivery) {
+	reason := "rejected"
+	if deaths, ok := msg.Headers["x-death"].([]interface{}); ok && len(deaths) > 0 {
+		if death, ok := deaths[0].(amqp.Table); ok {
+			reason = fmt.Sprint(death["reason"])
+		}
+	}
+	if lastErr, ok := msg.Headers[services.LastErrorHeader].(string); ok {
+		reason = lastErr
+	}
+
+	fmt.Printf("--- message_id=%s retries=%d timestamp=%s\n", msg.MessageId, services.RetryCount(msg.Headers), msg.Timestamp.Format(time.RFC3339))
+	fmt.Printf("reason: %s\n", reason)
+	fmt.Printf("body:   %s\n", msg.Body)
+}
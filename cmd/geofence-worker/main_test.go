@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// memoryEventStore keeps processed event IDs like the processed_events table
+type memoryEventStore struct {
+	ids map[string]bool
+	err error
+}
+
+func (s *memoryEventStore) MarkEventProcessed(eventID string) (bool, error) {
+	if s.err != nil {
+		return false, s.err
+	}
+	if s.ids[eventID] {
+		return false, nil
+	}
+	s.ids[eventID] = true
+	return true, nil
+}
+
+func TestHandleEventSkipsDuplicates(t *testing.T) {
+	entry := models.GeofenceEvent{
+		EventID:   "evt-1",
+		VehicleID: "B1234XYZ",
+		Event:     "geofence_entry",
+		AreaName:  "Halte Pluit",
+		Timestamp: 1700000000,
+	}
+	legacy := entry
+	legacy.EventID = ""
+	exit := legacy
+	exit.Event = "geofence_exit"
+	later := legacy
+	later.Timestamp++
+
+	tests := []struct {
+		name          string
+		events        []models.GeofenceEvent
+		wantDuplicate []bool
+	}{
+		{"first delivery", []models.GeofenceEvent{entry}, []bool{false}},
+		{"redelivery of the same event", []models.GeofenceEvent{entry, entry, entry}, []bool{false, true, true}},
+		{"legacy event without ID", []models.GeofenceEvent{legacy, legacy}, []bool{false, true}},
+		{"entry and exit are different events", []models.GeofenceEvent{legacy, exit}, []bool{false, false}},
+		{"same area at another time", []models.GeofenceEvent{legacy, later}, []bool{false, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processedEvents = &memoryEventStore{ids: make(map[string]bool)}
+
+			for i, event := range tt.events {
+				duplicate, err := handleEvent(context.Background(), &event)
+				if err != nil {
+					t.Fatalf("event %d: %v", i, err)
+				}
+				if duplicate != tt.wantDuplicate[i] {
+					t.Errorf("event %d: duplicate = %v, want %v", i, duplicate, tt.wantDuplicate[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHandleEventStoreFailure(t *testing.T) {
+	processedEvents = &memoryEventStore{err: errors.New("connection refused")}
+
+	event := models.GeofenceEvent{EventID: "evt-1", VehicleID: "B1234XYZ", Event: "geofence_entry"}
+	duplicate, err := handleEvent(context.Background(), &event)
+	if err == nil {
+		t.Fatal("expected an error so the event is retried")
+	}
+	if duplicate {
+		t.Error("a failed lookup must not count as a duplicate")
+	}
+}
+
+func TestShardKey(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  amqp.Delivery
+		want string
+	}{
+		{"header", amqp.Delivery{Headers: amqp.Table{"vehicle_id": "B1"}, Body: []byte(`{"vehicle_id":"B2"}`)}, "B1"},
+		{"body without header", amqp.Delivery{Body: []byte(`{"vehicle_id":"B2"}`)}, "B2"},
+		{"unparsable body", amqp.Delivery{Body: []byte(`not json`)}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shardKey(tt.msg); got != tt.want {
+				t.Errorf("shardKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequeueBackoff(t *testing.T) {
+	b := &requeueBackoff{min: time.Second, max: 5 * time.Second}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := b.next(); got != w {
+			t.Errorf("next() #%d = %v, want %v", i+1, got, w)
+		}
+	}
+
+	b.reset()
+	if got := b.next(); got != time.Second {
+		t.Errorf("next() after reset = %v, want %v", got, time.Second)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/config"
+	"github.com/asaaitika/fleetmgm-tst/internal/gtfs"
+	"github.com/asaaitika/fleetmgm-tst/internal/logging"
+	"github.com/asaaitika/fleetmgm-tst/internal/repositories"
+)
+
+var logger = logging.New("gtfs-import")
+
+func main() {
+	file := flag.String("file", "", "path to the GTFS zip file")
+	stopRadius := flag.Int("stop-radius", 0, "geofence radius in meters for newly imported stops (default gtfs.stop_radius)")
+	flags := config.AddFlags(flag.CommandLine)
+	flag.Parse()
+	cfg := config.Setup("gtfs-import", flags)
+
+	if *stopRadius == 0 {
+		*stopRadius = cfg.GTFSStopRadius
+	}
+
+	if *file == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *stopRadius < 0 {
+		logging.Fatal(logger, "stop-radius must be positive", "stop_radius", *stopRadius)
+	}
+
+	db, err := config.ConnectDB(cfg)
+	if err != nil {
+		logging.Fatal(logger, "Failed to connect to database", "subsystem", "db", "error", err)
+	}
+	defer db.Close()
+
+	started := time.Now()
+
+	feed, err := gtfs.Load(*file)
+	if err != nil {
+		logging.Fatal(logger, "Failed to load feed", "subsystem", "feed", "file", *file, "error", err)
+	}
+
+	logger.Info("Loaded feed", "subsystem", "feed", "file", *file, "stops", len(feed.Stops), "routes", len(feed.Routes),
+		"trips", len(feed.Trips), "stop_times", len(feed.StopTimes), "shapes", len(feed.Shapes))
+
+	stats, err := repositories.NewGTFSRepository(db).ImportFeed(feed, *stopRadius)
+	if err != nil {
+		logging.Fatal(logger, "Import failed, nothing was changed", "subsystem", "db", "error", err)
+	}
+
+	logger.Info("Stops imported", "subsystem", "db", "inserted", stats.StopsInserted, "updated", stats.StopsUpdated,
+		"new_stop_radius_meters", *stopRadius)
+	logger.Info("Routes imported", "subsystem", "db", "routes", stats.Routes, "trips", stats.Trips,
+		"stop_times", stats.StopTimes)
+	logger.Info("Calendars imported", "subsystem", "db", "calendars", stats.Calendars,
+		"calendar_dates", stats.CalendarDates)
+
+	if stats.SkippedStopTimes > 0 {
+		logger.Warn("Skipped stop times referencing unknown stops", "subsystem", "db", "skipped", stats.SkippedStopTimes)
+	}
+	if stats.RoutesWithoutTrip > 0 {
+		logger.Warn("Routes without trips, their stops and shape were left unchanged", "subsystem", "db",
+			"routes", stats.RoutesWithoutTrip)
+	}
+
+	logger.Info("Import finished", "duration", time.Since(started).Round(time.Millisecond).String())
+}
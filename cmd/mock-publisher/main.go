@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"log"
+	"math"
 	"math/rand"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/asaaitika/fleetmgm-tst/internal/config"
+	"github.com/asaaitika/fleetmgm-tst/internal/health"
+	"github.com/asaaitika/fleetmgm-tst/internal/logging"
+	"github.com/asaaitika/fleetmgm-tst/internal/metrics"
 	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/asaaitika/fleetmgm-tst/internal/mqttconn"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
@@ -42,52 +50,83 @@ var simulationRoute = []struct {
 	{-6.2593, 106.8789, "Halte Pinang Ranti"},
 }
 
-func main() {
-	broker := getEnv("MQTT_BROKER", "tcp://localhost:1883")
-	interval := getEnv("PUBLISH_INTERVAL", "2s")
-	vehicleIDs := strings.Split(getEnv("VEHICLE_IDS", "B1234XYZ,B5678ABC"), ",")
+var logger = logging.New("mock-publisher")
 
-	publishInterval, err := time.ParseDuration(interval)
+func main() {
+	flags := config.AddFlags(flag.CommandLine)
+	flag.Parse()
+	cfg := config.Setup("mock-publisher", flags)
+
+	broker := cfg.MQTTBroker
+	publishInterval := cfg.PublishInterval
+	vehicleIDs := cfg.VehicleIDs
+	speedKmh := cfg.SimulationSpeedKmh
+	stopDwell := cfg.SimulationStopDwell
+
+	// The publisher subscribes to nothing and gets a new client ID on every
+	// start, a kept session would only pile up on the broker
+	mqttConfig := cfg.MQTTConfig(fmt.Sprintf("mock_publisher_%d", time.Now().Unix()))
+	mqttConfig.CleanSession = true
+	opts, err := mqttconn.ClientOptions(mqttConfig)
 	if err != nil {
-		log.Fatal("[MOCK-PUBLISHER]][APP][ERROR] >>> Invalid interval:", err)
+		logging.Fatal(logger, "Invalid MQTT settings", "subsystem", "mqtt", "error", err)
 	}
 
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(broker)
-	opts.SetClientID(fmt.Sprintf("mock_publisher_%d", time.Now().Unix()))
-
 	client := mqtt.NewClient(opts)
 	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		log.Fatal("[MOCK-PUBLISHER]][MQTT][ERROR] >>> Failed to connect:", token.Error())
+		logging.Fatal(logger, "Failed to connect", "subsystem", "mqtt", "broker", broker, "error", token.Error())
 	}
-	defer client.Disconnect(250)
+	defer client.Disconnect(uint(cfg.ShutdownTimeout.Milliseconds()))
 
-	log.Printf("[MOCK-PUBLISHER]][MQTT][INFO] >>> Connected to MQTT broker: %s", broker)
-	log.Printf("[MOCK-PUBLISHER]][MQTT][INFO] >>> Publishing data every %s for vehicles: %v", interval, vehicleIDs)
+	logger.Info("Connected to MQTT broker", "subsystem", "mqtt", "broker", broker, "client_id", mqttConfig.ClientID,
+		"tls", mqttconn.IsTLS(broker), "client_cert", mqttConfig.CertFile != "")
+	logger.Info("Publishing data", "subsystem", "mqtt", "interval", publishInterval.String(), "vehicles", vehicleIDs)
 
 	// Random seed
 	rand.Seed(time.Now().UnixNano())
 
-	// Track position for every vehicle
-	vehiclePositions := make(map[string]int)
+	// Track position for every vehicle, each one starts at a random stop
+	vehicles := make(map[string]*simulatedVehicle)
 	for _, vid := range vehicleIDs {
-		vehiclePositions[vid] = rand.Intn(len(simulationRoute))
+		vehicles[vid] = &simulatedVehicle{segment: rand.Intn(len(simulationRoute))}
 	}
 
+	checker := health.NewChecker(cfg.HealthCheckTimeout)
+	checker.Add("mqtt", func(ctx context.Context) error {
+		if !client.IsConnectionOpen() {
+			return fmt.Errorf("not connected to broker")
+		}
+		return nil
+	})
+
+	metrics.Serve(cfg.MetricsListenAddr(":9103"), checker.Register)
+
 	ticker := time.NewTicker(publishInterval)
 	defer ticker.Stop()
 
-	log.Println("[MOCK-PUBLISHER]][INFO] >>> Starting to publish mock GPS data")
+	logger.Info("Starting to publish mock GPS data", "speed_kmh", speedKmh, "dwell", stopDwell.String())
 
-	for range ticker.C {
+	// A batch in progress is finished, Disconnect then waits for the last acks
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	for {
+		select {
+		case <-sigChan:
+			logger.Info("Shutting down")
+			return
+		case <-ticker.C:
+		}
+
+		published := 0
 		for _, vehicleID := range vehicleIDs {
-			// Get current position
-			pos := vehiclePositions[vehicleID]
-			currentPoint := simulationRoute[pos]
+			vehicle := vehicles[vehicleID]
+			vehicle.advance(speedKmh/3.6*publishInterval.Seconds(), publishInterval, stopDwell)
 
-			// Small random offset
-			lat := currentPoint.Lat + (rand.Float64()-0.5)*0.0001
-			lon := currentPoint.Lon + (rand.Float64()-0.5)*0.0001
+			// Small random offset (about 5 meters of GPS noise)
+			position := vehicle.position()
+			lat := position.Lat + (rand.Float64()-0.5)*0.0001
+			lon := position.Lon + (rand.Float64()-0.5)*0.0001
 
 			payload := models.MQTTPayload{
 				VehicleID: vehicleID,
@@ -98,7 +137,8 @@ func main() {
 
 			data, err := json.Marshal(payload)
 			if err != nil {
-				log.Printf("[MOCK-PUBLISHER]][ERROR] >>> Failed to marshal: %v", err)
+				logger.Error("Failed to marshal", "vehicle_id", vehicleID, "error", err)
+				metrics.MockPublished.WithLabelValues("error").Inc()
 				continue
 			}
 
@@ -108,21 +148,79 @@ func main() {
 			token.Wait()
 
 			if token.Error() != nil {
-				log.Printf("[MOCK-PUBLISHER]][ERROR] >>> Failed to publish: %v", token.Error())
+				logger.Error("Failed to publish", "vehicle_id", vehicleID, "error", token.Error())
+				metrics.MockPublished.WithLabelValues("error").Inc()
 			} else {
-				log.Printf("[MOCK-PUBLISHER]][DEBUG] >>> Published: Vehicle=%s, Location=%s (%.4f,%.4f)",
-					vehicleID, currentPoint.Name, lat, lon)
+				published++
+				metrics.MockPublished.WithLabelValues("ok").Inc()
+				logger.Debug("Published", "vehicle_id", vehicleID, "location", vehicle.describe(),
+					"latitude", lat, "longitude", lon)
 			}
-
-			// Move to next position
-			vehiclePositions[vehicleID] = (pos + 1) % len(simulationRoute)
 		}
+		metrics.MockBatchSize.Observe(float64(published))
+	}
+}
+
+// simulatedVehicle drives along simulationRoute, stopping for a while at every halte
+type simulatedVehicle struct {
+	segment  int           // index of the stop the vehicle last left (or is standing at)
+	traveled float64       // meters traveled from that stop
+	dwell    time.Duration // remaining time standing at the stop
+}
+
+func (v *simulatedVehicle) advance(meters float64, elapsed, stopDwell time.Duration) {
+	if v.dwell > 0 {
+		v.dwell -= elapsed
+		return
 	}
+
+	v.traveled += meters
+
+	from := simulationRoute[v.segment]
+	to := simulationRoute[(v.segment+1)%len(simulationRoute)]
+	if v.traveled < distanceMeters(from.Lat, from.Lon, to.Lat, to.Lon) {
+		return
+	}
+
+	// Arrived at the next stop
+	v.segment = (v.segment + 1) % len(simulationRoute)
+	v.traveled = 0
+	v.dwell = stopDwell
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+func (v *simulatedVehicle) position() struct{ Lat, Lon float64 } {
+	from := simulationRoute[v.segment]
+	to := simulationRoute[(v.segment+1)%len(simulationRoute)]
+	length := distanceMeters(from.Lat, from.Lon, to.Lat, to.Lon)
+
+	ratio := 0.0
+	if length > 0 {
+		ratio = v.traveled / length
+	}
+
+	return struct{ Lat, Lon float64 }{
+		Lat: from.Lat + (to.Lat-from.Lat)*ratio,
+		Lon: from.Lon + (to.Lon-from.Lon)*ratio,
 	}
-	return defaultValue
+}
+
+func (v *simulatedVehicle) describe() string {
+	if v.traveled == 0 {
+		return simulationRoute[v.segment].Name
+	}
+	return "menuju " + simulationRoute[(v.segment+1)%len(simulationRoute)].Name
+}
+
+// distanceMeters calculates distance between 2 coordinates using Haversine formula
+func distanceMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371000.0
+
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLon := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*
+			math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return earthRadius * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
 }
@@ -0,0 +1,240 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTemp writes a file into the test's temporary directory and returns
+// its path
+func writeTemp(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	type source struct {
+		file     string // config file content, by its extension in fileName
+		fileName string
+		env      map[string]string
+	}
+
+	tests := []struct {
+		name         string
+		source       func(secret string) source
+		wantPassword string
+		wantHost     string
+	}{
+		{
+			name:         "defaults",
+			source:       func(string) source { return source{} },
+			wantPassword: "fleet_pass",
+			wantHost:     "localhost",
+		},
+		{
+			name: "file over defaults",
+			source: func(string) source {
+				return source{fileName: "fleet.yaml", file: "db:\n  host: db.internal\n  password: from-file\n"}
+			},
+			wantPassword: "from-file",
+			wantHost:     "db.internal",
+		},
+		{
+			name: "toml file",
+			source: func(string) source {
+				return source{fileName: "fleet.toml", file: "[db]\nhost = \"db.internal\"\npassword = \"from-toml\"\n"}
+			},
+			wantPassword: "from-toml",
+			wantHost:     "db.internal",
+		},
+		{
+			name: "environment over file",
+			source: func(string) source {
+				return source{
+					fileName: "fleet.yaml",
+					file:     "db:\n  host: db.internal\n  password: from-file\n",
+					env:      map[string]string{"DB_PASSWORD": "from-env"},
+				}
+			},
+			wantPassword: "from-env",
+			wantHost:     "db.internal",
+		},
+		{
+			name: "secret file over environment",
+			source: func(secret string) source {
+				return source{
+					fileName: "fleet.yaml",
+					file:     "db:\n  password: from-file\n",
+					env:      map[string]string{"DB_PASSWORD": "from-env", "DB_PASSWORD_FILE": secret},
+				}
+			},
+			wantPassword: "from-secret",
+			wantHost:     "localhost",
+		},
+		{
+			name: "secret file key in the config file",
+			source: func(secret string) source {
+				return source{fileName: "fleet.yaml", file: "db:\n  password_file: " + secret + "\n"}
+			},
+			wantPassword: "from-secret",
+			wantHost:     "localhost",
+		},
+		{
+			name: "environment over secret file key in the config file",
+			source: func(secret string) source {
+				return source{
+					fileName: "fleet.yaml",
+					file:     "db:\n  password_file: " + secret + "\n",
+					env:      map[string]string{"DB_PASSWORD": "from-env"},
+				}
+			},
+			wantPassword: "from-env",
+			wantHost:     "localhost",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The trailing newline of the secret is not part of the value
+			secret := writeTemp(t, "db_password", "from-secret\n")
+			src := tt.source(secret)
+
+			file := ""
+			if src.fileName != "" {
+				file = writeTemp(t, src.fileName, src.file)
+			}
+			for name, value := range src.env {
+				t.Setenv(name, value)
+			}
+
+			cfg, err := Load(file)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if cfg.DBPassword != tt.wantPassword {
+				t.Errorf("db.password = %q, want %q", cfg.DBPassword, tt.wantPassword)
+			}
+			if cfg.DBHost != tt.wantHost {
+				t.Errorf("db.host = %q, want %q", cfg.DBHost, tt.wantHost)
+			}
+		})
+	}
+}
+
+func TestLoadTypes(t *testing.T) {
+	file := writeTemp(t, "fleet.yaml", strings.Join([]string{
+		"publisher:",
+		"  vehicle_ids: [B1, B2]",
+		"worker:",
+		"  concurrency: 8",
+		"  retry_delays: [1s, 10s]",
+		"mqtt:",
+		"  clean_session: false",
+		"gps:",
+		"  max_speed_kmh: 120.5",
+	}, "\n"))
+	t.Setenv("WORKER_QUEUE_POLL", "5s")
+
+	cfg, err := Load(file)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if want := []string{"B1", "B2"}; !reflect.DeepEqual(cfg.VehicleIDs, want) {
+		t.Errorf("publisher.vehicle_ids = %v, want %v", cfg.VehicleIDs, want)
+	}
+	if cfg.WorkerConcurrency != 8 {
+		t.Errorf("worker.concurrency = %d, want 8", cfg.WorkerConcurrency)
+	}
+	if want := []time.Duration{time.Second, 10 * time.Second}; !reflect.DeepEqual(cfg.WorkerRetryDelays, want) {
+		t.Errorf("worker.retry_delays = %v, want %v", cfg.WorkerRetryDelays, want)
+	}
+	if cfg.MQTTCleanSession {
+		t.Error("mqtt.clean_session = true, want false")
+	}
+	if cfg.MaxSpeedKmh != 120.5 {
+		t.Errorf("gps.max_speed_kmh = %g, want 120.5", cfg.MaxSpeedKmh)
+	}
+	if cfg.WorkerQueuePoll != 5*time.Second {
+		t.Errorf("worker.queue_poll = %s, want 5s", cfg.WorkerQueuePoll)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		file     string
+		env      map[string]string
+		wantErrs []string
+	}{
+		{
+			name:     "unknown key",
+			fileName: "fleet.yaml",
+			file:     "db:\n  hostname: db.internal\n",
+			wantErrs: []string{"db.hostname: unknown key"},
+		},
+		{
+			name:     "unsupported file type",
+			fileName: "fleet.json",
+			file:     "{}",
+			wantErrs: []string{"unsupported config file"},
+		},
+		{
+			name:     "invalid value in the file",
+			fileName: "fleet.yaml",
+			file:     "worker:\n  concurrency: many\n",
+			wantErrs: []string{`worker.concurrency (in `, `invalid integer "many"`},
+		},
+		{
+			name:     "invalid environment value",
+			env:      map[string]string{"WORKER_QUEUE_POLL": "soon"},
+			wantErrs: []string{`worker.queue_poll (from WORKER_QUEUE_POLL): invalid duration "soon"`},
+		},
+		{
+			name:     "missing secret file",
+			env:      map[string]string{"DB_PASSWORD_FILE": "/nonexistent/db_password"},
+			wantErrs: []string{"DB_PASSWORD_FILE: failed to read secret file"},
+		},
+		{
+			name:     "all errors are reported",
+			env:      map[string]string{"WORKER_CONCURRENCY": "x", "MQTT_CLEAN_SESSION": "maybe"},
+			wantErrs: []string{`invalid integer "x"`, `invalid boolean "maybe"`},
+		},
+		{
+			name:     "validation runs after loading",
+			env:      map[string]string{"MQTT_BROKER": "http://broker:1883"},
+			wantErrs: []string{"mqtt.broker: must be a URL"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := ""
+			if tt.fileName != "" {
+				file = writeTemp(t, tt.fileName, tt.file)
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			_, err := Load(file)
+			if err == nil {
+				t.Fatal("Load succeeded, want an error")
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/logging"
+)
+
+// Flags are the command line flags shared by every binary
+type Flags struct {
+	File  string
+	Print bool
+}
+
+// AddFlags registers -config and -print-config. The config file can also be
+// given with CONFIG_FILE.
+func AddFlags(fs *flag.FlagSet) *Flags {
+	flags := &Flags{}
+	fs.StringVar(&flags.File, "config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	fs.BoolVar(&flags.Print, "print-config", false, "print the configuration with secrets redacted and exit")
+	return flags
+}
+
+// Setup loads the configuration of a binary and initializes its logging. It
+// exits when the configuration is invalid, listing every problem, and after
+// printing it with -print-config.
+func Setup(component string, flags *Flags) *Config {
+	cfg, err := Load(flags.File)
+	if err != nil {
+		logInvalid(err)
+		os.Exit(1)
+	}
+
+	if flags.Print {
+		if err := cfg.Print(os.Stdout); err != nil {
+			logging.Fatal(logger, "Failed to print configuration", "error", err)
+		}
+		os.Exit(0)
+	}
+
+	logConfig, err := cfg.LoggingConfig()
+	if err != nil {
+		logging.Fatal(logger, "Invalid logging configuration", "error", err)
+	}
+	logging.Init(component, logConfig)
+
+	if flags.File != "" {
+		logger.Info("Loaded configuration", "file", flags.File)
+	}
+
+	return cfg
+}
+
+func logInvalid(err error) {
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) {
+		logger.Error("Invalid configuration", "error", err)
+		return
+	}
+	for _, e := range joined.Unwrap() {
+		logger.Error("Invalid configuration", "error", e)
+	}
+}
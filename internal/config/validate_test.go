@@ -0,0 +1,178 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(c *Config)
+		wantErrs []string // empty for a valid configuration
+	}{
+		{
+			name:   "defaults",
+			change: func(c *Config) {},
+		},
+		{
+			name:     "port is not a number",
+			change:   func(c *Config) { c.DBPort = "postgres" },
+			wantErrs: []string{`db.port: must be a port number, got "postgres"`},
+		},
+		{
+			name:     "port out of range",
+			change:   func(c *Config) { c.ServerPort = "70000" },
+			wantErrs: []string{"api.port: must be a port number"},
+		},
+		{
+			name:     "unsupported broker scheme",
+			change:   func(c *Config) { c.MQTTBroker = "http://broker:1883" },
+			wantErrs: []string{"mqtt.broker: must be a URL"},
+		},
+		{
+			name:     "tls files on a plain broker",
+			change:   func(c *Config) { c.MQTTTLSCA = "/certs/ca.crt" },
+			wantErrs: []string{"mqtt.tls_ca: needs a TLS broker"},
+		},
+		{
+			name: "tls files on a tls broker",
+			change: func(c *Config) {
+				c.MQTTBroker = "ssl://broker:8883"
+				c.MQTTTLSCA, c.MQTTTLSCert, c.MQTTTLSKey = "/certs/ca.crt", "/certs/client.crt", "/certs/client.key"
+			},
+		},
+		{
+			name: "client certificate without key",
+			change: func(c *Config) {
+				c.MQTTBroker = "mqtts://broker:8883"
+				c.MQTTTLSCert = "/certs/client.crt"
+			},
+			wantErrs: []string{"mqtt.tls_cert: must be set together with mqtt.tls_key"},
+		},
+		{
+			name:     "password without username",
+			change:   func(c *Config) { c.MQTTPassword = "secret" },
+			wantErrs: []string{"mqtt.password: needs mqtt.username"},
+		},
+		{
+			name:   "last shard",
+			change: func(c *Config) { c.MQTTShardCount, c.MQTTShardIndex = 3, 2 },
+		},
+		{
+			name:     "shard index out of range",
+			change:   func(c *Config) { c.MQTTShardCount, c.MQTTShardIndex = 3, 3 },
+			wantErrs: []string{"mqtt.shard_index: must be between 0 and mqtt.shard_count - 1, got 3"},
+		},
+		{
+			name:     "no shards",
+			change:   func(c *Config) { c.MQTTShardCount = 0 },
+			wantErrs: []string{"mqtt.shard_count: must be positive, got 0"},
+		},
+		{
+			name:     "negative connect retry interval",
+			change:   func(c *Config) { c.MQTTConnectRetryInterval = -time.Second },
+			wantErrs: []string{"mqtt.connect_retry_interval: must not be negative, got -1s"},
+		},
+		{
+			name:     "rabbitmq url without amqp scheme",
+			change:   func(c *Config) { c.RabbitMQURL = "localhost:5672" },
+			wantErrs: []string{"rabbitmq.url: must be a URL"},
+		},
+		{
+			name:     "no retry delays",
+			change:   func(c *Config) { c.WorkerRetryDelays = nil },
+			wantErrs: []string{"worker.retry_delays: must list at least one delay"},
+		},
+		{
+			name:     "retry delay below a millisecond",
+			change:   func(c *Config) { c.WorkerRetryDelays = []time.Duration{1500 * time.Microsecond} },
+			wantErrs: []string{"worker.retry_delays: must be whole milliseconds, got 1.5ms"},
+		},
+		{
+			name: "dedup retention shorter than the retries",
+			change: func(c *Config) {
+				c.WorkerMaxRetries = 3
+				c.WorkerRetryDelays = []time.Duration{time.Minute}
+				c.WorkerDedupRetention = 3 * time.Minute
+			},
+			wantErrs: []string{"worker.dedup_retention: must be longer than all retries together (3m0s), got 3m0s"},
+		},
+		{
+			name:     "offline before stale",
+			change:   func(c *Config) { c.OfflineAfter = c.StaleAfter },
+			wantErrs: []string{"presence.offline_after: must be longer than presence.stale_after"},
+		},
+		{
+			name:     "headway gap below bunching",
+			change:   func(c *Config) { c.HeadwayGap = c.HeadwayBunching / 2 },
+			wantErrs: []string{"headway.gap_threshold: must be longer than headway.bunching_threshold"},
+		},
+		{
+			name:     "unknown position source",
+			change:   func(c *Config) { c.GeofencePositionSource = "filtered" },
+			wantErrs: []string{`geofence.position_source: must be raw or smoothed, got "filtered"`},
+		},
+		{
+			name:     "negative ingestion lag",
+			change:   func(c *Config) { c.IngestionMaxLag = -time.Minute },
+			wantErrs: []string{"health.ingestion_max_lag: must not be negative"},
+		},
+		{
+			name:     "unknown log level",
+			change:   func(c *Config) { c.LogLevel = "verbose" },
+			wantErrs: []string{"log.level: unknown level"},
+		},
+		{
+			name:     "invalid component levels",
+			change:   func(c *Config) { c.LogLevels = "mqtt-service" },
+			wantErrs: []string{"log.levels: invalid entry"},
+		},
+		{
+			name:     "unknown traces exporter",
+			change:   func(c *Config) { c.TracesExporter = "zipkin" },
+			wantErrs: []string{`tracing.exporter: must be none, otlp, stdout or file, got "zipkin"`},
+		},
+		{
+			name: "every error is reported",
+			change: func(c *Config) {
+				c.DBHost = ""
+				c.WorkerConcurrency = 0
+				c.HarshBrakingG = 0
+			},
+			wantErrs: []string{
+				"db.host: must not be empty",
+				"worker.concurrency: must be positive, got 0",
+				"harsh.braking_g: must be positive, got 0",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.change(cfg)
+
+			err := cfg.Validate()
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Validate succeeded, want an error")
+			}
+
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+			if got := len(strings.Split(err.Error(), "\n")); got != len(tt.wantErrs) {
+				t.Errorf("got %d errors, want %d: %v", got, len(tt.wantErrs), err)
+			}
+		})
+	}
+}
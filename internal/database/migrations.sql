@@ -1,5 +1,22 @@
+DROP TABLE IF EXISTS driver_shifts;
+DROP TABLE IF EXISTS drivers;
+DROP TABLE IF EXISTS harsh_events;
+DROP TABLE IF EXISTS idle_events;
+DROP TABLE IF EXISTS speeding_events;
+DROP TABLE IF EXISTS speed_limit_zone_points;
+DROP TABLE IF EXISTS speed_limit_zones;
+DROP TABLE IF EXISTS stop_visits;
+DROP TABLE IF EXISTS stop_times;
+DROP TABLE IF EXISTS vehicle_routes;
+DROP TABLE IF EXISTS trips;
+DROP TABLE IF EXISTS calendar_dates;
+DROP TABLE IF EXISTS calendar;
+DROP TABLE IF EXISTS route_shapes;
+DROP TABLE IF EXISTS route_stops;
+DROP TABLE IF EXISTS routes;
 DROP TABLE IF EXISTS vehicle_locations;
 DROP TABLE IF EXISTS geofence_areas;
+DROP TABLE IF EXISTS processed_events;
 
 CREATE TABLE vehicle_locations (
     id SERIAL PRIMARY KEY,
@@ -7,6 +24,14 @@ CREATE TABLE vehicle_locations (
     latitude DOUBLE PRECISION NOT NULL,
     longitude DOUBLE PRECISION NOT NULL,
     timestamp BIGINT NOT NULL,
+    quality VARCHAR(20) NOT NULL DEFAULT 'ok',
+    speed_kmh DOUBLE PRECISION, -- reported by the device
+    ignition BOOLEAN,
+    driver_id VARCHAR(64), -- driver on duty when the point was received
+    smoothed_latitude DOUBLE PRECISION,
+    smoothed_longitude DOUBLE PRECISION,
+    smoothed_speed_kmh DOUBLE PRECISION,
+    smoothed_heading DOUBLE PRECISION,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -19,13 +44,284 @@ CREATE TABLE IF NOT EXISTS geofence_areas (
     center_latitude DOUBLE PRECISION NOT NULL, 
     center_longitude DOUBLE PRECISION NOT NULL,
     radius_meters INTEGER NOT NULL,
+    stop_code VARCHAR(64) UNIQUE, -- GTFS stop_id for imported stops
+    area_type VARCHAR(20) NOT NULL DEFAULT 'stop' CHECK (area_type IN ('stop', 'terminal', 'depot')),
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Idempotency keys of events already handled by the geofence worker
+CREATE TABLE IF NOT EXISTS processed_events (
+    event_id VARCHAR(64) PRIMARY KEY,
+    processed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_processed_events_processed_at ON processed_events(processed_at);
+
 -- Insert sample geofence areas
-INSERT INTO geofence_areas (name, center_latitude, center_longitude, radius_meters) VALUES
-('Halte Pinang Ranti', -6.2593, 106.8789, 50),
-('Halte Cawang UKI', -6.2426, 106.8585, 50),
-('Halte Pancoran Tugu', -6.2253, 106.8401, 50),
-('Halte Pertamburan', -6.1679, 106.8038, 50),
-('Halte Pluit', -6.1250, 106.7942, 50);
\ No newline at end of file
+INSERT INTO geofence_areas (name, center_latitude, center_longitude, radius_meters, area_type) VALUES
+('Halte Pinang Ranti', -6.2593, 106.8789, 50, 'terminal'),
+('Halte Cawang UKI', -6.2426, 106.8585, 50, 'stop'),
+('Halte Pancoran Tugu', -6.2253, 106.8401, 50, 'stop'),
+('Halte Pertamburan', -6.1679, 106.8038, 50, 'stop'),
+('Halte Pluit', -6.1250, 106.7942, 50, 'terminal'),
+('Pool Pinang Ranti', -6.2625, 106.8815, 150, 'depot');
+
+-- Routes with ordered stops (linked to geofence areas) and a shape polyline
+CREATE TABLE IF NOT EXISTS routes (
+    id VARCHAR(64) PRIMARY KEY,
+    short_name VARCHAR(50) NOT NULL,
+    long_name VARCHAR(200) NOT NULL DEFAULT '',
+    color VARCHAR(6) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS route_stops (
+    route_id VARCHAR(64) NOT NULL REFERENCES routes(id) ON DELETE CASCADE,
+    stop_sequence INTEGER NOT NULL,
+    geofence_area_id INTEGER NOT NULL REFERENCES geofence_areas(id) ON DELETE CASCADE,
+    PRIMARY KEY (route_id, stop_sequence)
+);
+
+CREATE INDEX idx_route_stops_area ON route_stops(geofence_area_id);
+
+CREATE TABLE IF NOT EXISTS route_shapes (
+    route_id VARCHAR(64) NOT NULL REFERENCES routes(id) ON DELETE CASCADE,
+    point_sequence INTEGER NOT NULL,
+    latitude DOUBLE PRECISION NOT NULL,
+    longitude DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (route_id, point_sequence)
+);
+
+-- Current route of every vehicle and the last stop it reached
+CREATE TABLE IF NOT EXISTS vehicle_routes (
+    vehicle_id VARCHAR(50) PRIMARY KEY,
+    route_id VARCHAR(64) NOT NULL REFERENCES routes(id) ON DELETE CASCADE,
+    assigned_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_stop_sequence INTEGER,
+    last_stop_at BIGINT,
+    trip_id VARCHAR(64)
+);
+
+CREATE INDEX idx_vehicle_routes_route ON vehicle_routes(route_id);
+
+-- Scheduled trips and stop times imported from GTFS.
+-- Times are seconds after midnight of the service day and may exceed 24h.
+CREATE TABLE IF NOT EXISTS trips (
+    id VARCHAR(64) PRIMARY KEY,
+    route_id VARCHAR(64) NOT NULL REFERENCES routes(id) ON DELETE CASCADE,
+    service_id VARCHAR(64) NOT NULL,
+    headsign VARCHAR(200) NOT NULL DEFAULT '',
+    direction_id SMALLINT NOT NULL DEFAULT 0,
+    shape_id VARCHAR(64) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_trips_route ON trips(route_id);
+
+CREATE TABLE IF NOT EXISTS stop_times (
+    trip_id VARCHAR(64) NOT NULL REFERENCES trips(id) ON DELETE CASCADE,
+    stop_sequence INTEGER NOT NULL,
+    geofence_area_id INTEGER NOT NULL REFERENCES geofence_areas(id) ON DELETE CASCADE,
+    arrival_time INTEGER,
+    departure_time INTEGER,
+    PRIMARY KEY (trip_id, stop_sequence)
+);
+
+CREATE INDEX idx_stop_times_area ON stop_times(geofence_area_id, arrival_time);
+
+-- Days on which a GTFS service runs. calendar_dates adds (exception_type 1)
+-- or removes (exception_type 2) single dates.
+CREATE TABLE IF NOT EXISTS calendar (
+    service_id VARCHAR(64) PRIMARY KEY,
+    monday BOOLEAN NOT NULL,
+    tuesday BOOLEAN NOT NULL,
+    wednesday BOOLEAN NOT NULL,
+    thursday BOOLEAN NOT NULL,
+    friday BOOLEAN NOT NULL,
+    saturday BOOLEAN NOT NULL,
+    sunday BOOLEAN NOT NULL,
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS calendar_dates (
+    service_id VARCHAR(64) NOT NULL,
+    date DATE NOT NULL,
+    exception_type SMALLINT NOT NULL,
+    PRIMARY KEY (service_id, date)
+);
+
+-- The trip a vehicle is serving, cleared when the trip is removed by an import
+ALTER TABLE vehicle_routes ADD CONSTRAINT fk_vehicle_routes_trip
+    FOREIGN KEY (trip_id) REFERENCES trips(id) ON DELETE SET NULL;
+
+-- Actual arrivals and departures at stops, derived from geofence entry and
+-- exit. Scheduled times are unix timestamps resolved from stop_times, delays
+-- are in seconds and positive when late.
+CREATE TABLE IF NOT EXISTS stop_visits (
+    id BIGSERIAL PRIMARY KEY,
+    vehicle_id VARCHAR(50) NOT NULL,
+    driver_id VARCHAR(64),
+    geofence_area_id INTEGER NOT NULL REFERENCES geofence_areas(id) ON DELETE CASCADE,
+    route_id VARCHAR(64),
+    stop_sequence INTEGER,
+    trip_id VARCHAR(64),
+    arrived_at BIGINT NOT NULL,
+    departed_at BIGINT,
+    scheduled_arrival BIGINT,
+    scheduled_departure BIGINT,
+    arrival_delay INTEGER,
+    departure_delay INTEGER
+);
+
+CREATE INDEX idx_stop_visits_arrived_at ON stop_visits(arrived_at);
+CREATE INDEX idx_stop_visits_vehicle ON stop_visits(vehicle_id, arrived_at);
+CREATE INDEX idx_stop_visits_open ON stop_visits(vehicle_id) WHERE departed_at IS NULL;
+CREATE INDEX idx_stop_visits_route_stop ON stop_visits(route_id, stop_sequence, arrived_at);
+
+-- Areas with a speed limit, either a polygon or a corridor along a road
+CREATE TABLE IF NOT EXISTS speed_limit_zones (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    zone_type VARCHAR(20) NOT NULL CHECK (zone_type IN ('polygon', 'corridor')),
+    speed_limit_kmh DOUBLE PRECISION NOT NULL,
+    width_meters DOUBLE PRECISION NOT NULL DEFAULT 0, -- corridors only
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS speed_limit_zone_points (
+    zone_id INTEGER NOT NULL REFERENCES speed_limit_zones(id) ON DELETE CASCADE,
+    point_sequence INTEGER NOT NULL,
+    latitude DOUBLE PRECISION NOT NULL,
+    longitude DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (zone_id, point_sequence)
+);
+
+-- Periods a vehicle drove above the limit of a zone, the zone name and limit
+-- are kept so the history survives changes to the zone
+CREATE TABLE IF NOT EXISTS speeding_events (
+    id BIGSERIAL PRIMARY KEY,
+    vehicle_id VARCHAR(50) NOT NULL,
+    driver_id VARCHAR(64),
+    zone_id INTEGER REFERENCES speed_limit_zones(id) ON DELETE SET NULL,
+    zone_name VARCHAR(100) NOT NULL,
+    speed_limit_kmh DOUBLE PRECISION NOT NULL,
+    started_at BIGINT NOT NULL,
+    ended_at BIGINT,
+    max_speed_kmh DOUBLE PRECISION NOT NULL,
+    latitude DOUBLE PRECISION NOT NULL,
+    longitude DOUBLE PRECISION NOT NULL
+);
+
+CREATE INDEX idx_speeding_events_started_at ON speeding_events(started_at);
+CREATE INDEX idx_speeding_events_open ON speeding_events(vehicle_id) WHERE ended_at IS NULL;
+
+-- Periods a vehicle stood still with its engine running outside a terminal or depot
+CREATE TABLE IF NOT EXISTS idle_events (
+    id BIGSERIAL PRIMARY KEY,
+    vehicle_id VARCHAR(50) NOT NULL,
+    driver_id VARCHAR(64),
+    started_at BIGINT NOT NULL,
+    ended_at BIGINT,
+    latitude DOUBLE PRECISION NOT NULL,
+    longitude DOUBLE PRECISION NOT NULL,
+    ignition BOOLEAN -- NULL when the device reports no ignition
+);
+
+CREATE INDEX idx_idle_events_started_at ON idle_events(started_at);
+CREATE INDEX idx_idle_events_open ON idle_events(vehicle_id) WHERE ended_at IS NULL;
+
+-- Harsh braking, acceleration and cornering, from the accelerometer or derived
+-- from consecutive speeds and headings
+CREATE TABLE IF NOT EXISTS harsh_events (
+    id BIGSERIAL PRIMARY KEY,
+    vehicle_id VARCHAR(50) NOT NULL,
+    driver_id VARCHAR(64),
+    event_type VARCHAR(30) NOT NULL,
+    g_force DOUBLE PRECISION NOT NULL,
+    speed_kmh DOUBLE PRECISION NOT NULL,
+    source VARCHAR(20) NOT NULL,
+    timestamp BIGINT NOT NULL,
+    latitude DOUBLE PRECISION NOT NULL,
+    longitude DOUBLE PRECISION NOT NULL
+);
+
+CREATE INDEX idx_harsh_events_timestamp ON harsh_events(timestamp);
+CREATE INDEX idx_harsh_events_vehicle ON harsh_events(vehicle_id, timestamp);
+
+-- Drivers and the time they are on duty on a vehicle
+CREATE TABLE IF NOT EXISTS drivers (
+    id VARCHAR(64) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    license_number VARCHAR(50) NOT NULL DEFAULT '',
+    phone VARCHAR(30) NOT NULL DEFAULT '',
+    active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS driver_shifts (
+    id BIGSERIAL PRIMARY KEY,
+    driver_id VARCHAR(64) NOT NULL REFERENCES drivers(id) ON DELETE CASCADE,
+    vehicle_id VARCHAR(50) NOT NULL,
+    started_at BIGINT NOT NULL,
+    ended_at BIGINT,
+    source VARCHAR(20) NOT NULL DEFAULT 'api', -- 'api' or 'terminal'
+    CHECK (ended_at IS NULL OR ended_at >= started_at)
+);
+
+-- A driver is on one vehicle and a vehicle has one driver at a time
+CREATE UNIQUE INDEX idx_driver_shifts_open_driver ON driver_shifts(driver_id) WHERE ended_at IS NULL;
+CREATE UNIQUE INDEX idx_driver_shifts_open_vehicle ON driver_shifts(vehicle_id) WHERE ended_at IS NULL;
+CREATE INDEX idx_driver_shifts_vehicle ON driver_shifts(vehicle_id, started_at);
+
+-- Sample route used by the mock publisher
+INSERT INTO routes (id, short_name, long_name, color) VALUES
+('PR-PL', 'PR-PL', 'Pinang Ranti - Pluit', 'D32F2F');
+
+INSERT INTO route_stops (route_id, stop_sequence, geofence_area_id)
+SELECT 'PR-PL', s.seq, ga.id
+FROM (VALUES
+    (1, 'Halte Pinang Ranti'),
+    (2, 'Halte Cawang UKI'),
+    (3, 'Halte Pancoran Tugu'),
+    (4, 'Halte Pertamburan'),
+    (5, 'Halte Pluit'),
+    (6, 'Halte Pertamburan'),
+    (7, 'Halte Pancoran Tugu'),
+    (8, 'Halte Cawang UKI'),
+    (9, 'Halte Pinang Ranti')
+) AS s(seq, name)
+JOIN geofence_areas ga ON ga.name = s.name;
+
+INSERT INTO route_shapes (route_id, point_sequence, latitude, longitude)
+SELECT 'PR-PL', rs.stop_sequence, ga.center_latitude, ga.center_longitude
+FROM route_stops rs
+JOIN geofence_areas ga ON ga.id = rs.geofence_area_id
+WHERE rs.route_id = 'PR-PL';
+
+INSERT INTO vehicle_routes (vehicle_id, route_id) VALUES
+('B1234XYZ', 'PR-PL'),
+('B5678ABC', 'PR-PL'),
+('B9012DEF', 'PR-PL');
+
+-- Sample speed limit zones
+INSERT INTO speed_limit_zones (id, name, zone_type, speed_limit_kmh, width_meters) VALUES
+(1, 'Zona Sekolah Pancoran', 'polygon', 30, 0),
+(2, 'Jl. Gatot Subroto', 'corridor', 60, 40);
+
+SELECT setval('speed_limit_zones_id_seq', (SELECT MAX(id) FROM speed_limit_zones));
+
+INSERT INTO speed_limit_zone_points (zone_id, point_sequence, latitude, longitude) VALUES
+(1, 1, -6.2235, 106.8385),
+(1, 2, -6.2235, 106.8420),
+(1, 3, -6.2270, 106.8420),
+(1, 4, -6.2270, 106.8385),
+(2, 1, -6.2426, 106.8585),
+(2, 2, -6.2253, 106.8401);
+
+-- Sample drivers
+INSERT INTO drivers (id, name, license_number, phone) VALUES
+('DRV-001', 'Budi Santoso', 'SIM-B2-0001', '081200000001'),
+('DRV-002', 'Siti Rahayu', 'SIM-B2-0002', '081200000002'),
+('DRV-003', 'Agus Setiawan', 'SIM-B2-0003', '081200000003');
@@ -0,0 +1,369 @@
+package gtfs
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Feed holds the parts of a GTFS static feed the fleet system uses
+type Feed struct {
+	Stops     []Stop
+	Routes    []Route
+	Trips     []Trip
+	StopTimes []StopTime
+	Shapes    map[string][]ShapePoint // by shape_id, ordered by sequence
+
+	Calendars     []Calendar
+	CalendarDates []CalendarDate
+}
+
+type Stop struct {
+	ID        string
+	Name      string
+	Latitude  float64
+	Longitude float64
+}
+
+type Route struct {
+	ID        string
+	ShortName string
+	LongName  string
+	Color     string
+}
+
+type Trip struct {
+	ID          string
+	RouteID     string
+	ServiceID   string
+	Headsign    string
+	DirectionID int
+	ShapeID     string
+}
+
+// StopTime times are seconds after midnight of the service day and may
+// exceed 24h for trips running past midnight. Nil means no scheduled time.
+type StopTime struct {
+	TripID        string
+	StopID        string
+	StopSequence  int
+	ArrivalTime   *int
+	DepartureTime *int
+}
+
+// Calendar is the weekly pattern of a service between two dates, both
+// included. Weekdays is indexed by time.Weekday.
+type Calendar struct {
+	ServiceID string
+	Weekdays  [7]bool
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+// CalendarDate adds (ExceptionType 1) or removes (ExceptionType 2) a service
+// on a single date
+type CalendarDate struct {
+	ServiceID     string
+	Date          time.Time
+	ExceptionType int
+}
+
+type ShapePoint struct {
+	Latitude  float64
+	Longitude float64
+	Sequence  int
+}
+
+// Load reads a GTFS zip file. stops.txt, routes.txt, trips.txt and
+// stop_times.txt are required, shapes.txt, calendar.txt and
+// calendar_dates.txt are optional.
+func Load(path string) (*Feed, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GTFS zip: %v", err)
+	}
+	defer archive.Close()
+
+	feed := &Feed{Shapes: make(map[string][]ShapePoint)}
+
+	files := []struct {
+		name     string
+		required bool
+		parse    func(row map[string]string) error
+	}{
+		{"stops.txt", true, feed.parseStop},
+		{"routes.txt", true, feed.parseRoute},
+		{"trips.txt", true, feed.parseTrip},
+		{"stop_times.txt", true, feed.parseStopTime},
+		{"shapes.txt", false, feed.parseShapePoint},
+		{"calendar.txt", false, feed.parseCalendar},
+		{"calendar_dates.txt", false, feed.parseCalendarDate},
+	}
+
+	for _, file := range files {
+		f := findFile(&archive.Reader, file.name)
+		if f == nil {
+			if file.required {
+				return nil, fmt.Errorf("%s is missing from the feed", file.name)
+			}
+			continue
+		}
+
+		if err := readCSV(f, file.parse); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", file.name, err)
+		}
+	}
+
+	for _, points := range feed.Shapes {
+		sort.Slice(points, func(i, j int) bool { return points[i].Sequence < points[j].Sequence })
+	}
+
+	return feed, nil
+}
+
+func (f *Feed) parseStop(row map[string]string) error {
+	// Only stops and platforms, stations and entrances are never served directly
+	if t := row["location_type"]; t != "" && t != "0" {
+		return nil
+	}
+
+	lat, err := strconv.ParseFloat(row["stop_lat"], 64)
+	if err != nil {
+		return fmt.Errorf("stop %s: invalid stop_lat: %v", row["stop_id"], err)
+	}
+	lon, err := strconv.ParseFloat(row["stop_lon"], 64)
+	if err != nil {
+		return fmt.Errorf("stop %s: invalid stop_lon: %v", row["stop_id"], err)
+	}
+
+	f.Stops = append(f.Stops, Stop{
+		ID:        row["stop_id"],
+		Name:      row["stop_name"],
+		Latitude:  lat,
+		Longitude: lon,
+	})
+	return nil
+}
+
+func (f *Feed) parseRoute(row map[string]string) error {
+	f.Routes = append(f.Routes, Route{
+		ID:        row["route_id"],
+		ShortName: row["route_short_name"],
+		LongName:  row["route_long_name"],
+		Color:     row["route_color"],
+	})
+	return nil
+}
+
+func (f *Feed) parseTrip(row map[string]string) error {
+	direction := 0
+	if d := row["direction_id"]; d != "" {
+		var err error
+		if direction, err = strconv.Atoi(d); err != nil {
+			return fmt.Errorf("trip %s: invalid direction_id: %v", row["trip_id"], err)
+		}
+	}
+
+	f.Trips = append(f.Trips, Trip{
+		ID:          row["trip_id"],
+		RouteID:     row["route_id"],
+		ServiceID:   row["service_id"],
+		Headsign:    row["trip_headsign"],
+		DirectionID: direction,
+		ShapeID:     row["shape_id"],
+	})
+	return nil
+}
+
+func (f *Feed) parseStopTime(row map[string]string) error {
+	sequence, err := strconv.Atoi(row["stop_sequence"])
+	if err != nil {
+		return fmt.Errorf("trip %s: invalid stop_sequence: %v", row["trip_id"], err)
+	}
+
+	arrival, err := ParseTime(row["arrival_time"])
+	if err != nil {
+		return fmt.Errorf("trip %s: %v", row["trip_id"], err)
+	}
+	departure, err := ParseTime(row["departure_time"])
+	if err != nil {
+		return fmt.Errorf("trip %s: %v", row["trip_id"], err)
+	}
+
+	f.StopTimes = append(f.StopTimes, StopTime{
+		TripID:        row["trip_id"],
+		StopID:        row["stop_id"],
+		StopSequence:  sequence,
+		ArrivalTime:   arrival,
+		DepartureTime: departure,
+	})
+	return nil
+}
+
+func (f *Feed) parseShapePoint(row map[string]string) error {
+	lat, err := strconv.ParseFloat(row["shape_pt_lat"], 64)
+	if err != nil {
+		return fmt.Errorf("shape %s: invalid shape_pt_lat: %v", row["shape_id"], err)
+	}
+	lon, err := strconv.ParseFloat(row["shape_pt_lon"], 64)
+	if err != nil {
+		return fmt.Errorf("shape %s: invalid shape_pt_lon: %v", row["shape_id"], err)
+	}
+	sequence, err := strconv.Atoi(row["shape_pt_sequence"])
+	if err != nil {
+		return fmt.Errorf("shape %s: invalid shape_pt_sequence: %v", row["shape_id"], err)
+	}
+
+	shapeID := row["shape_id"]
+	f.Shapes[shapeID] = append(f.Shapes[shapeID], ShapePoint{
+		Latitude:  lat,
+		Longitude: lon,
+		Sequence:  sequence,
+	})
+	return nil
+}
+
+func (f *Feed) parseCalendar(row map[string]string) error {
+	calendar := Calendar{ServiceID: row["service_id"]}
+
+	days := []struct {
+		column  string
+		weekday time.Weekday
+	}{
+		{"monday", time.Monday}, {"tuesday", time.Tuesday}, {"wednesday", time.Wednesday},
+		{"thursday", time.Thursday}, {"friday", time.Friday}, {"saturday", time.Saturday},
+		{"sunday", time.Sunday},
+	}
+	for _, day := range days {
+		switch row[day.column] {
+		case "1":
+			calendar.Weekdays[day.weekday] = true
+		case "0":
+		default:
+			return fmt.Errorf("service %s: invalid %s %q", calendar.ServiceID, day.column, row[day.column])
+		}
+	}
+
+	var err error
+	if calendar.StartDate, err = ParseDate(row["start_date"]); err != nil {
+		return fmt.Errorf("service %s: %v", calendar.ServiceID, err)
+	}
+	if calendar.EndDate, err = ParseDate(row["end_date"]); err != nil {
+		return fmt.Errorf("service %s: %v", calendar.ServiceID, err)
+	}
+
+	f.Calendars = append(f.Calendars, calendar)
+	return nil
+}
+
+func (f *Feed) parseCalendarDate(row map[string]string) error {
+	date, err := ParseDate(row["date"])
+	if err != nil {
+		return fmt.Errorf("service %s: %v", row["service_id"], err)
+	}
+
+	exception, err := strconv.Atoi(row["exception_type"])
+	if err != nil || (exception != 1 && exception != 2) {
+		return fmt.Errorf("service %s: invalid exception_type %q", row["service_id"], row["exception_type"])
+	}
+
+	f.CalendarDates = append(f.CalendarDates, CalendarDate{
+		ServiceID:     row["service_id"],
+		Date:          date,
+		ExceptionType: exception,
+	})
+	return nil
+}
+
+// ParseDate parses a GTFS YYYYMMDD date
+func ParseDate(value string) (time.Time, error) {
+	date, err := time.Parse("20060102", strings.TrimSpace(value))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	return date, nil
+}
+
+// ParseTime parses a GTFS HH:MM:SS time into seconds after midnight.
+// An empty value returns nil.
+func ParseTime(value string) (*int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid time %q", value)
+	}
+
+	var hms [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid time %q", value)
+		}
+		hms[i] = n
+	}
+
+	seconds := hms[0]*3600 + hms[1]*60 + hms[2]
+	return &seconds, nil
+}
+
+func findFile(archive *zip.Reader, name string) *zip.File {
+	for _, f := range archive.File {
+		// Some feeds are zipped with a top level folder
+		if f.Name == name || strings.HasSuffix(f.Name, "/"+name) {
+			return f
+		}
+	}
+	return nil
+}
+
+// readCSV calls parse for every row, keyed by the header columns
+func readCSV(f *zip.File, parse func(row map[string]string) error) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	reader := csv.NewReader(rc)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+
+	row := make(map[string]string, len(header))
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for i, column := range header {
+			if i < len(record) {
+				row[column] = strings.TrimSpace(record[i])
+			} else {
+				row[column] = ""
+			}
+		}
+
+		if err := parse(row); err != nil {
+			return err
+		}
+	}
+}
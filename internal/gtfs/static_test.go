@@ -0,0 +1,135 @@
+package gtfs
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFeed zips the given files into a GTFS feed with the required files
+func writeFeed(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	required := map[string]string{
+		"stops.txt":      "stop_id,stop_name,stop_lat,stop_lon\nS1,Halte 1,-6.2,106.8\n",
+		"routes.txt":     "route_id,route_short_name,route_long_name,route_color\nR1,1,Blok M - Kota,\n",
+		"trips.txt":      "route_id,service_id,trip_id\nR1,WD,T1\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\nT1,08:00:00,08:00:30,S1,1\n",
+	}
+	for name, content := range files {
+		required[name] = content
+	}
+
+	path := filepath.Join(t.TempDir(), "gtfs.zip")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for name, content := range required {
+		entry, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entry.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadCalendars(t *testing.T) {
+	path := writeFeed(t, map[string]string{
+		"calendar.txt": "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\n" +
+			"WD,1,1,1,1,1,0,0,20260101,20261231\n" +
+			"WE,0,0,0,0,0,1,1,20260101,20261231\n",
+		"calendar_dates.txt": "service_id,date,exception_type\n" +
+			"WD,20260817,2\n" +
+			"WE,20260817,1\n",
+	})
+
+	feed, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if len(feed.Calendars) != 2 {
+		t.Fatalf("got %d calendars, want 2", len(feed.Calendars))
+	}
+	weekdays := feed.Calendars[0]
+	if weekdays.ServiceID != "WD" || !weekdays.Weekdays[time.Monday] || weekdays.Weekdays[time.Sunday] {
+		t.Errorf("unexpected weekday calendar %+v", weekdays)
+	}
+	if want := time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC); !weekdays.EndDate.Equal(want) {
+		t.Errorf("end date = %s, want %s", weekdays.EndDate, want)
+	}
+	if weekend := feed.Calendars[1]; !weekend.Weekdays[time.Saturday] || weekend.Weekdays[time.Friday] {
+		t.Errorf("unexpected weekend calendar %+v", weekend)
+	}
+
+	want := []CalendarDate{
+		{ServiceID: "WD", Date: time.Date(2026, 8, 17, 0, 0, 0, 0, time.UTC), ExceptionType: 2},
+		{ServiceID: "WE", Date: time.Date(2026, 8, 17, 0, 0, 0, 0, time.UTC), ExceptionType: 1},
+	}
+	if len(feed.CalendarDates) != len(want) {
+		t.Fatalf("got %d calendar dates, want %d", len(feed.CalendarDates), len(want))
+	}
+	for i, date := range feed.CalendarDates {
+		if date.ServiceID != want[i].ServiceID || !date.Date.Equal(want[i].Date) || date.ExceptionType != want[i].ExceptionType {
+			t.Errorf("calendar date %d = %+v, want %+v", i, date, want[i])
+		}
+	}
+}
+
+func TestLoadCalendarsOptional(t *testing.T) {
+	feed, err := Load(writeFeed(t, nil))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(feed.Calendars) != 0 || len(feed.CalendarDates) != 0 {
+		t.Errorf("got %d calendars and %d dates, want none", len(feed.Calendars), len(feed.CalendarDates))
+	}
+}
+
+func TestLoadInvalidCalendars(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name: "invalid weekday flag",
+			files: map[string]string{"calendar.txt": "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\n" +
+				"WD,yes,1,1,1,1,0,0,20260101,20261231\n"},
+			wantErr: `invalid monday "yes"`,
+		},
+		{
+			name: "invalid start date",
+			files: map[string]string{"calendar.txt": "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\n" +
+				"WD,1,1,1,1,1,0,0,2026-01-01,20261231\n"},
+			wantErr: `invalid date "2026-01-01"`,
+		},
+		{
+			name:    "invalid exception type",
+			files:   map[string]string{"calendar_dates.txt": "service_id,date,exception_type\nWD,20260817,3\n"},
+			wantErr: `invalid exception_type "3"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeFeed(t, tt.files))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/repositories"
+	"github.com/asaaitika/fleetmgm-tst/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Reports cover the last week unless a range is given
+const defaultReportWindow = 7 * 24 * time.Hour
+
+type AnalyticsHandler struct {
+	stopVisitRepo *repositories.StopVisitRepository
+	speedingRepo  *repositories.SpeedingRepository
+	idleRepo      *repositories.IdleRepository
+	harshRepo     *repositories.HarshEventRepository
+	onTimeEarly   time.Duration
+	onTimeLate    time.Duration
+	timezone      string
+}
+
+func NewAnalyticsHandler(stopVisitRepo *repositories.StopVisitRepository, speedingRepo *repositories.SpeedingRepository,
+	idleRepo *repositories.IdleRepository, harshRepo *repositories.HarshEventRepository,
+	onTimeEarly, onTimeLate time.Duration, timezone string) *AnalyticsHandler {
+	return &AnalyticsHandler{
+		stopVisitRepo: stopVisitRepo,
+		speedingRepo:  speedingRepo,
+		idleRepo:      idleRepo,
+		harshRepo:     harshRepo,
+		onTimeEarly:   onTimeEarly,
+		onTimeLate:    onTimeLate,
+		timezone:      timezone,
+	}
+}
+
+// reportRange reads ?from=xxx&to=xxx, defaulting to the last week
+func reportRange(c *gin.Context) (int64, int64, bool) {
+	var request struct {
+		From int64 `form:"from"`
+		To   int64 `form:"to"`
+	}
+	if err := c.ShouldBindQuery(&request); err != nil {
+		return 0, 0, false
+	}
+
+	if request.To == 0 {
+		request.To = time.Now().Unix()
+	}
+	if request.From == 0 {
+		request.From = request.To - int64(defaultReportWindow.Seconds())
+	}
+
+	return request.From, request.To, request.From <= request.To
+}
+
+// GetOnTimePerformance endpoint: GET /analytics/on-time-performance?group_by=route|stop|day&from=xxx&to=xxx&route_id=xxx
+func (h *AnalyticsHandler) GetOnTimePerformance(c *gin.Context) {
+	from, to, ok := reportRange(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "from and to must be unix timestamps, from before to",
+		})
+		return
+	}
+
+	groupBy := c.DefaultQuery("group_by", "route")
+	if groupBy != "route" && groupBy != "stop" && groupBy != "day" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "group_by must be route, stop or day",
+		})
+		return
+	}
+
+	early := int(h.onTimeEarly.Seconds())
+	late := int(h.onTimeLate.Seconds())
+
+	stats, err := h.stopVisitRepo.OnTimePerformance(groupBy, from, to, c.Query("route_id"), early, late, h.timezone)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get on-time performance",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"group_by":                groupBy,
+		"from":                    from,
+		"to":                      to,
+		"early_tolerance_seconds": early,
+		"late_tolerance_seconds":  late,
+		"count":                   len(stats),
+		"on_time_performance":     stats,
+	})
+}
+
+// GetSpeeding endpoint: GET /analytics/speeding?group_by=vehicle|driver&from=xxx&to=xxx
+func (h *AnalyticsHandler) GetSpeeding(c *gin.Context) {
+	from, to, ok := reportRange(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "from and to must be unix timestamps, from before to",
+		})
+		return
+	}
+
+	groupBy := c.DefaultQuery("group_by", "vehicle")
+	if groupBy != "vehicle" && groupBy != "driver" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "group_by must be vehicle or driver",
+		})
+		return
+	}
+
+	stats, err := h.speedingRepo.SpeedingReport(groupBy, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get speeding report",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"group_by": groupBy,
+		"from":     from,
+		"to":       to,
+		"count":    len(stats),
+		"speeding": stats,
+	})
+}
+
+// GetIdle endpoint: GET /analytics/idle?from=xxx&to=xxx&vehicle_id=xxx
+func (h *AnalyticsHandler) GetIdle(c *gin.Context) {
+	from, to, ok := reportRange(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "from and to must be unix timestamps, from before to",
+		})
+		return
+	}
+
+	stats, err := h.idleRepo.IdleReport(from, to, c.Query("vehicle_id"), h.timezone)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get idle report",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":  from,
+		"to":    to,
+		"count": len(stats),
+		"idle":  stats,
+	})
+}
+
+// GetSafetyScores endpoint: GET /analytics/safety-scores?group_by=vehicle|driver&from=xxx&to=xxx
+func (h *AnalyticsHandler) GetSafetyScores(c *gin.Context) {
+	from, to, ok := reportRange(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "from and to must be unix timestamps, from before to",
+		})
+		return
+	}
+
+	groupBy := c.DefaultQuery("group_by", "driver")
+	if groupBy != "vehicle" && groupBy != "driver" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "group_by must be vehicle or driver",
+		})
+		return
+	}
+
+	scores, err := h.harshRepo.SafetyCounts(groupBy, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get safety scores",
+		})
+		return
+	}
+	services.ApplySafetyScore(scores)
+
+	c.JSON(http.StatusOK, gin.H{
+		"group_by":      groupBy,
+		"from":          from,
+		"to":            to,
+		"count":         len(scores),
+		"safety_scores": scores,
+	})
+}
+
+// GetHarshEvents endpoint: GET /vehicles/{vehicle_id}/harsh-events?start=xxx&end=xxx
+func (h *AnalyticsHandler) GetHarshEvents(c *gin.Context) {
+	vehicleID := c.Param("vehicle_id")
+
+	var request struct {
+		Start int64 `form:"start" binding:"required"`
+		End   int64 `form:"end" binding:"required"`
+	}
+
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "start and end timestamps are required",
+		})
+		return
+	}
+
+	if request.Start > request.End {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "start time must be before end time",
+		})
+		return
+	}
+
+	events, err := h.harshRepo.GetVehicleHarshEvents(vehicleID, request.Start, request.End)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get harsh driving events",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"vehicle_id": vehicleID,
+		"count":      len(events),
+		"events":     events,
+	})
+}
+
+// GetStopVisits endpoint: GET /vehicles/{vehicle_id}/stop-visits?start=xxx&end=xxx
+func (h *AnalyticsHandler) GetStopVisits(c *gin.Context) {
+	vehicleID := c.Param("vehicle_id")
+
+	var request struct {
+		Start int64 `form:"start" binding:"required"`
+		End   int64 `form:"end" binding:"required"`
+	}
+
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "start and end timestamps are required",
+		})
+		return
+	}
+
+	if request.Start > request.End {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "start time must be before end time",
+		})
+		return
+	}
+
+	visits, err := h.stopVisitRepo.GetVehicleVisits(vehicleID, request.Start, request.End)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get stop visits",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"vehicle_id": vehicleID,
+		"count":      len(visits),
+		"visits":     visits,
+	})
+}
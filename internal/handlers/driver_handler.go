@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/asaaitika/fleetmgm-tst/internal/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+type DriverHandler struct {
+	repo *repositories.DriverRepository
+}
+
+func NewDriverHandler(repo *repositories.DriverRepository) *DriverHandler {
+	return &DriverHandler{repo: repo}
+}
+
+type driverRequest struct {
+	ID            string `json:"id"`
+	Name          string `json:"name" binding:"required"`
+	LicenseNumber string `json:"license_number"`
+	Phone         string `json:"phone"`
+	Active        *bool  `json:"active"`
+}
+
+func (r *driverRequest) driver(id string) *models.Driver {
+	driver := &models.Driver{
+		ID:            id,
+		Name:          r.Name,
+		LicenseNumber: r.LicenseNumber,
+		Phone:         r.Phone,
+		Active:        true,
+	}
+	if r.Active != nil {
+		driver.Active = *r.Active
+	}
+	return driver
+}
+
+// ListDrivers endpoint: GET /drivers
+func (h *DriverHandler) ListDrivers(c *gin.Context) {
+	drivers, err := h.repo.ListDrivers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get drivers",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"count":   len(drivers),
+		"drivers": drivers,
+	})
+}
+
+// GetDriver endpoint: GET /drivers/{driver_id}
+func (h *DriverHandler) GetDriver(c *gin.Context) {
+	driver, err := h.repo.GetDriver(c.Param("driver_id"))
+	if err != nil {
+		routeError(c, err, "Failed to get driver")
+		return
+	}
+
+	c.JSON(http.StatusOK, driver)
+}
+
+// CreateDriver endpoint: POST /drivers
+func (h *DriverHandler) CreateDriver(c *gin.Context) {
+	var request driverRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.ID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "id and name are required",
+		})
+		return
+	}
+
+	driver := request.driver(request.ID)
+	if err := h.repo.CreateDriver(driver); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "Driver already exists",
+			})
+			return
+		}
+
+		routeError(c, err, "Failed to create driver")
+		return
+	}
+
+	c.JSON(http.StatusCreated, driver)
+}
+
+// UpdateDriver endpoint: PUT /drivers/{driver_id}
+func (h *DriverHandler) UpdateDriver(c *gin.Context) {
+	var request driverRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "name is required",
+		})
+		return
+	}
+
+	driver := request.driver(c.Param("driver_id"))
+	if err := h.repo.UpdateDriver(driver); err != nil {
+		routeError(c, err, "Failed to update driver")
+		return
+	}
+
+	c.JSON(http.StatusOK, driver)
+}
+
+// DeleteDriver endpoint: DELETE /drivers/{driver_id}
+func (h *DriverHandler) DeleteDriver(c *gin.Context) {
+	if err := h.repo.DeleteDriver(c.Param("driver_id")); err != nil {
+		routeError(c, err, "Failed to delete driver")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// Login endpoint: POST /drivers/{driver_id}/login
+// Body: {"vehicle_id": "B1234XYZ", "timestamp": 1700000000}, timestamp defaults to now
+func (h *DriverHandler) Login(c *gin.Context) {
+	var request struct {
+		VehicleID string `json:"vehicle_id" binding:"required"`
+		Timestamp int64  `json:"timestamp"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "vehicle_id is required",
+		})
+		return
+	}
+	if request.Timestamp == 0 {
+		request.Timestamp = time.Now().Unix()
+	}
+
+	driver, err := h.repo.GetDriver(c.Param("driver_id"))
+	if err != nil {
+		routeError(c, err, "Failed to get driver")
+		return
+	}
+	if !driver.Active {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "driver is not active",
+		})
+		return
+	}
+
+	shift := &models.DriverShift{
+		DriverID:  driver.ID,
+		VehicleID: request.VehicleID,
+		StartedAt: request.Timestamp,
+		Source:    "api",
+	}
+	if err := h.repo.StartShift(shift); err != nil {
+		routeError(c, err, "Failed to start shift")
+		return
+	}
+
+	c.JSON(http.StatusCreated, shift)
+}
+
+// Logout endpoint: POST /drivers/{driver_id}/logout
+// Body (optional): {"timestamp": 1700000000}, timestamp defaults to now
+func (h *DriverHandler) Logout(c *gin.Context) {
+	var request struct {
+		Timestamp int64 `json:"timestamp"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "timestamp must be a unix timestamp",
+			})
+			return
+		}
+	}
+	if request.Timestamp == 0 {
+		request.Timestamp = time.Now().Unix()
+	}
+
+	shift, err := h.repo.EndShift(c.Param("driver_id"), "", request.Timestamp)
+	if err != nil {
+		routeError(c, err, "Failed to end shift")
+		return
+	}
+
+	c.JSON(http.StatusOK, shift)
+}
+
+// GetDriverShifts endpoint: GET /drivers/{driver_id}/shifts?start=xxx&end=xxx
+func (h *DriverHandler) GetDriverShifts(c *gin.Context) {
+	driverID := c.Param("driver_id")
+
+	var request struct {
+		Start int64 `form:"start" binding:"required"`
+		End   int64 `form:"end" binding:"required"`
+	}
+
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "start and end timestamps are required",
+		})
+		return
+	}
+
+	if request.Start > request.End {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "start time must be before end time",
+		})
+		return
+	}
+
+	shifts, err := h.repo.GetDriverShifts(driverID, request.Start, request.End)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get shifts",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"driver_id": driverID,
+		"count":     len(shifts),
+		"shifts":    shifts,
+	})
+}
+
+// GetVehicleDriver endpoint: GET /vehicles/{vehicle_id}/driver?at=xxx
+// Returns the shift on duty at the given timestamp, now by default
+func (h *DriverHandler) GetVehicleDriver(c *gin.Context) {
+	var request struct {
+		At int64 `form:"at"`
+	}
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "at must be a unix timestamp",
+		})
+		return
+	}
+	if request.At == 0 {
+		request.At = time.Now().Unix()
+	}
+
+	shift, err := h.repo.GetShiftAt(c.Param("vehicle_id"), request.At)
+	if err != nil {
+		routeError(c, err, "Failed to get vehicle driver")
+		return
+	}
+
+	c.JSON(http.StatusOK, shift)
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/repositories"
+	"github.com/asaaitika/fleetmgm-tst/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+type ETAHandler struct {
+	eta         *services.ETAService
+	vehicleRepo *repositories.VehicleRepository
+}
+
+func NewETAHandler(eta *services.ETAService, vehicleRepo *repositories.VehicleRepository) *ETAHandler {
+	return &ETAHandler{eta: eta, vehicleRepo: vehicleRepo}
+}
+
+// GetVehicleETA endpoint: GET /vehicles/{vehicle_id}/eta
+func (h *ETAHandler) GetVehicleETA(c *gin.Context) {
+	eta, err := h.eta.VehicleETA(c.Param("vehicle_id"), time.Now())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Vehicle has no route or no recent location",
+			})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to predict arrivals",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, eta)
+}
+
+// GetStopArrivals endpoint: GET /stops/{stop_id}/arrivals
+// stop_id is the GTFS stop_id or the geofence area ID
+func (h *ETAHandler) GetStopArrivals(c *gin.Context) {
+	area, err := h.vehicleRepo.GetGeofenceAreaByStopID(c.Param("stop_id"))
+	if err != nil {
+		routeError(c, err, "Failed to get stop")
+		return
+	}
+
+	arrivals, err := h.eta.StopArrivals(area.ID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to predict arrivals",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"stop_id":  c.Param("stop_id"),
+		"name":     area.Name,
+		"count":    len(arrivals),
+		"arrivals": arrivals,
+	})
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	gtfsrt "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"github.com/asaaitika/fleetmgm-tst/internal/services"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+type GTFSRealtimeHandler struct {
+	service *services.GTFSRealtimeService
+}
+
+func NewGTFSRealtimeHandler(service *services.GTFSRealtimeService) *GTFSRealtimeHandler {
+	return &GTFSRealtimeHandler{service: service}
+}
+
+// VehiclePositions endpoint: GET /gtfs-rt/vehicle-positions.pb
+func (h *GTFSRealtimeHandler) VehiclePositions(c *gin.Context) {
+	h.serve(c, h.service.VehiclePositions)
+}
+
+// TripUpdates endpoint: GET /gtfs-rt/trip-updates.pb
+func (h *GTFSRealtimeHandler) TripUpdates(c *gin.Context) {
+	h.serve(c, h.service.TripUpdates)
+}
+
+// serve writes the feed as protobuf, or as readable JSON with ?debug=json
+func (h *GTFSRealtimeHandler) serve(c *gin.Context, build func(now time.Time) (*gtfsrt.FeedMessage, error)) {
+	feed, err := build(time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to build feed",
+		})
+		return
+	}
+
+	if c.Query("debug") == "json" {
+		data, err := protojson.MarshalOptions{Multiline: true, UseProtoNames: true}.Marshal(feed)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to encode feed",
+			})
+			return
+		}
+		c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+		return
+	}
+
+	data, err := proto.Marshal(feed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to encode feed",
+		})
+		return
+	}
+	c.Data(http.StatusOK, "application/x-protobuf", data)
+}
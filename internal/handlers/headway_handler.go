@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/repositories"
+	"github.com/asaaitika/fleetmgm-tst/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+type HeadwayHandler struct {
+	routeRepo     *repositories.RouteRepository
+	stopVisitRepo *repositories.StopVisitRepository
+	policy        services.HeadwayPolicy
+	window        time.Duration
+}
+
+func NewHeadwayHandler(routeRepo *repositories.RouteRepository, stopVisitRepo *repositories.StopVisitRepository,
+	policy services.HeadwayPolicy, window time.Duration) *HeadwayHandler {
+	return &HeadwayHandler{
+		routeRepo:     routeRepo,
+		stopVisitRepo: stopVisitRepo,
+		policy:        policy,
+		window:        window,
+	}
+}
+
+// GetRouteHeadways endpoint: GET /routes/{route_id}/headways
+// A stop is a gap as well when no vehicle arrived for longer than the gap
+// threshold since the last one.
+func (h *HeadwayHandler) GetRouteHeadways(c *gin.Context) {
+	routeID := c.Param("route_id")
+
+	if _, err := h.routeRepo.GetRoute(routeID); err != nil {
+		routeError(c, err, "Failed to get route")
+		return
+	}
+
+	now := time.Now()
+	headways, err := h.stopVisitRepo.GetRouteHeadways(routeID, now.Add(-h.window).Unix())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get headways",
+		})
+		return
+	}
+
+	for i := range headways {
+		stop := &headways[i]
+		if stop.LastArrival == nil {
+			continue
+		}
+
+		since := max(0, now.Unix()-*stop.LastArrival)
+		stop.SinceLastSeconds = &since
+
+		if stop.PreviousArrival != nil {
+			headway := *stop.LastArrival - *stop.PreviousArrival
+			stop.HeadwaySeconds = &headway
+			stop.Status = h.policy.Status(time.Duration(headway) * time.Second)
+		}
+		if time.Duration(since)*time.Second > h.policy.Gap {
+			stop.Status = services.HeadwayGap
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"route_id":                   routeID,
+		"bunching_threshold_seconds": int64(h.policy.Bunching.Seconds()),
+		"gap_threshold_seconds":      int64(h.policy.Gap.Seconds()),
+		"stops":                      headways,
+	})
+}
@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/asaaitika/fleetmgm-tst/internal/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+type RouteHandler struct {
+	repo     *repositories.RouteRepository
+	gtfsRepo *repositories.GTFSRepository
+}
+
+func NewRouteHandler(repo *repositories.RouteRepository, gtfsRepo *repositories.GTFSRepository) *RouteHandler {
+	return &RouteHandler{repo: repo, gtfsRepo: gtfsRepo}
+}
+
+type routeRequest struct {
+	ID        string `json:"id"`
+	ShortName string `json:"short_name" binding:"required"`
+	LongName  string `json:"long_name"`
+	Color     string `json:"color" binding:"omitempty,hexadecimal,len=6"`
+}
+
+// ListRoutes endpoint: GET /routes
+func (h *RouteHandler) ListRoutes(c *gin.Context) {
+	routes, err := h.repo.ListRoutes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get routes",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"count":  len(routes),
+		"routes": routes,
+	})
+}
+
+// GetRoute endpoint: GET /routes/{route_id}
+func (h *RouteHandler) GetRoute(c *gin.Context) {
+	route, err := h.repo.GetRoute(c.Param("route_id"))
+	if err != nil {
+		routeError(c, err, "Failed to get route")
+		return
+	}
+
+	if route.Stops, err = h.repo.GetRouteStops(route.ID); err != nil {
+		routeError(c, err, "Failed to get route stops")
+		return
+	}
+
+	if route.Shape, err = h.repo.GetRouteShape(route.ID); err != nil {
+		routeError(c, err, "Failed to get route shape")
+		return
+	}
+
+	c.JSON(http.StatusOK, route)
+}
+
+// CreateRoute endpoint: POST /routes
+func (h *RouteHandler) CreateRoute(c *gin.Context) {
+	var request routeRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.ID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "id and short_name are required, color must be a 6 digit hex value",
+		})
+		return
+	}
+
+	route := &models.Route{
+		ID:        request.ID,
+		ShortName: request.ShortName,
+		LongName:  request.LongName,
+		Color:     request.Color,
+	}
+
+	if err := h.repo.CreateRoute(route); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "Route already exists",
+			})
+			return
+		}
+
+		routeError(c, err, "Failed to create route")
+		return
+	}
+
+	c.JSON(http.StatusCreated, route)
+}
+
+// UpdateRoute endpoint: PUT /routes/{route_id}
+func (h *RouteHandler) UpdateRoute(c *gin.Context) {
+	var request routeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "short_name is required, color must be a 6 digit hex value",
+		})
+		return
+	}
+
+	route := &models.Route{
+		ID:        c.Param("route_id"),
+		ShortName: request.ShortName,
+		LongName:  request.LongName,
+		Color:     request.Color,
+	}
+
+	if err := h.repo.UpdateRoute(route); err != nil {
+		routeError(c, err, "Failed to update route")
+		return
+	}
+
+	c.JSON(http.StatusOK, route)
+}
+
+// DeleteRoute endpoint: DELETE /routes/{route_id}
+func (h *RouteHandler) DeleteRoute(c *gin.Context) {
+	if err := h.repo.DeleteRoute(c.Param("route_id")); err != nil {
+		routeError(c, err, "Failed to delete route")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ReplaceStops endpoint: PUT /routes/{route_id}/stops
+// Body: {"geofence_area_ids": [1, 2, 3]} in travel order
+func (h *RouteHandler) ReplaceStops(c *gin.Context) {
+	routeID := c.Param("route_id")
+
+	var request struct {
+		GeofenceAreaIDs []int `json:"geofence_area_ids" binding:"required,min=2"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "geofence_area_ids must list at least 2 stops",
+		})
+		return
+	}
+
+	if _, err := h.repo.GetRoute(routeID); err != nil {
+		routeError(c, err, "Failed to get route")
+		return
+	}
+
+	if err := h.repo.ReplaceRouteStops(routeID, request.GeofenceAreaIDs); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Unknown geofence area",
+			})
+			return
+		}
+
+		routeError(c, err, "Failed to update route stops")
+		return
+	}
+
+	stops, err := h.repo.GetRouteStops(routeID)
+	if err != nil {
+		routeError(c, err, "Failed to get route stops")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"route_id": routeID,
+		"stops":    stops,
+	})
+}
+
+// ReplaceShape endpoint: PUT /routes/{route_id}/shape
+// Body: {"points": [{"latitude": -6.2, "longitude": 106.8}, ...]}
+func (h *RouteHandler) ReplaceShape(c *gin.Context) {
+	routeID := c.Param("route_id")
+
+	var request struct {
+		Points []models.ShapePoint `json:"points" binding:"required,min=2"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "points must contain at least 2 coordinates",
+		})
+		return
+	}
+
+	if _, err := h.repo.GetRoute(routeID); err != nil {
+		routeError(c, err, "Failed to get route")
+		return
+	}
+
+	if err := h.repo.ReplaceRouteShape(routeID, request.Points); err != nil {
+		routeError(c, err, "Failed to update route shape")
+		return
+	}
+
+	shape, err := h.repo.GetRouteShape(routeID)
+	if err != nil {
+		routeError(c, err, "Failed to get route shape")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"route_id": routeID,
+		"shape":    shape,
+	})
+}
+
+// GetVehicleRoute endpoint: GET /vehicles/{vehicle_id}/route
+func (h *RouteHandler) GetVehicleRoute(c *gin.Context) {
+	assignment, err := h.repo.GetVehicleRoute(c.Param("vehicle_id"))
+	if err != nil {
+		routeError(c, err, "Failed to get vehicle route")
+		return
+	}
+
+	c.JSON(http.StatusOK, assignment)
+}
+
+// AssignVehicle endpoint: PUT /vehicles/{vehicle_id}/route
+// Body: {"route_id": "PR-PL", "trip_id": "PR-PL-0600"}, trip_id is optional
+func (h *RouteHandler) AssignVehicle(c *gin.Context) {
+	var request struct {
+		RouteID string  `json:"route_id" binding:"required"`
+		TripID  *string `json:"trip_id"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "route_id is required",
+		})
+		return
+	}
+
+	if _, err := h.repo.GetRoute(request.RouteID); err != nil {
+		routeError(c, err, "Failed to get route")
+		return
+	}
+
+	if request.TripID != nil {
+		trip, err := h.gtfsRepo.GetTrip(*request.TripID)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && trip.RouteID != request.RouteID) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "trip_id is not a trip of the route",
+			})
+			return
+		}
+		if err != nil {
+			routeError(c, err, "Failed to get trip")
+			return
+		}
+	}
+
+	assignment, err := h.repo.AssignVehicle(c.Param("vehicle_id"), request.RouteID, request.TripID)
+	if err != nil {
+		routeError(c, err, "Failed to assign vehicle")
+		return
+	}
+
+	c.JSON(http.StatusOK, assignment)
+}
+
+// UnassignVehicle endpoint: DELETE /vehicles/{vehicle_id}/route
+func (h *RouteHandler) UnassignVehicle(c *gin.Context) {
+	if err := h.repo.UnassignVehicle(c.Param("vehicle_id")); err != nil {
+		routeError(c, err, "Failed to unassign vehicle")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// routeError maps not found errors to 404 and everything else to 500
+func routeError(c *gin.Context, err error, message string) {
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error": message,
+	})
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/asaaitika/fleetmgm-tst/internal/repositories"
+	"github.com/gin-gonic/gin"
+)
+
+type SpeedLimitZoneHandler struct {
+	repo *repositories.SpeedingRepository
+}
+
+func NewSpeedLimitZoneHandler(repo *repositories.SpeedingRepository) *SpeedLimitZoneHandler {
+	return &SpeedLimitZoneHandler{repo: repo}
+}
+
+// ListZones endpoint: GET /speed-limit-zones
+func (h *SpeedLimitZoneHandler) ListZones(c *gin.Context) {
+	zones, err := h.repo.GetSpeedLimitZones()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get speed limit zones",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"count": len(zones),
+		"zones": zones,
+	})
+}
+
+// CreateZone endpoint: POST /speed-limit-zones
+// Body: {"name": "...", "zone_type": "polygon|corridor", "speed_limit_kmh": 30,
+// "width_meters": 40, "points": [{"latitude": ..., "longitude": ...}]}
+func (h *SpeedLimitZoneHandler) CreateZone(c *gin.Context) {
+	var request struct {
+		Name          string              `json:"name" binding:"required"`
+		ZoneType      string              `json:"zone_type" binding:"required,oneof=polygon corridor"`
+		SpeedLimitKmh float64             `json:"speed_limit_kmh" binding:"required,gt=0"`
+		WidthMeters   float64             `json:"width_meters" binding:"gte=0"`
+		Points        []models.ShapePoint `json:"points" binding:"required,min=2"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "name, zone_type (polygon or corridor), speed_limit_kmh and points are required",
+		})
+		return
+	}
+
+	if request.ZoneType == models.ZonePolygon && len(request.Points) < 3 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "a polygon needs at least 3 points",
+		})
+		return
+	}
+	if request.ZoneType == models.ZoneCorridor && request.WidthMeters <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "a corridor needs width_meters",
+		})
+		return
+	}
+
+	zone := &models.SpeedLimitZone{
+		Name:          request.Name,
+		ZoneType:      request.ZoneType,
+		SpeedLimitKmh: request.SpeedLimitKmh,
+		Points:        request.Points,
+	}
+	if zone.ZoneType == models.ZoneCorridor {
+		zone.WidthMeters = request.WidthMeters
+	}
+
+	if err := h.repo.CreateSpeedLimitZone(zone); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create speed limit zone",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, zone)
+}
+
+// DeleteZone endpoint: DELETE /speed-limit-zones/{zone_id}
+func (h *SpeedLimitZoneHandler) DeleteZone(c *gin.Context) {
+	zoneID, err := strconv.Atoi(c.Param("zone_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "zone_id must be a number",
+		})
+		return
+	}
+
+	if err := h.repo.DeleteSpeedLimitZone(zoneID); err != nil {
+		routeError(c, err, "Failed to delete speed limit zone")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -2,17 +2,59 @@ package handlers
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
 	"github.com/asaaitika/fleetmgm-tst/internal/repositories"
+	"github.com/asaaitika/fleetmgm-tst/internal/services"
 	"github.com/gin-gonic/gin"
 )
 
 type VehicleHandler struct {
-	repo *repositories.VehicleRepository
+	repo           *repositories.VehicleRepository
+	presence       services.PresencePolicy
+	positionSource string
 }
 
-func NewVehicleHandler(repo *repositories.VehicleRepository) *VehicleHandler {
-	return &VehicleHandler{repo: repo}
+func NewVehicleHandler(repo *repositories.VehicleRepository, presence services.PresencePolicy, positionSource string) *VehicleHandler {
+	return &VehicleHandler{repo: repo, presence: presence, positionSource: positionSource}
+}
+
+// source returns the position source requested with ?source=raw|smoothed,
+// or the configured default
+func (h *VehicleHandler) source(c *gin.Context) (string, bool) {
+	switch source := c.DefaultQuery("source", h.positionSource); source {
+	case models.PositionRaw, models.PositionSmoothed:
+		return source, true
+	default:
+		return "", false
+	}
+}
+
+// locationResponse builds the JSON of one location using the given position source
+func locationResponse(loc *models.VehicleLocation, source string) gin.H {
+	lat, lon := loc.Position(source)
+
+	response := gin.H{
+		"vehicle_id": loc.VehicleID,
+		"latitude":   lat,
+		"longitude":  lon,
+		"timestamp":  loc.Timestamp,
+		"quality":    loc.Quality,
+	}
+	// Raw positions come with the speed reported by the device
+	speed := loc.SmoothedSpeedKmh
+	if loc.SpeedKmh != nil && (source == models.PositionRaw || speed == nil) {
+		speed = loc.SpeedKmh
+	}
+	if speed != nil {
+		response["speed_kmh"] = *speed
+	}
+	if loc.SmoothedHeading != nil {
+		response["heading"] = *loc.SmoothedHeading
+	}
+
+	return response
 }
 
 // GetLastLocation endpoint: GET /vehicles/{vehicle_id}/location
@@ -26,6 +68,14 @@ func (h *VehicleHandler) GetLastLocation(c *gin.Context) {
 		return
 	}
 
+	source, ok := h.source(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "source must be raw or smoothed",
+		})
+		return
+	}
+
 	location, err := h.repo.GetLastLocation(vehicleID)
 	if err != nil {
 		if err.Error() == "sql: no rows in result set" {
@@ -41,12 +91,13 @@ func (h *VehicleHandler) GetLastLocation(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"vehicle_id": location.VehicleID,
-		"latitude":   location.Latitude,
-		"longitude":  location.Longitude,
-		"timestamp":  location.Timestamp,
-	})
+	status, age := h.presence.Status(location.Timestamp, time.Now())
+
+	response := locationResponse(location, source)
+	response["status"] = status
+	response["last_seen_age"] = int64(age.Seconds())
+
+	c.JSON(http.StatusOK, response)
 }
 
 // GetLocationHistory endpoint: GET /vehicles/{vehicle_id}/history?start=xxx&end=xxx
@@ -65,6 +116,14 @@ func (h *VehicleHandler) GetLocationHistory(c *gin.Context) {
 		return
 	}
 
+	source, ok := h.source(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "source must be raw or smoothed",
+		})
+		return
+	}
+
 	if request.Start > request.End {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "start time must be before end time",
@@ -81,13 +140,8 @@ func (h *VehicleHandler) GetLocationHistory(c *gin.Context) {
 	}
 
 	var response []gin.H
-	for _, loc := range locations {
-		response = append(response, gin.H{
-			"vehicle_id": loc.VehicleID,
-			"latitude":   loc.Latitude,
-			"longitude":  loc.Longitude,
-			"timestamp":  loc.Timestamp,
-		})
+	for i := range locations {
+		response = append(response, locationResponse(&locations[i], source))
 	}
 
 	c.JSON(http.StatusOK, gin.H{
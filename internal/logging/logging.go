@@ -0,0 +1,194 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Output formats selectable with LOG_FORMAT
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// LevelAlert is used for geofence alerts, between info and warn
+const LevelAlert = slog.Level(2)
+
+// Config selects the format and the levels of all loggers
+type Config struct {
+	Format string
+	Level  slog.Level            // default level
+	Levels map[string]slog.Level // by component, overrides Level
+	Output io.Writer
+}
+
+// ParseLevel parses a level name, case-insensitive
+func ParseLevel(name string) (slog.Level, error) {
+	if strings.EqualFold(name, "alert") {
+		return LevelAlert, nil
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown level %q", name)
+	}
+	return level, nil
+}
+
+// ParseLevels parses a list of component=level pairs such as
+// "mqtt-service=debug,rabbitmq-service=warn"
+func ParseLevels(value string) (map[string]slog.Level, error) {
+	levels := map[string]slog.Level{}
+	if value == "" {
+		return levels, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		component, levelName, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || component == "" {
+			return nil, fmt.Errorf("invalid entry %q, expected component=level", pair)
+		}
+		level, err := ParseLevel(levelName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry %q: %v", pair, err)
+		}
+		levels[component] = level
+	}
+
+	return levels, nil
+}
+
+type state struct {
+	cfg  Config
+	base slog.Handler
+}
+
+func (s *state) level(component string) slog.Level {
+	if level, ok := s.cfg.Levels[component]; ok {
+		return level
+	}
+	return s.cfg.Level
+}
+
+var current atomic.Pointer[state]
+
+func init() {
+	current.Store(newState(Config{Format: FormatText, Level: slog.LevelInfo, Output: os.Stderr}))
+}
+
+func newState(cfg Config) *state {
+	opts := &slog.HandlerOptions{
+		// Levels are checked per component before a record gets here
+		Level: slog.LevelDebug - 4,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.LevelKey && len(groups) == 0 {
+				if level, ok := attr.Value.Any().(slog.Level); ok && level == LevelAlert {
+					attr.Value = slog.StringValue("ALERT")
+				}
+			}
+			return attr
+		},
+	}
+
+	var base slog.Handler
+	if cfg.Format == FormatJSON {
+		base = slog.NewJSONHandler(cfg.Output, opts)
+	} else {
+		base = slog.NewTextHandler(cfg.Output, opts)
+	}
+
+	return &state{cfg: cfg, base: base}
+}
+
+// Init applies the configuration to every logger, including the ones created
+// before, and routes the standard log package through the given component
+func Init(component string, cfg Config) {
+	if cfg.Output == nil {
+		cfg.Output = os.Stderr
+	}
+	current.Store(newState(cfg))
+	slog.SetDefault(New(component))
+}
+
+// New returns the logger of a component. Every record carries the component
+// and, when logged with a context holding a span, the trace and span IDs.
+func New(component string) *slog.Logger {
+	return slog.New(&handler{component: component})
+}
+
+// Alert logs a geofence alert
+func Alert(ctx context.Context, logger *slog.Logger, msg string, args ...any) {
+	logger.Log(ctx, LevelAlert, msg, args...)
+}
+
+// Fatal logs at error level and exits, like log.Fatal
+func Fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+// handler looks up the configuration on every record, so loggers created in
+// package variables follow Init. The handler derived from the configuration
+// is built once per Init.
+type handler struct {
+	component string
+	wrap      []func(slog.Handler) slog.Handler // WithAttrs and WithGroup calls, in order
+	derived   atomic.Pointer[derivedHandler]
+}
+
+type derivedHandler struct {
+	state *state // configuration the handler was built from
+	inner slog.Handler
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= current.Load().level(h.component)
+}
+
+func (h *handler) Handle(ctx context.Context, record slog.Record) error {
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(
+			slog.String("trace_id", span.TraceID().String()),
+			slog.String("span_id", span.SpanID().String()),
+		)
+	}
+
+	return h.inner().Handle(ctx, record)
+}
+
+// inner returns the handler for the current configuration, with the
+// component and the WithAttrs and WithGroup calls applied
+func (h *handler) inner() slog.Handler {
+	st := current.Load()
+	if derived := h.derived.Load(); derived != nil && derived.state == st {
+		return derived.inner
+	}
+
+	inner := st.base.WithAttrs([]slog.Attr{slog.String("component", h.component)})
+	for _, wrap := range h.wrap {
+		inner = wrap(inner)
+	}
+	h.derived.Store(&derivedHandler{state: st, inner: inner})
+	return inner
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(inner slog.Handler) slog.Handler { return inner.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(inner slog.Handler) slog.Handler { return inner.WithGroup(name) })
+}
+
+func (h *handler) with(wrap func(slog.Handler) slog.Handler) *handler {
+	wraps := make([]func(slog.Handler) slog.Handler, len(h.wrap), len(h.wrap)+1)
+	copy(wraps, h.wrap)
+	return &handler{component: h.component, wrap: append(wraps, wrap)}
+}
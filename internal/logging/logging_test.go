@@ -0,0 +1,91 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+func initForTest(t *testing.T, cfg Config) {
+	t.Helper()
+	Init("test", cfg)
+	t.Cleanup(func() {
+		Init("test", Config{Format: FormatText, Level: slog.LevelInfo, Output: os.Stderr})
+	})
+}
+
+func TestHandlerFollowsInit(t *testing.T) {
+	logger := New("mqtt-service").With("vehicle_id", "B1234XYZ").WithGroup("payload")
+
+	var first, second bytes.Buffer
+	initForTest(t, Config{Format: FormatJSON, Level: slog.LevelInfo, Output: &first})
+	logger.Info("first", "speed", 40)
+
+	initForTest(t, Config{Format: FormatText, Level: slog.LevelInfo, Output: &second})
+	logger.Info("second", "speed", 41)
+
+	if got := first.String(); !strings.Contains(got, `"component":"mqtt-service"`) ||
+		!strings.Contains(got, `"vehicle_id":"B1234XYZ"`) || !strings.Contains(got, `"payload":{"speed":40}`) {
+		t.Errorf("first record = %s", got)
+	}
+	if strings.Contains(first.String(), "second") {
+		t.Errorf("record logged after Init went to the old output: %s", first.String())
+	}
+	if got := second.String(); !strings.Contains(got, "component=mqtt-service") ||
+		!strings.Contains(got, "vehicle_id=B1234XYZ") || !strings.Contains(got, "payload.speed=41") {
+		t.Errorf("second record = %s", got)
+	}
+}
+
+func TestHandlerReusesDerivedHandler(t *testing.T) {
+	var buf bytes.Buffer
+	initForTest(t, Config{Format: FormatText, Level: slog.LevelInfo, Output: &buf})
+
+	h := New("geofence-worker").Handler().(*handler)
+	logger := slog.New(h)
+
+	logger.Info("first")
+	derived := h.derived.Load()
+	logger.Info("second")
+
+	if derived == nil || h.derived.Load() != derived {
+		t.Fatal("derived handler was rebuilt without a configuration change")
+	}
+
+	initForTest(t, Config{Format: FormatText, Level: slog.LevelInfo, Output: &buf})
+	logger.Info("third")
+	if h.derived.Load() == derived {
+		t.Error("derived handler was kept after Init")
+	}
+}
+
+func TestHandlerComponentLevels(t *testing.T) {
+	var buf bytes.Buffer
+	initForTest(t, Config{
+		Format: FormatText,
+		Level:  slog.LevelInfo,
+		Levels: map[string]slog.Level{"mqtt-service": slog.LevelDebug, "rabbitmq-service": slog.LevelWarn},
+		Output: &buf,
+	})
+
+	tests := []struct {
+		component string
+		level     slog.Level
+		want      bool
+	}{
+		{"mqtt-service", slog.LevelDebug, true},
+		{"rabbitmq-service", slog.LevelInfo, false},
+		{"rabbitmq-service", slog.LevelWarn, true},
+		{"geofence-worker", slog.LevelDebug, false},
+		{"geofence-worker", LevelAlert, true},
+	}
+
+	for _, tt := range tests {
+		if got := New(tt.component).Enabled(context.Background(), tt.level); got != tt.want {
+			t.Errorf("%s enabled at %s = %v, want %v", tt.component, tt.level, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "fleet"
+
+var logger = logging.New("metrics")
+
+// MQTT subscriber
+var (
+	MQTTMessagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mqtt_messages_received_total",
+		Help:      "MQTT messages received, by message type (location or driver).",
+	}, []string{"type"})
+
+	MQTTMessagesRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mqtt_messages_rejected_total",
+		Help:      "MQTT messages dropped before they were stored, by reason.",
+	}, []string{"reason"})
+
+	MQTTMessagesOtherShard = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mqtt_messages_other_shard_total",
+		Help:      "MQTT messages acknowledged without handling because their vehicle belongs to another shard.",
+	})
+
+	MQTTConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "mqtt_connected",
+		Help:      "Whether the subscriber is connected to the MQTT broker (1) or reconnecting (0).",
+	})
+
+	MQTTDisconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mqtt_disconnects_total",
+		Help:      "MQTT connections lost by the subscriber.",
+	})
+
+	MQTTReconnectAttempts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mqtt_reconnect_attempts_total",
+		Help:      "Attempts to reconnect to the MQTT broker after a lost connection.",
+	})
+
+	MQTTDisconnectDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mqtt_disconnect_duration_seconds",
+		Help:      "Time from a lost MQTT connection to the reconnect, no locations are received meanwhile.",
+		Buckets:   []float64{1, 2, 5, 10, 30, 60, 120, 300, 600, 1800},
+	})
+
+	LocationsStored = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "locations_stored_total",
+		Help:      "Vehicle locations stored, by plausibility quality.",
+	}, []string{"quality"})
+
+	DBInsertDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_insert_duration_seconds",
+		Help:      "Latency of database inserts, by table.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"table"})
+
+	GeofenceEvaluations = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "geofence_evaluations_total",
+		Help:      "Points checked against the geofence areas.",
+	})
+
+	GeofenceHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "geofence_hits_total",
+		Help:      "Geofence transitions, by event (geofence_entry or geofence_exit).",
+	}, []string{"event"})
+)
+
+// RabbitMQ publisher
+var (
+	RabbitMQPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rabbitmq_published_total",
+		Help:      "Events published to RabbitMQ and confirmed by the broker, by routing key.",
+	}, []string{"routing_key"})
+
+	RabbitMQPublishFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rabbitmq_publish_failures_total",
+		Help:      "Events that could not be published to RabbitMQ or were not confirmed, by routing key.",
+	}, []string{"routing_key"})
+)
+
+// Geofence worker
+var (
+	WorkerProcessingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "worker_processing_duration_seconds",
+		Help:      "Time to handle one delivery, by outcome (ack, duplicate, retry, parked, invalid or requeued).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"outcome"})
+
+	QueueMessages = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rabbitmq_queue_messages",
+		Help:      "Messages ready in a RabbitMQ queue, polled by the worker.",
+	}, []string{"queue"})
+)
+
+// Mock publisher
+var (
+	MockPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mock_messages_published_total",
+		Help:      "Mock GPS messages published, by result (ok or error).",
+	}, []string{"result"})
+
+	MockBatchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mock_publish_batch_size",
+		Help:      "Messages published per tick of the mock publisher.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+)
+
+// API
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests, by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+// Since returns the seconds elapsed since start, for observing histograms
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+// Handler returns the handler serving /metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// GinMiddleware records the count and latency of every request. Requests are
+// labelled with the route pattern, not the path, so vehicle IDs don't blow up
+// the number of series.
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		HTTPRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		HTTPRequestDuration.WithLabelValues(c.Request.Method, route).Observe(Since(start))
+	}
+}
+
+// Serve exposes /metrics on addr in the background, for binaries without an
+// HTTP server of their own. register adds more routes to the same server,
+// such as the health checks.
+func Serve(addr string, register ...func(*http.ServeMux)) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	for _, r := range register {
+		r(mux)
+	}
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		logger.Info("Serving metrics", "addr", addr, "path", "/metrics")
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Metrics server stopped", "error", err)
+		}
+	}()
+
+	return server
+}
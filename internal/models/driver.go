@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// Driver is a bus driver
+type Driver struct {
+	ID            string    `json:"id" db:"id"`
+	Name          string    `json:"name" db:"name"`
+	LicenseNumber string    `json:"license_number" db:"license_number"`
+	Phone         string    `json:"phone" db:"phone"`
+	Active        bool      `json:"active" db:"active"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// DriverShift is the time a driver is on duty on a vehicle, from login until
+// logout
+type DriverShift struct {
+	ID        int64  `json:"id" db:"id"`
+	DriverID  string `json:"driver_id" db:"driver_id"`
+	VehicleID string `json:"vehicle_id" db:"vehicle_id"`
+	StartedAt int64  `json:"started_at" db:"started_at"`
+	EndedAt   *int64 `json:"ended_at,omitempty" db:"ended_at"`
+	Source    string `json:"source" db:"source"` // "api" or "terminal"
+}
+
+// Covers reports whether the shift was running at the given timestamp
+func (s *DriverShift) Covers(timestamp int64) bool {
+	return s.StartedAt <= timestamp && (s.EndedAt == nil || timestamp < *s.EndedAt)
+}
+
+// Shift actions sent by the in-bus terminal
+const (
+	ShiftLogin  = "login"
+	ShiftLogout = "logout"
+)
+
+// DriverShiftPayload is the message of the in-bus terminal on
+// /fleet/vehicle/{vehicle_id}/driver
+type DriverShiftPayload struct {
+	DriverID  string `json:"driver_id"`
+	Action    string `json:"action"` // "login" or "logout"
+	Timestamp int64  `json:"timestamp"`
+}
@@ -0,0 +1,37 @@
+package models
+
+// SegmentTravelTime is the typical time to travel from a route stop to the
+// next one, learned from past stop visits
+type SegmentTravelTime struct {
+	FromSequence int     `json:"from_sequence" db:"from_sequence"`
+	Seconds      float64 `json:"seconds" db:"seconds"`
+	Samples      int     `json:"samples" db:"samples"`
+}
+
+// StopETA is the predicted arrival of a vehicle at one upcoming stop
+type StopETA struct {
+	StopSequence   int     `json:"stop_sequence"`
+	StopID         string  `json:"stop_id"`
+	GeofenceAreaID int     `json:"geofence_area_id"`
+	Name           string  `json:"name"`
+	DistanceMeters float64 `json:"distance_meters"`
+	ETA            int64   `json:"eta"`
+	ETASeconds     int64   `json:"eta_seconds"`
+}
+
+// VehicleETA lists the predicted arrivals of a vehicle along its route
+type VehicleETA struct {
+	VehicleID string    `json:"vehicle_id"`
+	RouteID   string    `json:"route_id"`
+	Timestamp int64     `json:"timestamp"`
+	Stops     []StopETA `json:"stops"`
+}
+
+// StopArrival is a predicted arrival of a vehicle at a stop
+type StopArrival struct {
+	VehicleID    string `json:"vehicle_id"`
+	RouteID      string `json:"route_id"`
+	StopSequence int    `json:"stop_sequence"`
+	ETA          int64  `json:"eta"`
+	ETASeconds   int64  `json:"eta_seconds"`
+}
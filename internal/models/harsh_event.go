@@ -0,0 +1,42 @@
+package models
+
+// Accelerometer is a reading of the in-bus accelerometer in g. X points
+// forward, Y to the left and Z up.
+type Accelerometer struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// Harsh driving event types
+const (
+	HarshBraking      = "harsh_braking"
+	HarshAcceleration = "harsh_acceleration"
+	SharpCornering    = "sharp_cornering"
+)
+
+// HarshEvent is a harsh braking, acceleration or cornering maneuver
+type HarshEvent struct {
+	ID        int64   `json:"id" db:"id"`
+	VehicleID string  `json:"vehicle_id" db:"vehicle_id"`
+	DriverID  *string `json:"driver_id,omitempty" db:"driver_id"`
+	EventType string  `json:"event_type" db:"event_type"`
+	GForce    float64 `json:"g_force" db:"g_force"`
+	SpeedKmh  float64 `json:"speed_kmh" db:"speed_kmh"`
+	Source    string  `json:"source" db:"source"` // "accelerometer" or "derived"
+	Timestamp int64   `json:"timestamp" db:"timestamp"`
+	Latitude  float64 `json:"latitude" db:"latitude"`
+	Longitude float64 `json:"longitude" db:"longitude"`
+}
+
+// SafetyScore counts the unsafe driving events of a vehicle or driver and the
+// distance driven. Score starts at 100 and drops with the events per 100 km.
+type SafetyScore struct {
+	Key               string  `json:"key" db:"key"`
+	HarshBraking      int     `json:"harsh_braking" db:"harsh_braking"`
+	HarshAcceleration int     `json:"harsh_acceleration" db:"harsh_acceleration"`
+	SharpCornering    int     `json:"sharp_cornering" db:"sharp_cornering"`
+	Speeding          int     `json:"speeding" db:"speeding"`
+	DistanceKm        float64 `json:"distance_km" db:"distance_km"`
+	Score             float64 `json:"score" db:"-"`
+}
@@ -0,0 +1,22 @@
+package models
+
+// IdleEvent is a period a vehicle stood still with its engine running outside
+// a terminal or depot
+type IdleEvent struct {
+	ID        int64   `json:"id" db:"id"`
+	VehicleID string  `json:"vehicle_id" db:"vehicle_id"`
+	DriverID  *string `json:"driver_id,omitempty" db:"driver_id"`
+	StartedAt int64   `json:"started_at" db:"started_at"`
+	EndedAt   *int64  `json:"ended_at,omitempty" db:"ended_at"`
+	Latitude  float64 `json:"latitude" db:"latitude"`
+	Longitude float64 `json:"longitude" db:"longitude"`
+	Ignition  *bool   `json:"ignition,omitempty" db:"ignition"` // nil when the device reports no ignition
+}
+
+// IdleStats is the idle time of a vehicle on one day
+type IdleStats struct {
+	VehicleID   string  `json:"vehicle_id" db:"vehicle_id"`
+	Day         string  `json:"day" db:"day"`
+	Events      int     `json:"events" db:"events"`
+	IdleMinutes float64 `json:"idle_minutes" db:"idle_minutes"`
+}
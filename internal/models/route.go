@@ -0,0 +1,79 @@
+package models
+
+import (
+	"time"
+)
+
+// Route is a bus line with an ordered list of stops and a shape
+type Route struct {
+	ID        string       `json:"id" db:"id"`
+	ShortName string       `json:"short_name" db:"short_name"`
+	LongName  string       `json:"long_name" db:"long_name"`
+	Color     string       `json:"color" db:"color"`
+	CreatedAt time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at" db:"updated_at"`
+	Stops     []RouteStop  `json:"stops,omitempty" db:"-"`
+	Shape     []ShapePoint `json:"shape,omitempty" db:"-"`
+}
+
+// RouteStop is a stop of a route, linked to the geofence area of the halte
+type RouteStop struct {
+	RouteID        string  `json:"route_id" db:"route_id"`
+	StopSequence   int     `json:"stop_sequence" db:"stop_sequence"`
+	GeofenceAreaID int     `json:"geofence_area_id" db:"geofence_area_id"`
+	Name           string  `json:"name" db:"name"`
+	Latitude       float64 `json:"latitude" db:"latitude"`
+	Longitude      float64 `json:"longitude" db:"longitude"`
+	RadiusMeters   int     `json:"radius_meters" db:"radius_meters"`
+	StopCode       *string `json:"stop_code,omitempty" db:"stop_code"`
+}
+
+// ShapePoint is one vertex of the polyline a route follows
+type ShapePoint struct {
+	Sequence  int     `json:"sequence" db:"point_sequence"`
+	Latitude  float64 `json:"latitude" db:"latitude"`
+	Longitude float64 `json:"longitude" db:"longitude"`
+}
+
+// VehicleRoute is the route a vehicle is assigned to and its progress along it
+type VehicleRoute struct {
+	VehicleID        string    `json:"vehicle_id" db:"vehicle_id"`
+	RouteID          string    `json:"route_id" db:"route_id"`
+	AssignedAt       time.Time `json:"assigned_at" db:"assigned_at"`
+	LastStopSequence *int      `json:"last_stop_sequence,omitempty" db:"last_stop_sequence"`
+	LastStopAt       *int64    `json:"last_stop_at,omitempty" db:"last_stop_at"`
+	TripID           *string   `json:"trip_id,omitempty" db:"trip_id"`
+}
+
+// Trip is a scheduled trip imported from GTFS
+type Trip struct {
+	ID          string `json:"id" db:"id"`
+	RouteID     string `json:"route_id" db:"route_id"`
+	ServiceID   string `json:"service_id" db:"service_id"`
+	Headsign    string `json:"headsign" db:"headsign"`
+	DirectionID int    `json:"direction_id" db:"direction_id"`
+	ShapeID     string `json:"shape_id" db:"shape_id"`
+}
+
+// TripStop is a scheduled stop of a trip. Times are seconds after midnight of
+// the service day, nil when the feed has no time for the stop.
+type TripStop struct {
+	TripID         string  `json:"trip_id" db:"trip_id"`
+	StopSequence   int     `json:"stop_sequence" db:"stop_sequence"`
+	GeofenceAreaID int     `json:"geofence_area_id" db:"geofence_area_id"`
+	StopCode       *string `json:"stop_code,omitempty" db:"stop_code"`
+	Name           string  `json:"name" db:"name"`
+	Latitude       float64 `json:"latitude" db:"latitude"`
+	Longitude      float64 `json:"longitude" db:"longitude"`
+	RadiusMeters   int     `json:"radius_meters" db:"radius_meters"`
+	ArrivalTime    *int    `json:"arrival_time,omitempty" db:"arrival_time"`
+	DepartureTime  *int    `json:"departure_time,omitempty" db:"departure_time"`
+}
+
+// RouteProgress describes where a stop arrival falls on the assigned route
+type RouteProgress struct {
+	RouteID      string  `json:"route_id"`
+	StopSequence int     `json:"stop_sequence"`
+	StopsTotal   int     `json:"stops_total"`
+	TripID       *string `json:"trip_id,omitempty"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// Speed limit zone shapes
+const (
+	ZonePolygon  = "polygon"  // area enclosed by the points
+	ZoneCorridor = "corridor" // road along the points, WidthMeters wide
+)
+
+// SpeedLimitZone is an area with a speed limit, e.g. around a school
+type SpeedLimitZone struct {
+	ID            int          `json:"id" db:"id"`
+	Name          string       `json:"name" db:"name"`
+	ZoneType      string       `json:"zone_type" db:"zone_type"`
+	SpeedLimitKmh float64      `json:"speed_limit_kmh" db:"speed_limit_kmh"`
+	WidthMeters   float64      `json:"width_meters,omitempty" db:"width_meters"`
+	Points        []ShapePoint `json:"points" db:"-"`
+	CreatedAt     time.Time    `json:"created_at" db:"created_at"`
+}
+
+// SpeedingEvent is a period a vehicle drove above the limit of a zone
+type SpeedingEvent struct {
+	ID            int64   `json:"id" db:"id"`
+	VehicleID     string  `json:"vehicle_id" db:"vehicle_id"`
+	DriverID      *string `json:"driver_id,omitempty" db:"driver_id"`
+	ZoneID        *int    `json:"zone_id,omitempty" db:"zone_id"`
+	ZoneName      string  `json:"zone_name" db:"zone_name"`
+	SpeedLimitKmh float64 `json:"speed_limit_kmh" db:"speed_limit_kmh"`
+	StartedAt     int64   `json:"started_at" db:"started_at"`
+	EndedAt       *int64  `json:"ended_at,omitempty" db:"ended_at"`
+	MaxSpeedKmh   float64 `json:"max_speed_kmh" db:"max_speed_kmh"`
+	Latitude      float64 `json:"latitude" db:"latitude"`
+	Longitude     float64 `json:"longitude" db:"longitude"`
+}
+
+// SpeedingStats summarizes the speeding events of a vehicle or driver
+type SpeedingStats struct {
+	Key             string  `json:"key" db:"key"`
+	Events          int     `json:"events" db:"events"`
+	TotalSeconds    int64   `json:"total_seconds" db:"total_seconds"`
+	MaxSpeedKmh     float64 `json:"max_speed_kmh" db:"max_speed_kmh"`
+	MaxOverLimitKmh float64 `json:"max_over_limit_kmh" db:"max_over_limit_kmh"`
+}
@@ -0,0 +1,58 @@
+package models
+
+// StopVisit is an actual arrival at a stop and the departure from it.
+// Scheduled times are set when the visit could be matched to stop_times.
+type StopVisit struct {
+	ID                 int64   `json:"id" db:"id"`
+	VehicleID          string  `json:"vehicle_id" db:"vehicle_id"`
+	DriverID           *string `json:"driver_id,omitempty" db:"driver_id"`
+	GeofenceAreaID     int     `json:"geofence_area_id" db:"geofence_area_id"`
+	AreaName           string  `json:"area_name,omitempty" db:"area_name"`
+	RouteID            *string `json:"route_id,omitempty" db:"route_id"`
+	StopSequence       *int    `json:"stop_sequence,omitempty" db:"stop_sequence"`
+	TripID             *string `json:"trip_id,omitempty" db:"trip_id"`
+	ArrivedAt          int64   `json:"arrived_at" db:"arrived_at"`
+	DepartedAt         *int64  `json:"departed_at,omitempty" db:"departed_at"`
+	ScheduledArrival   *int64  `json:"scheduled_arrival,omitempty" db:"scheduled_arrival"`
+	ScheduledDeparture *int64  `json:"scheduled_departure,omitempty" db:"scheduled_departure"`
+	ArrivalDelay       *int    `json:"arrival_delay,omitempty" db:"arrival_delay"`
+	DepartureDelay     *int    `json:"departure_delay,omitempty" db:"departure_delay"`
+}
+
+// ScheduledStop is the stop_times entry a visit was matched to
+type ScheduledStop struct {
+	TripID        string `db:"trip_id"`
+	StopSequence  int    `db:"stop_sequence"`
+	ArrivalTime   *int   `db:"arrival_time"`
+	DepartureTime *int   `db:"departure_time"`
+}
+
+// OnTimeStats summarizes arrival punctuality of one route, stop or day
+type OnTimeStats struct {
+	Key             string  `json:"key" db:"key"`
+	Name            string  `json:"name" db:"name"`
+	Total           int     `json:"total" db:"total"`
+	Early           int     `json:"early" db:"early"`
+	OnTime          int     `json:"on_time" db:"on_time"`
+	Late            int     `json:"late" db:"late"`
+	EarlyPercent    float64 `json:"early_pct" db:"-"`
+	OnTimePercent   float64 `json:"on_time_pct" db:"-"`
+	LatePercent     float64 `json:"late_pct" db:"-"`
+	AvgDelaySeconds float64 `json:"avg_delay_seconds" db:"avg_delay_seconds"`
+}
+
+// StopHeadway is the time between the last two vehicles that arrived at a
+// stop of a route
+type StopHeadway struct {
+	StopSequence      int     `json:"stop_sequence" db:"stop_sequence"`
+	GeofenceAreaID    int     `json:"geofence_area_id" db:"geofence_area_id"`
+	StopCode          *string `json:"stop_code,omitempty" db:"stop_code"`
+	Name              string  `json:"name" db:"name"`
+	LastVehicleID     *string `json:"last_vehicle_id,omitempty" db:"last_vehicle_id"`
+	LastArrival       *int64  `json:"last_arrival,omitempty" db:"last_arrival"`
+	PreviousVehicleID *string `json:"previous_vehicle_id,omitempty" db:"previous_vehicle_id"`
+	PreviousArrival   *int64  `json:"previous_arrival,omitempty" db:"previous_arrival"`
+	HeadwaySeconds    *int64  `json:"headway_seconds,omitempty" db:"-"`
+	SinceLastSeconds  *int64  `json:"since_last_arrival_seconds,omitempty" db:"-"`
+	Status            string  `json:"status,omitempty" db:"-"`
+}
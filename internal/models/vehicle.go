@@ -1,6 +1,9 @@
 package models
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
 	"time"
 )
 
@@ -11,7 +14,36 @@ type VehicleLocation struct {
 	Latitude  float64   `json:"latitude" db:"latitude"`
 	Longitude float64   `json:"longitude" db:"longitude"`
 	Timestamp int64     `json:"timestamp" db:"timestamp"`
+	Quality   string    `json:"quality" db:"quality"` // "ok", "out_of_order" or "implausible_speed"
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
+
+	// Speed and ignition reported by the device, nil when it sends none
+	SpeedKmh *float64 `json:"speed_kmh,omitempty" db:"speed_kmh"`
+	Ignition *bool    `json:"ignition,omitempty" db:"ignition"`
+
+	// Driver on duty when the point was received
+	DriverID *string `json:"driver_id,omitempty" db:"driver_id"`
+
+	// Output of the position smoother, nil when smoothing is disabled
+	SmoothedLatitude  *float64 `json:"smoothed_latitude,omitempty" db:"smoothed_latitude"`
+	SmoothedLongitude *float64 `json:"smoothed_longitude,omitempty" db:"smoothed_longitude"`
+	SmoothedSpeedKmh  *float64 `json:"smoothed_speed_kmh,omitempty" db:"smoothed_speed_kmh"`
+	SmoothedHeading   *float64 `json:"smoothed_heading,omitempty" db:"smoothed_heading"`
+}
+
+// Position sources for geofencing and the API
+const (
+	PositionRaw      = "raw"
+	PositionSmoothed = "smoothed"
+)
+
+// Position returns the raw or smoothed coordinates. It falls back to the raw
+// fix when no smoothed position is available.
+func (l *VehicleLocation) Position(source string) (float64, float64) {
+	if source == PositionSmoothed && l.SmoothedLatitude != nil && l.SmoothedLongitude != nil {
+		return *l.SmoothedLatitude, *l.SmoothedLongitude
+	}
+	return l.Latitude, l.Longitude
 }
 
 // VehicleStatus is used for tracking last known status of a vehicle
@@ -20,6 +52,12 @@ type MQTTPayload struct {
 	Latitude  float64 `json:"latitude"`
 	Longitude float64 `json:"longitude"`
 	Timestamp int64   `json:"timestamp"`
+
+	Speed    *float64 `json:"speed,omitempty"`     // km/h, optional
+	Ignition *bool    `json:"ignition,omitempty"`  // optional
+	DriverID *string  `json:"driver_id,omitempty"` // optional, driver logged in on the terminal
+
+	Accelerometer *Accelerometer `json:"accelerometer,omitempty"` // optional
 }
 
 // VehicleStatus tracks the last known location and geofence status of a vehicle
@@ -29,15 +67,68 @@ type GeofenceArea struct {
 	CenterLatitude  float64 `json:"center_latitude" db:"center_latitude"`
 	CenterLongitude float64 `json:"center_longitude" db:"center_longitude"`
 	RadiusMeters    int     `json:"radius_meters" db:"radius_meters"`
+	StopCode        *string `json:"stop_code,omitempty" db:"stop_code"`
+	AreaType        string  `json:"area_type" db:"area_type"` // "stop", "terminal" or "depot"
 }
 
+// Geofence area types, vehicles may idle at terminals and depots
+const (
+	AreaStop     = "stop"
+	AreaTerminal = "terminal"
+	AreaDepot    = "depot"
+)
+
 // GeofenceEvent sended when a vehicle enters or exits a geofence area
 type GeofenceEvent struct {
+	EventID   string   `json:"event_id"` // idempotency key, stable across redeliveries
 	VehicleID string   `json:"vehicle_id"`
 	Event     string   `json:"event"` // "geofence_entry" or "geofence_exit"
 	Location  Location `json:"location"`
 	Timestamp int64    `json:"timestamp"`
 	AreaName  string   `json:"area_name,omitempty"`
+	DriverID  *string  `json:"driver_id,omitempty"`
+
+	// Set when the area is a stop of the vehicle's assigned route
+	Route *RouteProgress `json:"route,omitempty"`
+}
+
+// IdempotencyKey returns EventID, or derives the same key from the event fields
+// for messages published before event IDs were introduced
+func (e *GeofenceEvent) IdempotencyKey() string {
+	if e.EventID != "" {
+		return e.EventID
+	}
+	return NewEventID(e.VehicleID, e.Event, e.AreaName, fmt.Sprint(e.Timestamp))
+}
+
+// NewEventID builds a deterministic event ID from the given parts
+func NewEventID(parts ...string) string {
+	h := sha1.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// VehicleEvent is a generic vehicle event published on fleet.events
+type VehicleEvent struct {
+	EventID   string                 `json:"event_id"`
+	VehicleID string                 `json:"vehicle_id"`
+	Event     string                 `json:"event"`
+	Timestamp int64                  `json:"timestamp"`
+	Location  *Location              `json:"location,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// SubscriberEvent is an event about the MQTT subscriber itself, such as a lost
+// broker connection, published on fleet.events
+type SubscriberEvent struct {
+	EventID   string                 `json:"event_id"`
+	ClientID  string                 `json:"client_id"`
+	Event     string                 `json:"event"`
+	Timestamp int64                  `json:"timestamp"`
+	Details   map[string]interface{} `json:"details,omitempty"`
 }
 
 // Location is used in GeofenceEvent
@@ -0,0 +1,108 @@
+package mqttconn
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Config selects the broker and how to authenticate to it. TLS is used when
+// the broker URL has a TLS scheme such as ssl:// or mqtts://.
+type Config struct {
+	Broker       string
+	ClientID     string
+	CleanSession bool // false keeps the session of ClientID on the broker across connections
+	Username     string
+	Password     string
+	CAFile       string // PEM bundle to verify the broker, system roots when empty
+	CertFile     string // client certificate and key for mutual TLS
+	KeyFile      string
+
+	// Longest wait between reconnect attempts, the backoff starts at 1s and
+	// doubles up to it. Zero keeps the client default (10m).
+	ReconnectMaxInterval time.Duration
+
+	// Wait between attempts of the first connect, so a broker that is down
+	// at startup is waited for. Zero fails the first connect right away.
+	ConnectRetryInterval time.Duration
+}
+
+// TLSSchemes are the broker URL schemes paho connects to over TLS
+var TLSSchemes = []string{"ssl", "tls", "mqtts", "mqtt+ssl", "tcps", "wss"}
+
+// IsTLS reports whether the broker URL uses TLS
+func IsTLS(broker string) bool {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return false
+	}
+	for _, scheme := range TLSSchemes {
+		if u.Scheme == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientOptions returns the client options for the broker with the
+// credentials and TLS settings applied, the caller adds its own handlers
+func ClientOptions(cfg Config) (*mqtt.ClientOptions, error) {
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(cfg.Broker)
+	opts.SetClientID(cfg.ClientID)
+	opts.SetCleanSession(cfg.CleanSession)
+	opts.SetAutoReconnect(true)
+	if cfg.ReconnectMaxInterval > 0 {
+		opts.SetMaxReconnectInterval(cfg.ReconnectMaxInterval)
+	}
+	if cfg.ConnectRetryInterval > 0 {
+		opts.SetConnectRetry(true)
+		opts.SetConnectRetryInterval(cfg.ConnectRetryInterval)
+	}
+
+	if cfg.Username != "" {
+		opts.SetUsername(cfg.Username)
+		opts.SetPassword(cfg.Password)
+	}
+
+	if IsTLS(cfg.Broker) {
+		tlsConfig, err := newTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	return opts, nil
+}
+
+func newTLSConfig(cfg Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,162 @@
+package repositories
+
+import (
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+type DriverRepository struct {
+	db *sqlx.DB
+}
+
+func NewDriverRepository(db *sqlx.DB) *DriverRepository {
+	return &DriverRepository{db: db}
+}
+
+// ListDrivers retrieves all drivers
+func (r *DriverRepository) ListDrivers() ([]models.Driver, error) {
+	drivers := []models.Driver{}
+
+	query := `
+        SELECT id, name, license_number, phone, active, created_at, updated_at
+        FROM drivers
+        ORDER BY id
+    `
+
+	err := r.db.Select(&drivers, query)
+	return drivers, err
+}
+
+// GetDriver retrieves a driver
+func (r *DriverRepository) GetDriver(driverID string) (*models.Driver, error) {
+	var driver models.Driver
+
+	query := `
+        SELECT id, name, license_number, phone, active, created_at, updated_at
+        FROM drivers
+        WHERE id = $1
+    `
+
+	if err := r.db.Get(&driver, query, driverID); err != nil {
+		return nil, err
+	}
+
+	return &driver, nil
+}
+
+// CreateDriver inserts a driver
+func (r *DriverRepository) CreateDriver(driver *models.Driver) error {
+	query := `
+        INSERT INTO drivers (id, name, license_number, phone, active)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING created_at, updated_at
+    `
+	return r.db.QueryRowx(query, driver.ID, driver.Name, driver.LicenseNumber, driver.Phone, driver.Active).
+		Scan(&driver.CreatedAt, &driver.UpdatedAt)
+}
+
+// UpdateDriver updates the attributes of a driver
+func (r *DriverRepository) UpdateDriver(driver *models.Driver) error {
+	query := `
+        UPDATE drivers
+        SET name = $2, license_number = $3, phone = $4, active = $5, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+        RETURNING created_at, updated_at
+    `
+	return r.db.QueryRowx(query, driver.ID, driver.Name, driver.LicenseNumber, driver.Phone, driver.Active).
+		Scan(&driver.CreatedAt, &driver.UpdatedAt)
+}
+
+// DeleteDriver deletes a driver together with their shifts
+func (r *DriverRepository) DeleteDriver(driverID string) error {
+	result, err := r.db.Exec(`DELETE FROM drivers WHERE id = $1`, driverID)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result)
+}
+
+// StartShift logs a driver in on a vehicle. Open shifts of the driver and of
+// the vehicle are ended at the same time, a driver can only be on one vehicle
+// and a vehicle only has one driver.
+func (r *DriverRepository) StartShift(shift *models.DriverShift) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+        UPDATE driver_shifts
+        SET ended_at = GREATEST($3, started_at)
+        WHERE (driver_id = $1 OR vehicle_id = $2) AND ended_at IS NULL
+    `
+	if _, err := tx.Exec(query, shift.DriverID, shift.VehicleID, shift.StartedAt); err != nil {
+		return err
+	}
+
+	query = `
+        INSERT INTO driver_shifts (driver_id, vehicle_id, started_at, source)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id
+    `
+	if err := tx.Get(&shift.ID, query, shift.DriverID, shift.VehicleID, shift.StartedAt, shift.Source); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// EndShift logs a driver out and returns the ended shift. An empty vehicleID
+// ends the shift on any vehicle, otherwise only a shift on that vehicle. It
+// returns sql.ErrNoRows when the driver is not on duty there.
+func (r *DriverRepository) EndShift(driverID, vehicleID string, endedAt int64) (*models.DriverShift, error) {
+	var shift models.DriverShift
+
+	query := `
+        UPDATE driver_shifts
+        SET ended_at = GREATEST($3, started_at)
+        WHERE driver_id = $1 AND ($2 = '' OR vehicle_id = $2) AND ended_at IS NULL
+        RETURNING id, driver_id, vehicle_id, started_at, ended_at, source
+    `
+
+	if err := r.db.Get(&shift, query, driverID, vehicleID, endedAt); err != nil {
+		return nil, err
+	}
+
+	return &shift, nil
+}
+
+// GetShiftAt retrieves the shift running on a vehicle at the given timestamp
+func (r *DriverRepository) GetShiftAt(vehicleID string, timestamp int64) (*models.DriverShift, error) {
+	var shift models.DriverShift
+
+	query := `
+        SELECT id, driver_id, vehicle_id, started_at, ended_at, source
+        FROM driver_shifts
+        WHERE vehicle_id = $1 AND started_at <= $2 AND (ended_at IS NULL OR ended_at > $2)
+        ORDER BY started_at DESC
+        LIMIT 1
+    `
+
+	if err := r.db.Get(&shift, query, vehicleID, timestamp); err != nil {
+		return nil, err
+	}
+
+	return &shift, nil
+}
+
+// GetDriverShifts retrieves the shifts of a driver overlapping a time range
+func (r *DriverRepository) GetDriverShifts(driverID string, start, end int64) ([]models.DriverShift, error) {
+	shifts := []models.DriverShift{}
+
+	query := `
+        SELECT id, driver_id, vehicle_id, started_at, ended_at, source
+        FROM driver_shifts
+        WHERE driver_id = $1 AND started_at <= $3 AND (ended_at IS NULL OR ended_at >= $2)
+        ORDER BY started_at ASC
+    `
+
+	err := r.db.Select(&shifts, query, driverID, start, end)
+	return shifts, err
+}
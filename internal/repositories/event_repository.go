@@ -0,0 +1,51 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type EventRepository struct {
+	db *sqlx.DB
+}
+
+func NewEventRepository(db *sqlx.DB) *EventRepository {
+	return &EventRepository{db: db}
+}
+
+// MarkEventProcessed records an event ID as processed.
+// It returns false when the event was already processed before.
+func (r *EventRepository) MarkEventProcessed(eventID string) (bool, error) {
+	query := `
+        INSERT INTO processed_events (event_id)
+        VALUES ($1)
+        ON CONFLICT (event_id) DO NOTHING
+    `
+	result, err := r.db.Exec(query, eventID)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// PruneProcessedEvents deletes the event IDs processed longer than olderThan
+// ago, it returns how many were deleted
+func (r *EventRepository) PruneProcessedEvents(olderThan time.Duration) (int64, error) {
+	query := `
+        DELETE FROM processed_events
+        WHERE processed_at < CURRENT_TIMESTAMP - make_interval(secs => $1)
+    `
+	result, err := r.db.Exec(query, olderThan.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,392 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/gtfs"
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// dateLayout formats dates for DATE columns
+const dateLayout = "2006-01-02"
+
+type GTFSRepository struct {
+	db *sqlx.DB
+}
+
+func NewGTFSRepository(db *sqlx.DB) *GTFSRepository {
+	return &GTFSRepository{db: db}
+}
+
+// GTFSImportStats summarizes what an import changed
+type GTFSImportStats struct {
+	StopsInserted     int
+	StopsUpdated      int
+	Routes            int
+	Trips             int
+	StopTimes         int
+	Calendars         int
+	CalendarDates     int
+	SkippedStopTimes  int
+	RoutesWithoutTrip int
+}
+
+// ImportFeed writes a GTFS feed in a single transaction. Stops are matched on
+// their GTFS stop_id, so a re-import updates moved or renamed stops and keeps
+// the radius of existing geofence areas. Trips of the imported routes that are
+// no longer in the feed are removed.
+func (r *GTFSRepository) ImportFeed(feed *gtfs.Feed, stopRadius int) (*GTFSImportStats, error) {
+	stats := &GTFSImportStats{}
+
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	areaIDs, err := importStops(tx, feed.Stops, stopRadius, stats)
+	if err != nil {
+		return nil, err
+	}
+
+	routeIDs := make([]string, 0, len(feed.Routes))
+	for _, route := range feed.Routes {
+		query := `
+            INSERT INTO routes (id, short_name, long_name, color)
+            VALUES ($1, $2, $3, $4)
+            ON CONFLICT (id) DO UPDATE
+            SET short_name = EXCLUDED.short_name, long_name = EXCLUDED.long_name,
+                color = EXCLUDED.color, updated_at = CURRENT_TIMESTAMP
+        `
+		if _, err := tx.Exec(query, route.ID, route.ShortName, route.LongName, route.Color); err != nil {
+			return nil, fmt.Errorf("route %s: %v", route.ID, err)
+		}
+		routeIDs = append(routeIDs, route.ID)
+		stats.Routes++
+	}
+
+	tripIDs := make([]string, 0, len(feed.Trips))
+	for _, trip := range feed.Trips {
+		query := `
+            INSERT INTO trips (id, route_id, service_id, headsign, direction_id, shape_id)
+            VALUES ($1, $2, $3, $4, $5, $6)
+            ON CONFLICT (id) DO UPDATE
+            SET route_id = EXCLUDED.route_id, service_id = EXCLUDED.service_id, headsign = EXCLUDED.headsign,
+                direction_id = EXCLUDED.direction_id, shape_id = EXCLUDED.shape_id
+        `
+		if _, err := tx.Exec(query, trip.ID, trip.RouteID, trip.ServiceID, trip.Headsign, trip.DirectionID, trip.ShapeID); err != nil {
+			return nil, fmt.Errorf("trip %s: %v", trip.ID, err)
+		}
+		tripIDs = append(tripIDs, trip.ID)
+		stats.Trips++
+	}
+
+	query := `DELETE FROM trips WHERE route_id = ANY($1) AND NOT (id = ANY($2))`
+	if _, err := tx.Exec(query, pq.Array(routeIDs), pq.Array(tripIDs)); err != nil {
+		return nil, fmt.Errorf("failed to remove old trips: %v", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM stop_times WHERE trip_id = ANY($1)`, pq.Array(tripIDs)); err != nil {
+		return nil, fmt.Errorf("failed to clear stop times: %v", err)
+	}
+
+	stopsByTrip, err := importStopTimes(tx, feed.StopTimes, areaIDs, stats)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := importCalendars(tx, feed, stats); err != nil {
+		return nil, err
+	}
+
+	for _, route := range feed.Routes {
+		trip := representativeTrip(feed.Trips, route.ID, stopsByTrip)
+		if trip == nil {
+			stats.RoutesWithoutTrip++
+			continue
+		}
+
+		if err := replaceRouteGeometry(tx, route.ID, stopsByTrip[trip.ID], feed.Shapes[trip.ShapeID]); err != nil {
+			return nil, fmt.Errorf("route %s: %v", route.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// importStops upserts stops as geofence areas and returns their IDs by stop_id
+func importStops(tx *sqlx.Tx, stops []gtfs.Stop, stopRadius int, stats *GTFSImportStats) (map[string]int, error) {
+	existing := map[string]bool{}
+	rows, err := tx.Queryx(`SELECT stop_code FROM geofence_areas WHERE stop_code IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		existing[code] = true
+	}
+	rows.Close()
+
+	areaIDs := make(map[string]int, len(stops))
+	for _, stop := range stops {
+		query := `
+            INSERT INTO geofence_areas (name, center_latitude, center_longitude, radius_meters, stop_code)
+            VALUES ($1, $2, $3, $4, $5)
+            ON CONFLICT (stop_code) DO UPDATE
+            SET name = EXCLUDED.name, center_latitude = EXCLUDED.center_latitude,
+                center_longitude = EXCLUDED.center_longitude
+            WHERE (geofence_areas.name, geofence_areas.center_latitude, geofence_areas.center_longitude)
+                IS DISTINCT FROM (EXCLUDED.name, EXCLUDED.center_latitude, EXCLUDED.center_longitude)
+            RETURNING id
+        `
+
+		var id int
+		err := tx.QueryRowx(query, stop.Name, stop.Latitude, stop.Longitude, stopRadius, stop.ID).Scan(&id)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// Unchanged stops return no row
+			if err := tx.Get(&id, `SELECT id FROM geofence_areas WHERE stop_code = $1`, stop.ID); err != nil {
+				return nil, fmt.Errorf("stop %s: %v", stop.ID, err)
+			}
+		case err != nil:
+			return nil, fmt.Errorf("stop %s: %v", stop.ID, err)
+		case existing[stop.ID]:
+			stats.StopsUpdated++
+		default:
+			stats.StopsInserted++
+		}
+
+		areaIDs[stop.ID] = id
+	}
+
+	return areaIDs, nil
+}
+
+// importStopTimes copies stop times into the database and returns the
+// geofence area IDs of every trip in stop order
+func importStopTimes(tx *sqlx.Tx, stopTimes []gtfs.StopTime, areaIDs map[string]int, stats *GTFSImportStats) (map[string][]int, error) {
+	stmt, err := tx.Prepare(pq.CopyIn("stop_times",
+		"trip_id", "stop_sequence", "geofence_area_id", "arrival_time", "departure_time"))
+	if err != nil {
+		return nil, err
+	}
+
+	type sequenced struct {
+		sequence, areaID int
+	}
+	ordered := map[string][]sequenced{}
+
+	for _, st := range stopTimes {
+		areaID, ok := areaIDs[st.StopID]
+		if !ok {
+			stats.SkippedStopTimes++
+			continue
+		}
+
+		if _, err := stmt.Exec(st.TripID, st.StopSequence, areaID, st.ArrivalTime, st.DepartureTime); err != nil {
+			stmt.Close()
+			return nil, fmt.Errorf("stop time %s/%d: %v", st.TripID, st.StopSequence, err)
+		}
+		ordered[st.TripID] = append(ordered[st.TripID], sequenced{st.StopSequence, areaID})
+		stats.StopTimes++
+	}
+
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return nil, fmt.Errorf("failed to copy stop times: %v", err)
+	}
+	if err := stmt.Close(); err != nil {
+		return nil, err
+	}
+
+	stopsByTrip := make(map[string][]int, len(ordered))
+	for tripID, stops := range ordered {
+		sort.Slice(stops, func(i, j int) bool { return stops[i].sequence < stops[j].sequence })
+
+		areas := make([]int, len(stops))
+		for i, stop := range stops {
+			areas[i] = stop.areaID
+		}
+		stopsByTrip[tripID] = areas
+	}
+
+	return stopsByTrip, nil
+}
+
+// importCalendars replaces the calendars and calendar dates of every service
+// in the feed
+func importCalendars(tx *sqlx.Tx, feed *gtfs.Feed, stats *GTFSImportStats) error {
+	seen := map[string]bool{}
+	serviceIDs := []string{}
+	add := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			serviceIDs = append(serviceIDs, id)
+		}
+	}
+	for _, trip := range feed.Trips {
+		add(trip.ServiceID)
+	}
+	for _, calendar := range feed.Calendars {
+		add(calendar.ServiceID)
+	}
+	for _, date := range feed.CalendarDates {
+		add(date.ServiceID)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM calendar WHERE service_id = ANY($1)`, pq.Array(serviceIDs)); err != nil {
+		return fmt.Errorf("failed to clear calendars: %v", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM calendar_dates WHERE service_id = ANY($1)`, pq.Array(serviceIDs)); err != nil {
+		return fmt.Errorf("failed to clear calendar dates: %v", err)
+	}
+
+	for _, c := range feed.Calendars {
+		query := `
+            INSERT INTO calendar (service_id, monday, tuesday, wednesday, thursday, friday, saturday, sunday,
+                start_date, end_date)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+        `
+		w := c.Weekdays
+		_, err := tx.Exec(query, c.ServiceID,
+			w[time.Monday], w[time.Tuesday], w[time.Wednesday], w[time.Thursday], w[time.Friday],
+			w[time.Saturday], w[time.Sunday],
+			c.StartDate.Format(dateLayout), c.EndDate.Format(dateLayout))
+		if err != nil {
+			return fmt.Errorf("calendar %s: %v", c.ServiceID, err)
+		}
+		stats.Calendars++
+	}
+
+	for _, d := range feed.CalendarDates {
+		query := `
+            INSERT INTO calendar_dates (service_id, date, exception_type)
+            VALUES ($1, $2, $3)
+            ON CONFLICT (service_id, date) DO UPDATE SET exception_type = EXCLUDED.exception_type
+        `
+		if _, err := tx.Exec(query, d.ServiceID, d.Date.Format(dateLayout), d.ExceptionType); err != nil {
+			return fmt.Errorf("calendar date %s/%s: %v", d.ServiceID, d.Date.Format(dateLayout), err)
+		}
+		stats.CalendarDates++
+	}
+
+	return nil
+}
+
+// representativeTrip picks the trip with the most stops to define the route's
+// stop sequence and shape
+func representativeTrip(trips []gtfs.Trip, routeID string, stopsByTrip map[string][]int) *gtfs.Trip {
+	var best *gtfs.Trip
+	for i := range trips {
+		trip := &trips[i]
+		if trip.RouteID != routeID {
+			continue
+		}
+		if best == nil || len(stopsByTrip[trip.ID]) > len(stopsByTrip[best.ID]) {
+			best = trip
+		}
+	}
+
+	if best == nil || len(stopsByTrip[best.ID]) == 0 {
+		return nil
+	}
+	return best
+}
+
+// replaceRouteGeometry replaces route stops and shape. Without a GTFS shape the
+// stops themselves are used as shape points.
+func replaceRouteGeometry(tx *sqlx.Tx, routeID string, areaIDs []int, shape []gtfs.ShapePoint) error {
+	if _, err := tx.Exec(`DELETE FROM route_stops WHERE route_id = $1`, routeID); err != nil {
+		return err
+	}
+	for i, areaID := range areaIDs {
+		query := `
+            INSERT INTO route_stops (route_id, stop_sequence, geofence_area_id)
+            VALUES ($1, $2, $3)
+        `
+		if _, err := tx.Exec(query, routeID, i+1, areaID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM route_shapes WHERE route_id = $1`, routeID); err != nil {
+		return err
+	}
+
+	if len(shape) == 0 {
+		query := `
+            INSERT INTO route_shapes (route_id, point_sequence, latitude, longitude)
+            SELECT rs.route_id, rs.stop_sequence, ga.center_latitude, ga.center_longitude
+            FROM route_stops rs
+            JOIN geofence_areas ga ON ga.id = rs.geofence_area_id
+            WHERE rs.route_id = $1
+        `
+		_, err := tx.Exec(query, routeID)
+		return err
+	}
+
+	stmt, err := tx.Prepare(pq.CopyIn("route_shapes", "route_id", "point_sequence", "latitude", "longitude"))
+	if err != nil {
+		return err
+	}
+	for i, point := range shape {
+		if _, err := stmt.Exec(routeID, i+1, point.Latitude, point.Longitude); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return err
+	}
+	return stmt.Close()
+}
+
+// GetTrip retrieves an imported trip
+func (r *GTFSRepository) GetTrip(tripID string) (*models.Trip, error) {
+	var trip models.Trip
+
+	query := `
+        SELECT id, route_id, service_id, headsign, direction_id, shape_id
+        FROM trips
+        WHERE id = $1
+    `
+
+	if err := r.db.Get(&trip, query, tripID); err != nil {
+		return nil, err
+	}
+
+	return &trip, nil
+}
+
+// GetTripStops retrieves the scheduled stops of a trip in stop order
+func (r *GTFSRepository) GetTripStops(tripID string) ([]models.TripStop, error) {
+	stops := []models.TripStop{}
+
+	query := `
+        SELECT st.trip_id, st.stop_sequence, st.geofence_area_id, ga.stop_code, ga.name,
+            ga.center_latitude AS latitude, ga.center_longitude AS longitude, ga.radius_meters,
+            st.arrival_time, st.departure_time
+        FROM stop_times st
+        JOIN geofence_areas ga ON ga.id = st.geofence_area_id
+        WHERE st.trip_id = $1
+        ORDER BY st.stop_sequence
+    `
+
+	err := r.db.Select(&stops, query, tripID)
+	return stops, err
+}
@@ -0,0 +1,130 @@
+package repositories
+
+import (
+	"fmt"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+type HarshEventRepository struct {
+	db *sqlx.DB
+}
+
+func NewHarshEventRepository(db *sqlx.DB) *HarshEventRepository {
+	return &HarshEventRepository{db: db}
+}
+
+// InsertHarshEvent records a harsh driving event
+func (r *HarshEventRepository) InsertHarshEvent(event *models.HarshEvent) error {
+	query := `
+        INSERT INTO harsh_events (vehicle_id, driver_id, event_type, g_force, speed_kmh, source,
+            timestamp, latitude, longitude)
+        VALUES (:vehicle_id, :driver_id, :event_type, :g_force, :speed_kmh, :source,
+            :timestamp, :latitude, :longitude)
+        RETURNING id
+    `
+
+	rows, err := r.db.NamedQuery(query, event)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return rows.Scan(&event.ID)
+	}
+	return rows.Err()
+}
+
+// GetVehicleHarshEvents retrieves the harsh driving events of a vehicle within a time range
+func (r *HarshEventRepository) GetVehicleHarshEvents(vehicleID string, start, end int64) ([]models.HarshEvent, error) {
+	events := []models.HarshEvent{}
+
+	query := `
+        SELECT id, vehicle_id, driver_id, event_type, g_force, speed_kmh, source,
+            timestamp, latitude, longitude
+        FROM harsh_events
+        WHERE vehicle_id = $1 AND timestamp >= $2 AND timestamp <= $3
+        ORDER BY timestamp ASC
+    `
+
+	err := r.db.Select(&events, query, vehicleID, start, end)
+	return events, err
+}
+
+// safetyGroups maps the supported score groupings to their key
+var safetyGroups = map[string]string{
+	"vehicle": "vehicle_id",
+	"driver":  "COALESCE(driver_id, '')",
+}
+
+// SafetyCounts counts harsh driving and speeding events in [from, to] per
+// vehicle or driver, with the distance driven between accepted points at most
+// five minutes apart. The smoothed position is used when there is one.
+func (r *HarshEventRepository) SafetyCounts(groupBy string, from, to int64) ([]models.SafetyScore, error) {
+	key, ok := safetyGroups[groupBy]
+	if !ok {
+		return nil, fmt.Errorf("unsupported grouping %q", groupBy)
+	}
+
+	scores := []models.SafetyScore{}
+
+	query := fmt.Sprintf(`
+        WITH events AS (
+            SELECT vehicle_id, driver_id, event_type
+            FROM harsh_events
+            WHERE timestamp BETWEEN $1 AND $2
+            UNION ALL
+            SELECT vehicle_id, driver_id, 'speeding'
+            FROM speeding_events
+            WHERE started_at BETWEEN $1 AND $2
+        ),
+        counts AS (
+            SELECT %[1]s AS key,
+                COUNT(*) FILTER (WHERE event_type = 'harsh_braking') AS harsh_braking,
+                COUNT(*) FILTER (WHERE event_type = 'harsh_acceleration') AS harsh_acceleration,
+                COUNT(*) FILTER (WHERE event_type = 'sharp_cornering') AS sharp_cornering,
+                COUNT(*) FILTER (WHERE event_type = 'speeding') AS speeding
+            FROM events
+            GROUP BY 1
+        ),
+        points AS (
+            SELECT vehicle_id, driver_id, timestamp,
+                radians(COALESCE(smoothed_latitude, latitude)) AS lat,
+                radians(COALESCE(smoothed_longitude, longitude)) AS lon
+            FROM vehicle_locations
+            WHERE timestamp BETWEEN $1 AND $2 AND quality = 'ok'
+        ),
+        legs AS (
+            SELECT vehicle_id, driver_id, timestamp, lat, lon,
+                LAG(timestamp) OVER w AS previous_timestamp,
+                LAG(lat) OVER w AS previous_lat,
+                LAG(lon) OVER w AS previous_lon
+            FROM points
+            WINDOW w AS (PARTITION BY vehicle_id ORDER BY timestamp)
+        ),
+        distances AS (
+            SELECT %[1]s AS key,
+                SUM(2 * 6371 * asin(sqrt(
+                    power(sin((lat - previous_lat) / 2), 2) +
+                    cos(previous_lat) * cos(lat) * power(sin((lon - previous_lon) / 2), 2)
+                ))) AS km
+            FROM legs
+            WHERE timestamp - previous_timestamp <= 300
+            GROUP BY 1
+        )
+        SELECT COALESCE(c.key, d.key) AS key,
+            COALESCE(c.harsh_braking, 0) AS harsh_braking,
+            COALESCE(c.harsh_acceleration, 0) AS harsh_acceleration,
+            COALESCE(c.sharp_cornering, 0) AS sharp_cornering,
+            COALESCE(c.speeding, 0) AS speeding,
+            ROUND(COALESCE(d.km, 0)::numeric, 1) AS distance_km
+        FROM counts c
+        FULL JOIN distances d ON d.key = c.key
+        ORDER BY 1
+    `, key)
+
+	err := r.db.Select(&scores, query, from, to)
+	return scores, err
+}
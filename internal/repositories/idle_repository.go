@@ -0,0 +1,106 @@
+package repositories
+
+import (
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+type IdleRepository struct {
+	db *sqlx.DB
+}
+
+func NewIdleRepository(db *sqlx.DB) *IdleRepository {
+	return &IdleRepository{db: db}
+}
+
+// StartIdle records the start of an idle period
+func (r *IdleRepository) StartIdle(event *models.IdleEvent) error {
+	query := `
+        INSERT INTO idle_events (vehicle_id, driver_id, started_at, latitude, longitude, ignition)
+        VALUES (:vehicle_id, :driver_id, :started_at, :latitude, :longitude, :ignition)
+        RETURNING id
+    `
+
+	rows, err := r.db.NamedQuery(query, event)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return rows.Scan(&event.ID)
+	}
+	return rows.Err()
+}
+
+// EndIdle closes the open idle period of a vehicle
+func (r *IdleRepository) EndIdle(vehicleID string, endedAt int64) error {
+	query := `
+        UPDATE idle_events
+        SET ended_at = $2
+        WHERE vehicle_id = $1 AND ended_at IS NULL
+    `
+	_, err := r.db.Exec(query, vehicleID, endedAt)
+	return err
+}
+
+// GetOpenIdles retrieves idle periods that have not ended yet
+func (r *IdleRepository) GetOpenIdles() ([]models.IdleEvent, error) {
+	events := []models.IdleEvent{}
+
+	query := `
+        SELECT id, vehicle_id, driver_id, started_at, ended_at, latitude, longitude, ignition
+        FROM idle_events
+        WHERE ended_at IS NULL
+    `
+
+	err := r.db.Select(&events, query)
+	return events, err
+}
+
+// IdleReport sums the idle minutes per vehicle per day of the idle periods
+// overlapping [from, to]. Periods are clipped to the range and split at
+// midnight in the given timezone, so a period over midnight counts on both
+// days. Periods still open count until the last location of the vehicle.
+func (r *IdleRepository) IdleReport(from, to int64, vehicleID, timezone string) ([]models.IdleStats, error) {
+	stats := []models.IdleStats{}
+
+	query := `
+        WITH periods AS (
+            SELECT e.vehicle_id,
+                GREATEST(e.started_at, $1) AS started_at,
+                LEAST(COALESCE(e.ended_at, seen.last_seen, e.started_at), $2) AS ended_at
+            FROM idle_events e
+            LEFT JOIN LATERAL (
+                SELECT MAX(timestamp) AS last_seen
+                FROM vehicle_locations
+                WHERE vehicle_id = e.vehicle_id AND timestamp >= e.started_at
+            ) seen ON e.ended_at IS NULL
+            WHERE e.started_at <= $2 AND (e.ended_at IS NULL OR e.ended_at >= $1)
+              AND ($3 = '' OR e.vehicle_id = $3)
+        ),
+        days AS (
+            -- d is a local midnight without time zone, AT TIME ZONE turns it
+            -- into the instant of that midnight in $4
+            SELECT p.vehicle_id, d::date AS day,
+                GREATEST(p.started_at, EXTRACT(EPOCH FROM d AT TIME ZONE $4)) AS day_from,
+                LEAST(p.ended_at, EXTRACT(EPOCH FROM (d + interval '1 day') AT TIME ZONE $4)) AS day_to
+            FROM periods p
+            CROSS JOIN generate_series(
+                (to_timestamp(p.started_at) AT TIME ZONE $4)::date::timestamp,
+                (to_timestamp(GREATEST(p.ended_at, p.started_at)) AT TIME ZONE $4)::date::timestamp,
+                interval '1 day'
+            ) AS d
+        )
+        SELECT vehicle_id,
+            to_char(day, 'YYYY-MM-DD') AS day,
+            COUNT(*) AS events,
+            ROUND(SUM(GREATEST(day_to - day_from, 0)) / 60.0, 1) AS idle_minutes
+        FROM days
+        GROUP BY 1, 2
+        ORDER BY 2, 1
+    `
+
+	err := r.db.Select(&stats, query, from, to, vehicleID, timezone)
+	return stats, err
+}
@@ -0,0 +1,289 @@
+package repositories
+
+import (
+	"database/sql"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+type RouteRepository struct {
+	db *sqlx.DB
+}
+
+func NewRouteRepository(db *sqlx.DB) *RouteRepository {
+	return &RouteRepository{db: db}
+}
+
+// ListRoutes retrieves all routes without stops and shape
+func (r *RouteRepository) ListRoutes() ([]models.Route, error) {
+	routes := []models.Route{}
+
+	query := `
+        SELECT id, short_name, long_name, color, created_at, updated_at
+        FROM routes
+        ORDER BY id
+    `
+
+	err := r.db.Select(&routes, query)
+	return routes, err
+}
+
+// GetRoute retrieves a route without stops and shape
+func (r *RouteRepository) GetRoute(routeID string) (*models.Route, error) {
+	var route models.Route
+
+	query := `
+        SELECT id, short_name, long_name, color, created_at, updated_at
+        FROM routes
+        WHERE id = $1
+    `
+
+	if err := r.db.Get(&route, query, routeID); err != nil {
+		return nil, err
+	}
+
+	return &route, nil
+}
+
+// CreateRoute inserts a new route
+func (r *RouteRepository) CreateRoute(route *models.Route) error {
+	query := `
+        INSERT INTO routes (id, short_name, long_name, color)
+        VALUES ($1, $2, $3, $4)
+        RETURNING created_at, updated_at
+    `
+	return r.db.QueryRowx(query, route.ID, route.ShortName, route.LongName, route.Color).
+		Scan(&route.CreatedAt, &route.UpdatedAt)
+}
+
+// UpdateRoute updates the attributes of a route
+func (r *RouteRepository) UpdateRoute(route *models.Route) error {
+	query := `
+        UPDATE routes
+        SET short_name = $2, long_name = $3, color = $4, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+        RETURNING created_at, updated_at
+    `
+	return r.db.QueryRowx(query, route.ID, route.ShortName, route.LongName, route.Color).
+		Scan(&route.CreatedAt, &route.UpdatedAt)
+}
+
+// DeleteRoute deletes a route together with its stops, shape and assignments
+func (r *RouteRepository) DeleteRoute(routeID string) error {
+	result, err := r.db.Exec(`DELETE FROM routes WHERE id = $1`, routeID)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result)
+}
+
+// GetRouteStops retrieves the ordered stops of a route
+func (r *RouteRepository) GetRouteStops(routeID string) ([]models.RouteStop, error) {
+	stops := []models.RouteStop{}
+
+	query := `
+        SELECT rs.route_id, rs.stop_sequence, rs.geofence_area_id,
+            ga.name, ga.center_latitude AS latitude, ga.center_longitude AS longitude, ga.radius_meters,
+            ga.stop_code
+        FROM route_stops rs
+        JOIN geofence_areas ga ON ga.id = rs.geofence_area_id
+        WHERE rs.route_id = $1
+        ORDER BY rs.stop_sequence
+    `
+
+	err := r.db.Select(&stops, query, routeID)
+	return stops, err
+}
+
+// ReplaceRouteStops replaces the stop sequence of a route, numbering the
+// given geofence areas from 1 in order
+func (r *RouteRepository) ReplaceRouteStops(routeID string, areaIDs []int) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM route_stops WHERE route_id = $1`, routeID); err != nil {
+		return err
+	}
+
+	for i, areaID := range areaIDs {
+		query := `
+            INSERT INTO route_stops (route_id, stop_sequence, geofence_area_id)
+            VALUES ($1, $2, $3)
+        `
+		if _, err := tx.Exec(query, routeID, i+1, areaID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE routes SET updated_at = CURRENT_TIMESTAMP WHERE id = $1`, routeID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetRouteShape retrieves the ordered shape points of a route
+func (r *RouteRepository) GetRouteShape(routeID string) ([]models.ShapePoint, error) {
+	points := []models.ShapePoint{}
+
+	query := `
+        SELECT point_sequence, latitude, longitude
+        FROM route_shapes
+        WHERE route_id = $1
+        ORDER BY point_sequence
+    `
+
+	err := r.db.Select(&points, query, routeID)
+	return points, err
+}
+
+// ReplaceRouteShape replaces the shape of a route
+func (r *RouteRepository) ReplaceRouteShape(routeID string, points []models.ShapePoint) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM route_shapes WHERE route_id = $1`, routeID); err != nil {
+		return err
+	}
+
+	for i, point := range points {
+		query := `
+            INSERT INTO route_shapes (route_id, point_sequence, latitude, longitude)
+            VALUES ($1, $2, $3, $4)
+        `
+		if _, err := tx.Exec(query, routeID, i+1, point.Latitude, point.Longitude); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE routes SET updated_at = CURRENT_TIMESTAMP WHERE id = $1`, routeID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetVehicleRoute retrieves the route assignment of a vehicle
+func (r *RouteRepository) GetVehicleRoute(vehicleID string) (*models.VehicleRoute, error) {
+	var assignment models.VehicleRoute
+
+	query := `
+        SELECT vehicle_id, route_id, assigned_at, last_stop_sequence, last_stop_at, trip_id
+        FROM vehicle_routes
+        WHERE vehicle_id = $1
+    `
+
+	if err := r.db.Get(&assignment, query, vehicleID); err != nil {
+		return nil, err
+	}
+
+	return &assignment, nil
+}
+
+// ListVehicleRoutes retrieves the route assignments of all vehicles
+func (r *RouteRepository) ListVehicleRoutes() ([]models.VehicleRoute, error) {
+	assignments := []models.VehicleRoute{}
+
+	query := `
+        SELECT vehicle_id, route_id, assigned_at, last_stop_sequence, last_stop_at, trip_id
+        FROM vehicle_routes
+        ORDER BY vehicle_id
+    `
+
+	err := r.db.Select(&assignments, query)
+	return assignments, err
+}
+
+// AssignVehicle assigns a vehicle to a route and optionally the GTFS trip it
+// serves, resetting its progress
+func (r *RouteRepository) AssignVehicle(vehicleID, routeID string, tripID *string) (*models.VehicleRoute, error) {
+	var assignment models.VehicleRoute
+
+	query := `
+        INSERT INTO vehicle_routes (vehicle_id, route_id, trip_id)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (vehicle_id) DO UPDATE
+        SET route_id = EXCLUDED.route_id, trip_id = EXCLUDED.trip_id, assigned_at = CURRENT_TIMESTAMP,
+            last_stop_sequence = NULL, last_stop_at = NULL
+        RETURNING vehicle_id, route_id, assigned_at, last_stop_sequence, last_stop_at, trip_id
+    `
+
+	if err := r.db.Get(&assignment, query, vehicleID, routeID, tripID); err != nil {
+		return nil, err
+	}
+
+	return &assignment, nil
+}
+
+// UnassignVehicle removes the route assignment of a vehicle
+func (r *RouteRepository) UnassignVehicle(vehicleID string) error {
+	result, err := r.db.Exec(`DELETE FROM vehicle_routes WHERE vehicle_id = $1`, vehicleID)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result)
+}
+
+// UpdateRouteProgress stores the last stop a vehicle reached on its route
+func (r *RouteRepository) UpdateRouteProgress(vehicleID string, stopSequence int, timestamp int64) error {
+	query := `
+        UPDATE vehicle_routes
+        SET last_stop_sequence = $2, last_stop_at = $3
+        WHERE vehicle_id = $1
+    `
+	_, err := r.db.Exec(query, vehicleID, stopSequence, timestamp)
+	return err
+}
+
+// GetSegmentTravelTimes derives the median time between consecutive stops of
+// a route from the stop visits since the given timestamp. A segment is
+// measured from the departure at a stop to the arrival at the next one, so
+// dwell time is not included. Visits count for the route recorded with them,
+// not the route the vehicle is assigned to now. Gaps longer than maxSeconds
+// are ignored.
+func (r *RouteRepository) GetSegmentTravelTimes(routeID string, since int64, maxSeconds int) ([]models.SegmentTravelTime, error) {
+	segments := []models.SegmentTravelTime{}
+
+	query := `
+        WITH visits AS (
+            SELECT route_id, stop_sequence, arrived_at,
+                LAG(route_id) OVER w AS previous_route_id,
+                LAG(stop_sequence) OVER w AS previous_sequence,
+                LAG(COALESCE(departed_at, arrived_at)) OVER w AS previous_departed_at
+            FROM stop_visits
+            WHERE arrived_at >= $2
+            WINDOW w AS (PARTITION BY vehicle_id ORDER BY arrived_at)
+        )
+        SELECT previous_sequence AS from_sequence,
+            percentile_cont(0.5) WITHIN GROUP (ORDER BY arrived_at - previous_departed_at) AS seconds,
+            COUNT(*) AS samples
+        FROM visits
+        WHERE route_id = $1 AND previous_route_id = $1
+          AND stop_sequence = previous_sequence + 1
+          AND arrived_at - previous_departed_at BETWEEN 1 AND $3
+        GROUP BY previous_sequence
+        ORDER BY previous_sequence
+    `
+
+	err := r.db.Select(&segments, query, routeID, since, maxSeconds)
+	return segments, err
+}
+
+// expectOneRow turns an update or delete that matched nothing into sql.ErrNoRows
+func expectOneRow(result sql.Result) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
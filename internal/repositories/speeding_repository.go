@@ -0,0 +1,178 @@
+package repositories
+
+import (
+	"fmt"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+type SpeedingRepository struct {
+	db *sqlx.DB
+}
+
+func NewSpeedingRepository(db *sqlx.DB) *SpeedingRepository {
+	return &SpeedingRepository{db: db}
+}
+
+// GetSpeedLimitZones retrieves all speed limit zones with their points
+func (r *SpeedingRepository) GetSpeedLimitZones() ([]models.SpeedLimitZone, error) {
+	zones := []models.SpeedLimitZone{}
+
+	query := `
+        SELECT id, name, zone_type, speed_limit_kmh, width_meters, created_at
+        FROM speed_limit_zones
+        ORDER BY id
+    `
+	if err := r.db.Select(&zones, query); err != nil {
+		return nil, err
+	}
+
+	var points []struct {
+		ZoneID int `db:"zone_id"`
+		models.ShapePoint
+	}
+	query = `
+        SELECT zone_id, point_sequence, latitude, longitude
+        FROM speed_limit_zone_points
+        ORDER BY zone_id, point_sequence
+    `
+	if err := r.db.Select(&points, query); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int]*models.SpeedLimitZone, len(zones))
+	for i := range zones {
+		zones[i].Points = []models.ShapePoint{}
+		byID[zones[i].ID] = &zones[i]
+	}
+	for _, point := range points {
+		if zone, ok := byID[point.ZoneID]; ok {
+			zone.Points = append(zone.Points, point.ShapePoint)
+		}
+	}
+
+	return zones, nil
+}
+
+// CreateSpeedLimitZone inserts a zone together with its points
+func (r *SpeedingRepository) CreateSpeedLimitZone(zone *models.SpeedLimitZone) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+        INSERT INTO speed_limit_zones (name, zone_type, speed_limit_kmh, width_meters)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, created_at
+    `
+	err = tx.QueryRowx(query, zone.Name, zone.ZoneType, zone.SpeedLimitKmh, zone.WidthMeters).
+		Scan(&zone.ID, &zone.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	for i := range zone.Points {
+		zone.Points[i].Sequence = i + 1
+		query := `
+            INSERT INTO speed_limit_zone_points (zone_id, point_sequence, latitude, longitude)
+            VALUES ($1, $2, $3, $4)
+        `
+		if _, err := tx.Exec(query, zone.ID, i+1, zone.Points[i].Latitude, zone.Points[i].Longitude); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteSpeedLimitZone deletes a zone, its speeding events are kept
+func (r *SpeedingRepository) DeleteSpeedLimitZone(zoneID int) error {
+	result, err := r.db.Exec(`DELETE FROM speed_limit_zones WHERE id = $1`, zoneID)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result)
+}
+
+// StartSpeeding records the start of a speeding event
+func (r *SpeedingRepository) StartSpeeding(event *models.SpeedingEvent) error {
+	query := `
+        INSERT INTO speeding_events (vehicle_id, driver_id, zone_id, zone_name, speed_limit_kmh,
+            started_at, max_speed_kmh, latitude, longitude)
+        VALUES (:vehicle_id, :driver_id, :zone_id, :zone_name, :speed_limit_kmh,
+            :started_at, :max_speed_kmh, :latitude, :longitude)
+        RETURNING id
+    `
+
+	rows, err := r.db.NamedQuery(query, event)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return rows.Scan(&event.ID)
+	}
+	return rows.Err()
+}
+
+// EndSpeeding closes the open speeding event of a vehicle
+func (r *SpeedingRepository) EndSpeeding(vehicleID string, endedAt int64, maxSpeedKmh float64) error {
+	query := `
+        UPDATE speeding_events
+        SET ended_at = $2, max_speed_kmh = GREATEST(max_speed_kmh, $3)
+        WHERE vehicle_id = $1 AND ended_at IS NULL
+    `
+	_, err := r.db.Exec(query, vehicleID, endedAt, maxSpeedKmh)
+	return err
+}
+
+// GetOpenSpeedings retrieves speeding events that have not ended yet
+func (r *SpeedingRepository) GetOpenSpeedings() ([]models.SpeedingEvent, error) {
+	events := []models.SpeedingEvent{}
+
+	query := `
+        SELECT id, vehicle_id, driver_id, zone_id, zone_name, speed_limit_kmh,
+            started_at, ended_at, max_speed_kmh, latitude, longitude
+        FROM speeding_events
+        WHERE ended_at IS NULL
+    `
+
+	err := r.db.Select(&events, query)
+	return events, err
+}
+
+// speedingGroups maps the supported report groupings to their key
+var speedingGroups = map[string]string{
+	"vehicle": "vehicle_id",
+	"driver":  "COALESCE(driver_id, '')",
+}
+
+// SpeedingReport summarizes the speeding events started in [from, to] per
+// vehicle or driver. Events still open count until to.
+func (r *SpeedingRepository) SpeedingReport(groupBy string, from, to int64) ([]models.SpeedingStats, error) {
+	key, ok := speedingGroups[groupBy]
+	if !ok {
+		return nil, fmt.Errorf("unsupported grouping %q", groupBy)
+	}
+
+	stats := []models.SpeedingStats{}
+
+	query := fmt.Sprintf(`
+        SELECT %s AS key,
+            COUNT(*) AS events,
+            SUM(GREATEST(COALESCE(ended_at, $2) - started_at, 0)) AS total_seconds,
+            MAX(max_speed_kmh) AS max_speed_kmh,
+            MAX(max_speed_kmh - speed_limit_kmh) AS max_over_limit_kmh
+        FROM speeding_events
+        WHERE started_at BETWEEN $1 AND $2
+        GROUP BY 1
+        ORDER BY events DESC, 1
+    `, key)
+
+	err := r.db.Select(&stats, query, from, to)
+	return stats, err
+}
@@ -0,0 +1,263 @@
+package repositories
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+type StopVisitRepository struct {
+	db *sqlx.DB
+}
+
+func NewStopVisitRepository(db *sqlx.DB) *StopVisitRepository {
+	return &StopVisitRepository{db: db}
+}
+
+// InsertArrival records the arrival of a vehicle at a stop
+func (r *StopVisitRepository) InsertArrival(visit *models.StopVisit) error {
+	query := `
+        INSERT INTO stop_visits (vehicle_id, driver_id, geofence_area_id, route_id, stop_sequence, trip_id,
+            arrived_at, scheduled_arrival, scheduled_departure, arrival_delay)
+        VALUES (:vehicle_id, :driver_id, :geofence_area_id, :route_id, :stop_sequence, :trip_id,
+            :arrived_at, :scheduled_arrival, :scheduled_departure, :arrival_delay)
+        RETURNING id
+    `
+
+	rows, err := r.db.NamedQuery(query, visit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return rows.Scan(&visit.ID)
+	}
+	return rows.Err()
+}
+
+// RecordDeparture closes the open visit of a vehicle at a stop
+func (r *StopVisitRepository) RecordDeparture(vehicleID string, areaID int, departedAt int64) error {
+	query := `
+        UPDATE stop_visits
+        SET departed_at = $3, departure_delay = $3 - scheduled_departure
+        WHERE vehicle_id = $1 AND geofence_area_id = $2 AND departed_at IS NULL
+    `
+	_, err := r.db.Exec(query, vehicleID, areaID, departedAt)
+	return err
+}
+
+// GetOpenVisits retrieves visits without a departure, i.e. vehicles that are
+// currently at a stop
+func (r *StopVisitRepository) GetOpenVisits() ([]models.StopVisit, error) {
+	visits := []models.StopVisit{}
+
+	query := `
+        SELECT id, vehicle_id, geofence_area_id, route_id, stop_sequence, trip_id, arrived_at
+        FROM stop_visits
+        WHERE departed_at IS NULL
+    `
+
+	err := r.db.Select(&visits, query)
+	return visits, err
+}
+
+// GetVehicleVisits retrieves the stop visits of a vehicle within a time range
+func (r *StopVisitRepository) GetVehicleVisits(vehicleID string, start, end int64) ([]models.StopVisit, error) {
+	visits := []models.StopVisit{}
+
+	query := `
+        SELECT sv.id, sv.vehicle_id, sv.driver_id, sv.geofence_area_id, ga.name AS area_name, sv.route_id,
+            sv.stop_sequence, sv.trip_id, sv.arrived_at, sv.departed_at, sv.scheduled_arrival,
+            sv.scheduled_departure, sv.arrival_delay, sv.departure_delay
+        FROM stop_visits sv
+        JOIN geofence_areas ga ON ga.id = sv.geofence_area_id
+        WHERE sv.vehicle_id = $1 AND sv.arrived_at >= $2 AND sv.arrived_at <= $3
+        ORDER BY sv.arrived_at ASC
+    `
+
+	err := r.db.Select(&visits, query, vehicleID, start, end)
+	return visits, err
+}
+
+// FindTripStop finds the scheduled stop of a trip at a geofence area. A trip
+// can serve the same halte twice, so the stop at the given position in the
+// trip is preferred.
+func (r *StopVisitRepository) FindTripStop(tripID string, areaID, position int) (*models.ScheduledStop, error) {
+	var stop models.ScheduledStop
+
+	query := `
+        SELECT trip_id, stop_sequence, arrival_time, departure_time
+        FROM (
+            SELECT *, row_number() OVER (ORDER BY stop_sequence) AS position
+            FROM stop_times
+            WHERE trip_id = $1
+        ) st
+        WHERE geofence_area_id = $2
+        ORDER BY position = $3 DESC, stop_sequence
+        LIMIT 1
+    `
+
+	if err := r.db.Get(&stop, query, tripID, areaID, position); err != nil {
+		return nil, err
+	}
+
+	return &stop, nil
+}
+
+// FindClosestRouteStop finds the scheduled arrival closest to the given
+// seconds after midnight of date among the trips of the route that run on
+// that day. Times after midnight are also compared against the trips of the
+// previous service day. calendar_dates exceptions override the weekly
+// calendar, and services without any calendar entry (feeds imported before
+// calendars were stored) are assumed to run every day.
+func (r *StopVisitRepository) FindClosestRouteStop(routeID string, areaID int, date time.Time, secondsOfDay int) (*models.ScheduledStop, error) {
+	var stop models.ScheduledStop
+
+	query := `
+        WITH days (service_date, seconds) AS (
+            VALUES ($3::date, $4::integer), ($3::date - 1, $4::integer + 86400)
+        )
+        SELECT st.trip_id, st.stop_sequence, st.arrival_time, st.departure_time
+        FROM stop_times st
+        JOIN trips t ON t.id = st.trip_id
+        CROSS JOIN days d
+        LEFT JOIN calendar c ON c.service_id = t.service_id
+        LEFT JOIN calendar_dates cd ON cd.service_id = t.service_id AND cd.date = d.service_date
+        WHERE t.route_id = $1 AND st.geofence_area_id = $2 AND st.arrival_time IS NOT NULL
+            AND CASE
+                WHEN cd.exception_type = 1 THEN true
+                WHEN cd.exception_type = 2 THEN false
+                WHEN c.service_id IS NOT NULL THEN d.service_date BETWEEN c.start_date AND c.end_date
+                    AND (ARRAY[c.sunday, c.monday, c.tuesday, c.wednesday, c.thursday, c.friday,
+                        c.saturday])[EXTRACT(DOW FROM d.service_date)::integer + 1]
+                ELSE NOT EXISTS (SELECT 1 FROM calendar_dates x WHERE x.service_id = t.service_id)
+            END
+        ORDER BY abs(st.arrival_time - d.seconds)
+        LIMIT 1
+    `
+
+	if err := r.db.Get(&stop, query, routeID, areaID, date.Format(dateLayout), secondsOfDay); err != nil {
+		return nil, err
+	}
+
+	return &stop, nil
+}
+
+// GetPreviousRouteArrival retrieves the latest arrival at a route stop
+// before the given timestamp and not before since
+func (r *StopVisitRepository) GetPreviousRouteArrival(routeID string, stopSequence int, before, since int64) (*models.StopVisit, error) {
+	var visit models.StopVisit
+
+	query := `
+        SELECT id, vehicle_id, geofence_area_id, route_id, stop_sequence, trip_id, arrived_at
+        FROM stop_visits
+        WHERE route_id = $1 AND stop_sequence = $2 AND arrived_at < $3 AND arrived_at >= $4
+        ORDER BY arrived_at DESC
+        LIMIT 1
+    `
+
+	err := r.db.Get(&visit, query, routeID, stopSequence, before, since)
+	if err != nil {
+		return nil, err
+	}
+	return &visit, nil
+}
+
+// GetRouteHeadways retrieves, for every stop of a route, the last arrival
+// since the given timestamp and the arrival of the vehicle before it
+func (r *StopVisitRepository) GetRouteHeadways(routeID string, since int64) ([]models.StopHeadway, error) {
+	headways := []models.StopHeadway{}
+
+	query := `
+        SELECT rs.stop_sequence, rs.geofence_area_id, ga.stop_code, ga.name,
+            last.vehicle_id AS last_vehicle_id, last.arrived_at AS last_arrival,
+            previous.vehicle_id AS previous_vehicle_id, previous.arrived_at AS previous_arrival
+        FROM route_stops rs
+        JOIN geofence_areas ga ON ga.id = rs.geofence_area_id
+        LEFT JOIN LATERAL (
+            SELECT vehicle_id, arrived_at
+            FROM stop_visits
+            WHERE route_id = rs.route_id AND stop_sequence = rs.stop_sequence AND arrived_at >= $2
+            ORDER BY arrived_at DESC
+            LIMIT 1
+        ) last ON true
+        LEFT JOIN LATERAL (
+            SELECT vehicle_id, arrived_at
+            FROM stop_visits
+            WHERE route_id = rs.route_id AND stop_sequence = rs.stop_sequence AND arrived_at >= $2
+              AND arrived_at < last.arrived_at AND vehicle_id <> last.vehicle_id
+            ORDER BY arrived_at DESC
+            LIMIT 1
+        ) previous ON true
+        WHERE rs.route_id = $1
+        ORDER BY rs.stop_sequence
+    `
+
+	err := r.db.Select(&headways, query, routeID, since)
+	return headways, err
+}
+
+// onTimeGroups maps the supported report groupings to their key and name
+var onTimeGroups = map[string][2]string{
+	"route": {"COALESCE(sv.route_id, '')", "COALESCE(MAX(r.short_name), '')"},
+	"stop":  {"sv.geofence_area_id::text", "MAX(ga.name)"},
+	"day":   {"to_char(to_timestamp(sv.arrived_at) AT TIME ZONE $6, 'YYYY-MM-DD')", "''"},
+}
+
+// OnTimePerformance counts early, on time and late arrivals with a schedule
+// in [from, to], grouped by route, stop or day. Arrivals more than early
+// seconds before or late seconds after the schedule are early or late.
+func (r *StopVisitRepository) OnTimePerformance(groupBy string, from, to int64, routeID string, early, late int, timezone string) ([]models.OnTimeStats, error) {
+	group, ok := onTimeGroups[groupBy]
+	if !ok {
+		return nil, fmt.Errorf("unsupported grouping %q", groupBy)
+	}
+
+	stats := []models.OnTimeStats{}
+
+	query := fmt.Sprintf(`
+        SELECT %[1]s AS key, %[2]s AS name,
+            COUNT(*) AS total,
+            COUNT(*) FILTER (WHERE sv.arrival_delay < -$3::int) AS early,
+            COUNT(*) FILTER (WHERE sv.arrival_delay BETWEEN -$3::int AND $4::int) AS on_time,
+            COUNT(*) FILTER (WHERE sv.arrival_delay > $4::int) AS late,
+            COALESCE(AVG(sv.arrival_delay), 0) AS avg_delay_seconds
+        FROM stop_visits sv
+        JOIN geofence_areas ga ON ga.id = sv.geofence_area_id
+        LEFT JOIN routes r ON r.id = sv.route_id
+        WHERE sv.arrival_delay IS NOT NULL
+          AND sv.arrived_at BETWEEN $1 AND $2
+          AND ($5 = '' OR sv.route_id = $5)
+        GROUP BY 1
+        ORDER BY 1
+    `, group[0], group[1])
+
+	args := []interface{}{from, to, early, late, routeID}
+	if groupBy == "day" {
+		args = append(args, timezone)
+	}
+
+	if err := r.db.Select(&stats, query, args...); err != nil {
+		return nil, err
+	}
+
+	for i := range stats {
+		s := &stats[i]
+		if s.Total > 0 {
+			s.EarlyPercent = percent(s.Early, s.Total)
+			s.OnTimePercent = percent(s.OnTime, s.Total)
+			s.LatePercent = percent(s.Late, s.Total)
+		}
+	}
+
+	return stats, nil
+}
+
+// percent returns part of total as a percentage rounded to one decimal
+func percent(part, total int) float64 {
+	return math.Round(float64(part)*1000/float64(total)) / 10
+}
@@ -14,23 +14,26 @@ func NewVehicleRepository(db *sqlx.DB) *VehicleRepository {
 }
 
 // InsertLocation saves vehicle location to database
-func (r *VehicleRepository) InsertLocation(payload *models.MQTTPayload) error {
+func (r *VehicleRepository) InsertLocation(location *models.VehicleLocation) error {
 	query := `
-        INSERT INTO vehicle_locations (vehicle_id, latitude, longitude, timestamp)
-        VALUES ($1, $2, $3, $4)
+        INSERT INTO vehicle_locations (vehicle_id, latitude, longitude, timestamp, quality,
+            speed_kmh, ignition, driver_id, smoothed_latitude, smoothed_longitude, smoothed_speed_kmh, smoothed_heading)
+        VALUES (:vehicle_id, :latitude, :longitude, :timestamp, :quality,
+            :speed_kmh, :ignition, :driver_id, :smoothed_latitude, :smoothed_longitude, :smoothed_speed_kmh, :smoothed_heading)
     `
-	_, err := r.db.Exec(query, payload.VehicleID, payload.Latitude, payload.Longitude, payload.Timestamp)
+	_, err := r.db.NamedExec(query, location)
 	return err
 }
 
-// GetLastLocation retrieves the last known location of a vehicle
+// GetLastLocation retrieves the last known plausible location of a vehicle
 func (r *VehicleRepository) GetLastLocation(vehicleID string) (*models.VehicleLocation, error) {
 	var location models.VehicleLocation
 
 	query := `
-        SELECT id, vehicle_id, latitude, longitude, timestamp, created_at
+        SELECT id, vehicle_id, latitude, longitude, timestamp, quality, created_at,
+            speed_kmh, ignition, driver_id, smoothed_latitude, smoothed_longitude, smoothed_speed_kmh, smoothed_heading
         FROM vehicle_locations
-        WHERE vehicle_id = $1
+        WHERE vehicle_id = $1 AND quality = 'ok'
         ORDER BY timestamp DESC
         LIMIT 1
     `
@@ -48,7 +51,8 @@ func (r *VehicleRepository) GetLocationHistory(vehicleID string, start, end int6
 	var locations []models.VehicleLocation
 
 	query := `
-        SELECT id, vehicle_id, latitude, longitude, timestamp, created_at
+        SELECT id, vehicle_id, latitude, longitude, timestamp, quality, created_at,
+            speed_kmh, ignition, driver_id, smoothed_latitude, smoothed_longitude, smoothed_speed_kmh, smoothed_heading
         FROM vehicle_locations
         WHERE vehicle_id = $1 AND timestamp >= $2 AND timestamp <= $3
         ORDER BY timestamp ASC
@@ -62,15 +66,77 @@ func (r *VehicleRepository) GetLocationHistory(vehicleID string, start, end int6
 	return locations, nil
 }
 
+// GetLatestLocations retrieves the last plausible location of every vehicle
+func (r *VehicleRepository) GetLatestLocations() ([]models.VehicleLocation, error) {
+	locations := []models.VehicleLocation{}
+
+	query := `
+        SELECT DISTINCT ON (vehicle_id)
+            id, vehicle_id, latitude, longitude, timestamp, quality, created_at,
+            speed_kmh, ignition, driver_id, smoothed_latitude, smoothed_longitude, smoothed_speed_kmh, smoothed_heading
+        FROM vehicle_locations
+        WHERE quality = 'ok'
+        ORDER BY vehicle_id, timestamp DESC
+    `
+
+	err := r.db.Select(&locations, query)
+	return locations, err
+}
+
+// GetLastSeenAll retrieves the latest reported timestamp of every vehicle
+func (r *VehicleRepository) GetLastSeenAll() (map[string]int64, error) {
+	var rows []struct {
+		VehicleID string `db:"vehicle_id"`
+		Timestamp int64  `db:"timestamp"`
+	}
+
+	query := `
+        SELECT vehicle_id, MAX(timestamp) AS timestamp
+        FROM vehicle_locations
+        GROUP BY vehicle_id
+    `
+
+	if err := r.db.Select(&rows, query); err != nil {
+		return nil, err
+	}
+
+	lastSeen := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		lastSeen[row.VehicleID] = row.Timestamp
+	}
+
+	return lastSeen, nil
+}
+
 // GetGeofenceAreas retrieves all geofence areas from the database
 func (r *VehicleRepository) GetGeofenceAreas() ([]models.GeofenceArea, error) {
 	var areas []models.GeofenceArea
 
 	query := `
-        SELECT id, name, center_latitude, center_longitude, radius_meters
+        SELECT id, name, center_latitude, center_longitude, radius_meters, stop_code, area_type
         FROM geofence_areas
     `
 
 	err := r.db.Select(&areas, query)
 	return areas, err
 }
+
+// GetGeofenceAreaByStopID retrieves the geofence area of a stop by its GTFS
+// stop_id, or by area ID for stops that were not imported
+func (r *VehicleRepository) GetGeofenceAreaByStopID(stopID string) (*models.GeofenceArea, error) {
+	var area models.GeofenceArea
+
+	query := `
+        SELECT id, name, center_latitude, center_longitude, radius_meters, stop_code, area_type
+        FROM geofence_areas
+        WHERE stop_code = $1 OR id::text = $1
+        ORDER BY COALESCE(stop_code = $1, false) DESC
+        LIMIT 1
+    `
+
+	if err := r.db.Get(&area, query, stopID); err != nil {
+		return nil, err
+	}
+
+	return &area, nil
+}
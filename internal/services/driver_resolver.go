@@ -0,0 +1,127 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/logging"
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/asaaitika/fleetmgm-tst/internal/repositories"
+)
+
+// DriverResolver finds the driver on duty on a vehicle at a point in time and
+// handles logins and logouts from the in-bus terminal. The current shift of
+// every vehicle is cached for cacheTTL, so logins through the API are picked
+// up after at most that long.
+type DriverResolver struct {
+	repo     *repositories.DriverRepository
+	cacheTTL time.Duration
+	logger   *slog.Logger
+
+	mu     sync.Mutex
+	shifts map[string]cachedShift
+}
+
+type cachedShift struct {
+	shift    *models.DriverShift // nil when no driver was on duty
+	at       int64               // timestamp the shift was looked up for
+	loadedAt time.Time
+}
+
+func NewDriverResolver(repo *repositories.DriverRepository, cacheTTL time.Duration) *DriverResolver {
+	return &DriverResolver{
+		repo:     repo,
+		cacheTTL: cacheTTL,
+		logger:   logging.New("driver-resolver"),
+		shifts:   make(map[string]cachedShift),
+	}
+}
+
+// Resolve returns the ID of the driver on duty on the vehicle at the given
+// timestamp, or nil when nobody was logged in
+func (r *DriverResolver) Resolve(vehicleID string, timestamp int64) (*string, error) {
+	r.mu.Lock()
+	cached, ok := r.shifts[vehicleID]
+	r.mu.Unlock()
+
+	if ok && time.Since(cached.loadedAt) < r.cacheTTL {
+		if cached.shift != nil && cached.shift.Covers(timestamp) {
+			return &cached.shift.DriverID, nil
+		}
+		// Nobody on duty, and nobody logged in after the cached lookup
+		if cached.shift == nil && timestamp >= cached.at {
+			return nil, nil
+		}
+	}
+
+	shift, err := r.repo.GetShiftAt(vehicleID, timestamp)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.shifts[vehicleID] = cachedShift{shift: shift, at: timestamp, loadedAt: time.Now()}
+	r.mu.Unlock()
+
+	if shift == nil {
+		return nil, nil
+	}
+	return &shift.DriverID, nil
+}
+
+// Handle processes a login or logout sent by the terminal of a vehicle. Only
+// active drivers can log in, and a logout only ends a shift on that vehicle.
+func (r *DriverResolver) Handle(vehicleID string, payload *models.DriverShiftPayload) error {
+	switch payload.Action {
+	case models.ShiftLogin:
+		driver, err := r.repo.GetDriver(payload.DriverID)
+		if errors.Is(err, sql.ErrNoRows) {
+			r.logger.Warn("Unknown driver tried to log in", "driver_id", payload.DriverID, "vehicle_id", vehicleID)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get driver: %v", err)
+		}
+		if !driver.Active {
+			r.logger.Warn("Inactive driver tried to log in", "driver_id", payload.DriverID, "vehicle_id", vehicleID)
+			return nil
+		}
+
+		shift := &models.DriverShift{
+			DriverID:  payload.DriverID,
+			VehicleID: vehicleID,
+			StartedAt: payload.Timestamp,
+			Source:    "terminal",
+		}
+		if err := r.repo.StartShift(shift); err != nil {
+			return fmt.Errorf("failed to start shift: %v", err)
+		}
+		r.logger.Info("Driver logged in", "driver_id", payload.DriverID, "vehicle_id", vehicleID)
+
+	case models.ShiftLogout:
+		shift, err := r.repo.EndShift(payload.DriverID, vehicleID, payload.Timestamp)
+		if errors.Is(err, sql.ErrNoRows) {
+			r.logger.Warn("Driver logged out but was not on duty on this vehicle", "driver_id", payload.DriverID,
+				"vehicle_id", vehicleID)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to end shift: %v", err)
+		}
+		r.logger.Info("Driver logged out", "driver_id", payload.DriverID, "vehicle_id", shift.VehicleID)
+
+	default:
+		return fmt.Errorf("unknown action %q", payload.Action)
+	}
+
+	// A login also ends the driver's shift on another vehicle
+	r.mu.Lock()
+	r.shifts = make(map[string]cachedShift)
+	r.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,311 @@
+package services
+
+import (
+	"database/sql"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/asaaitika/fleetmgm-tst/internal/repositories"
+)
+
+// Below this speed a vehicle is considered standing and its live speed is not
+// used to predict the current segment
+const minLiveSpeedKmh = 5.0
+
+// ETAConfig tunes arrival predictions
+type ETAConfig struct {
+	HistoryWindow   time.Duration // how far back segment travel times are learned
+	DefaultSpeedKmh float64       // used for segments without history
+	Dwell           time.Duration // time spent at every intermediate stop
+	CacheTTL        time.Duration // how long route geometry and history are cached
+}
+
+// ETAService predicts arrival times at upcoming stops from the vehicle's
+// position along the route shape, historical segment travel times and its
+// live speed
+type ETAService struct {
+	vehicleRepo    *repositories.VehicleRepository
+	routeRepo      *repositories.RouteRepository
+	geofence       *GeofenceService
+	presence       PresencePolicy
+	positionSource string
+	cfg            ETAConfig
+
+	mu     sync.Mutex
+	routes map[string]*routeGeometry
+}
+
+// routeGeometry is a route's stops placed along its shape together with the
+// learned segment travel times
+type routeGeometry struct {
+	stops          []models.RouteStop
+	shape          []models.ShapePoint
+	lengths        []float64
+	stopsAlong     []PolylineProjection
+	segmentSeconds map[int]float64 // by stop sequence the segment starts at
+	loadedAt       time.Time
+}
+
+func NewETAService(vehicleRepo *repositories.VehicleRepository, routeRepo *repositories.RouteRepository,
+	presence PresencePolicy, positionSource string, cfg ETAConfig) *ETAService {
+	return &ETAService{
+		vehicleRepo:    vehicleRepo,
+		routeRepo:      routeRepo,
+		geofence:       NewGeofenceService(),
+		presence:       presence,
+		positionSource: positionSource,
+		cfg:            cfg,
+		routes:         make(map[string]*routeGeometry),
+	}
+}
+
+// VehicleETA predicts the arrivals of a vehicle at the remaining stops of its
+// route. It returns sql.ErrNoRows when the vehicle has no route, no location
+// or is offline.
+func (s *ETAService) VehicleETA(vehicleID string, now time.Time) (*models.VehicleETA, error) {
+	assignment, err := s.routeRepo.GetVehicleRoute(vehicleID)
+	if err != nil {
+		return nil, err
+	}
+
+	location, err := s.vehicleRepo.GetLastLocation(vehicleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if status, _ := s.presence.Status(location.Timestamp, now); status == PresenceOffline {
+		return nil, sql.ErrNoRows
+	}
+
+	geometry, err := s.geometry(assignment.RouteID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.VehicleETA{
+		VehicleID: vehicleID,
+		RouteID:   assignment.RouteID,
+		Timestamp: location.Timestamp,
+		Stops:     s.predict(geometry, location, assignment.LastStopSequence, now),
+	}, nil
+}
+
+// StopArrivals predicts the next arrival of every online vehicle whose route
+// serves the given geofence area, soonest first
+func (s *ETAService) StopArrivals(areaID int, now time.Time) ([]models.StopArrival, error) {
+	assignments, err := s.routeRepo.ListVehicleRoutes()
+	if err != nil {
+		return nil, err
+	}
+
+	locations, err := s.vehicleRepo.GetLatestLocations()
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[string]*models.VehicleLocation, len(locations))
+	for i := range locations {
+		latest[locations[i].VehicleID] = &locations[i]
+	}
+
+	arrivals := []models.StopArrival{}
+	for _, assignment := range assignments {
+		location, ok := latest[assignment.VehicleID]
+		if !ok {
+			continue
+		}
+		if status, _ := s.presence.Status(location.Timestamp, now); status == PresenceOffline {
+			continue
+		}
+
+		geometry, err := s.geometry(assignment.RouteID, now)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, eta := range s.predict(geometry, location, assignment.LastStopSequence, now) {
+			if eta.GeofenceAreaID != areaID {
+				continue
+			}
+			arrivals = append(arrivals, models.StopArrival{
+				VehicleID:    assignment.VehicleID,
+				RouteID:      assignment.RouteID,
+				StopSequence: eta.StopSequence,
+				ETA:          eta.ETA,
+				ETASeconds:   eta.ETASeconds,
+			})
+			break
+		}
+	}
+
+	sort.Slice(arrivals, func(i, j int) bool { return arrivals[i].ETA < arrivals[j].ETA })
+	return arrivals, nil
+}
+
+// predict walks the remaining stops from the vehicle's position. The current
+// segment blends the historical time for the part still ahead with the time
+// at live speed, later segments use history plus dwell time.
+func (s *ETAService) predict(g *routeGeometry, location *models.VehicleLocation, lastSequence *int, now time.Time) []models.StopETA {
+	etas := []models.StopETA{}
+	if len(g.stops) < 2 || len(g.shape) < 2 {
+		return etas
+	}
+
+	reached := -1
+	if lastSequence != nil {
+		for i, stop := range g.stops {
+			if stop.StopSequence == *lastSequence {
+				reached = i
+				break
+			}
+		}
+	}
+
+	// Routes returning to their first halte start over after the last stop
+	last := len(g.stops) - 1
+	if reached == last && g.stops[0].GeofenceAreaID == g.stops[last].GeofenceAreaID {
+		reached = 0
+	}
+	if reached == last {
+		return etas
+	}
+
+	lat, lon := location.Position(s.positionSource)
+
+	minAlong, maxAlong := 0.0, math.Inf(1)
+	if reached >= 0 {
+		minAlong = g.stopsAlong[reached].Along
+		maxAlong = g.stopsAlong[min(reached+2, last)].Along
+	}
+	position := s.geofence.ProjectOntoPolyline(g.shape, g.lengths, lat, lon, minAlong, maxAlong)
+
+	// Skip stops the vehicle already passed without reporting a point inside
+	next := reached + 1
+	for next < last && g.stopsAlong[next].Along < position.Along {
+		next++
+	}
+
+	liveSpeed := 0.0
+	if location.SmoothedSpeedKmh != nil && *location.SmoothedSpeedKmh >= minLiveSpeedKmh {
+		liveSpeed = *location.SmoothedSpeedKmh / 3.6
+	}
+	defaultSpeed := s.cfg.DefaultSpeedKmh / 3.6
+
+	elapsed := 0.0
+	for i := next; i <= last; i++ {
+		// Before the first stop there is no segment to learn from
+		segmentLength, historical, known := 0.0, 0.0, false
+		if i > 0 {
+			segmentLength = g.stopsAlong[i].Along - g.stopsAlong[i-1].Along
+			historical, known = g.segmentSeconds[g.stops[i-1].StopSequence]
+		}
+
+		if i == next {
+			remaining := math.Max(0, g.stopsAlong[i].Along-position.Along)
+
+			var estimates []float64
+			if known {
+				fraction := 1.0
+				if segmentLength > 0 {
+					fraction = math.Min(1, remaining/segmentLength)
+				}
+				estimates = append(estimates, historical*fraction)
+			}
+			if liveSpeed > 0 {
+				estimates = append(estimates, remaining/liveSpeed)
+			}
+			if len(estimates) == 0 {
+				estimates = append(estimates, remaining/defaultSpeed)
+			}
+
+			for _, estimate := range estimates {
+				elapsed += estimate / float64(len(estimates))
+			}
+		} else {
+			elapsed += s.cfg.Dwell.Seconds()
+			if known {
+				elapsed += historical
+			} else {
+				elapsed += segmentLength / defaultSpeed
+			}
+		}
+
+		stop := g.stops[i]
+		eta := location.Timestamp + int64(math.Round(elapsed))
+		etas = append(etas, models.StopETA{
+			StopSequence:   stop.StopSequence,
+			StopID:         stopID(stop.StopCode, stop.GeofenceAreaID),
+			GeofenceAreaID: stop.GeofenceAreaID,
+			Name:           stop.Name,
+			DistanceMeters: math.Round(math.Max(0, g.stopsAlong[i].Along-position.Along)),
+			ETA:            eta,
+			ETASeconds:     max(0, eta-now.Unix()),
+		})
+	}
+
+	return etas
+}
+
+// geometry returns the cached geometry of a route, reloading it after CacheTTL
+func (s *ETAService) geometry(routeID string, now time.Time) (*routeGeometry, error) {
+	s.mu.Lock()
+	cached, ok := s.routes[routeID]
+	s.mu.Unlock()
+
+	if ok && now.Sub(cached.loadedAt) < s.cfg.CacheTTL {
+		return cached, nil
+	}
+
+	stops, err := s.routeRepo.GetRouteStops(routeID)
+	if err != nil {
+		return nil, err
+	}
+
+	shape, err := s.routeRepo.GetRouteShape(routeID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a shape the bus is assumed to drive straight between stops
+	if len(shape) < 2 {
+		shape = StopShape(stops)
+	}
+
+	since := now.Add(-s.cfg.HistoryWindow).Unix()
+	maxSeconds := int(time.Hour.Seconds())
+	segments, err := s.routeRepo.GetSegmentTravelTimes(routeID, since, maxSeconds)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &routeGeometry{
+		stops:          stops,
+		shape:          shape,
+		lengths:        s.geofence.PolylineLengths(shape),
+		segmentSeconds: make(map[int]float64, len(segments)),
+		loadedAt:       now,
+	}
+	for _, segment := range segments {
+		g.segmentSeconds[segment.FromSequence] = segment.Seconds
+	}
+
+	// Place stops in order so a halte served in both directions is matched to
+	// the right side of the shape
+	if len(shape) >= 2 {
+		minAlong := 0.0
+		for _, stop := range stops {
+			projection := s.geofence.ProjectOntoPolyline(shape, g.lengths, stop.Latitude, stop.Longitude, minAlong, math.Inf(1))
+			g.stopsAlong = append(g.stopsAlong, projection)
+			minAlong = projection.Along
+		}
+	}
+
+	s.mu.Lock()
+	s.routes[routeID] = g
+	s.mu.Unlock()
+
+	return g, nil
+}
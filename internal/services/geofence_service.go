@@ -2,6 +2,8 @@ package services
 
 import (
 	"math"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
 )
 
 type GeofenceService struct{}
@@ -36,3 +38,79 @@ func (s *GeofenceService) CalculateDistance(lat1, lon1, lat2, lon2 float64) floa
 
 	return distance
 }
+
+// PolylineProjection is where a point falls on a polyline
+type PolylineProjection struct {
+	Segment int     // index of the first vertex of the closest segment
+	Along   float64 // meters from the start of the polyline
+	Offset  float64 // meters between the point and the polyline
+}
+
+// PolylineLengths returns the distance in meters from the start of the
+// polyline to every vertex
+func (s *GeofenceService) PolylineLengths(points []models.ShapePoint) []float64 {
+	lengths := make([]float64, len(points))
+	for i := 1; i < len(points); i++ {
+		lengths[i] = lengths[i-1] + s.CalculateDistance(
+			points[i-1].Latitude, points[i-1].Longitude, points[i].Latitude, points[i].Longitude)
+	}
+	return lengths
+}
+
+// ProjectOntoPolyline finds the closest point on a polyline between minAlong
+// and maxAlong meters from its start. Limiting the range keeps routes that run
+// back over the same road from snapping to the wrong direction. lengths comes
+// from PolylineLengths. The polyline needs at least 2 points.
+func (s *GeofenceService) ProjectOntoPolyline(points []models.ShapePoint, lengths []float64, lat, lon, minAlong, maxAlong float64) PolylineProjection {
+	best := PolylineProjection{Segment: -1, Offset: math.Inf(1)}
+	for i := 0; i < len(points)-1; i++ {
+		start, end := lengths[i], lengths[i+1]
+		if end < minAlong || start > maxAlong {
+			continue
+		}
+
+		// Local flat projection around the segment start, accurate enough for
+		// segments of a few kilometers
+		a, b := points[i], points[i+1]
+		cosLat := math.Cos(a.Latitude * math.Pi / 180)
+		bx := (b.Longitude - a.Longitude) * metersPerDegreeLat * cosLat
+		by := (b.Latitude - a.Latitude) * metersPerDegreeLat
+		px := (lon - a.Longitude) * metersPerDegreeLat * cosLat
+		py := (lat - a.Latitude) * metersPerDegreeLat
+
+		t, tMin, tMax := 0.0, 0.0, 1.0
+		if length := end - start; length > 0 {
+			tMin = math.Max(0, (minAlong-start)/length)
+			tMax = math.Min(1, (maxAlong-start)/length)
+		}
+		if squared := bx*bx + by*by; squared > 0 {
+			t = (px*bx + py*by) / squared
+		}
+		t = math.Max(tMin, math.Min(tMax, t))
+
+		offset := math.Hypot(px-t*bx, py-t*by)
+		if offset < best.Offset {
+			best = PolylineProjection{
+				Segment: i,
+				Along:   start + t*(end-start),
+				Offset:  offset,
+			}
+		}
+	}
+
+	return best
+}
+
+// ContainsPoint reports whether a point lies inside a polygon using ray
+// casting. The polygon is closed implicitly between its last and first point.
+func (s *GeofenceService) ContainsPoint(polygon []models.ShapePoint, lat, lon float64) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a.Latitude > lat) != (b.Latitude > lat) &&
+			lon < (b.Longitude-a.Longitude)*(lat-a.Latitude)/(b.Latitude-a.Latitude)+a.Longitude {
+			inside = !inside
+		}
+	}
+	return inside
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"sync"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+)
+
+// GeofenceExitMargin is how far beyond the radius a vehicle must be before it
+// counts as having left an area, so GPS jitter at the edge does not produce a
+// burst of entries and exits
+const GeofenceExitMargin = 10.0
+
+// GeofenceTracker remembers which areas every vehicle is inside, turning
+// positions into entry and exit transitions
+type GeofenceTracker struct {
+	mu     sync.Mutex
+	inside map[string]map[int]int64 // vehicle -> area -> last timestamp inside
+}
+
+// GeofenceExit is an area a vehicle left and the last time it was inside
+type GeofenceExit struct {
+	AreaID     int
+	LastInside int64
+}
+
+func NewGeofenceTracker() *GeofenceTracker {
+	return &GeofenceTracker{
+		inside: make(map[string]map[int]int64),
+	}
+}
+
+// Seed restores the state from visits without a departure, e.g. after a restart
+func (t *GeofenceTracker) Seed(visits []models.StopVisit) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, visit := range visits {
+		if t.inside[visit.VehicleID] == nil {
+			t.inside[visit.VehicleID] = make(map[int]int64)
+		}
+		t.inside[visit.VehicleID][visit.GeofenceAreaID] = visit.ArrivedAt
+	}
+}
+
+// Update applies one position. inside holds the areas within their radius,
+// held the areas within radius plus GeofenceExitMargin. It returns the areas
+// the vehicle entered and left.
+func (t *GeofenceTracker) Update(vehicleID string, timestamp int64, inside, held map[int]bool) ([]int, []GeofenceExit) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state := t.inside[vehicleID]
+	if state == nil {
+		state = make(map[int]int64)
+		t.inside[vehicleID] = state
+	}
+
+	var exited []GeofenceExit
+	for areaID, lastInside := range state {
+		if !held[areaID] {
+			exited = append(exited, GeofenceExit{AreaID: areaID, LastInside: lastInside})
+			delete(state, areaID)
+		}
+	}
+
+	var entered []int
+	for areaID := range inside {
+		if _, ok := state[areaID]; !ok {
+			entered = append(entered, areaID)
+		}
+		state[areaID] = timestamp
+	}
+
+	return entered, exited
+}
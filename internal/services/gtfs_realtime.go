@@ -0,0 +1,373 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	gtfsrt "github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/asaaitika/fleetmgm-tst/internal/repositories"
+	"google.golang.org/protobuf/proto"
+)
+
+// GTFSRealtimeService builds GTFS-Realtime VehiclePositions and TripUpdates
+// feeds from the latest vehicle locations, route assignments and stop arrivals
+type GTFSRealtimeService struct {
+	vehicleRepo    *repositories.VehicleRepository
+	routeRepo      *repositories.RouteRepository
+	gtfsRepo       *repositories.GTFSRepository
+	geofence       *GeofenceService
+	presence       PresencePolicy
+	positionSource string
+	timezone       *time.Location
+}
+
+// feedStop is a stop of the route or trip a vehicle is serving. Times are
+// seconds after midnight of the service day and only known for trips.
+type feedStop struct {
+	sequence     int
+	stopID       string
+	latitude     float64
+	longitude    float64
+	radiusMeters int
+	arrival      *int
+	departure    *int
+	areaID       int
+}
+
+// vehicleSnapshot is everything the feeds need to know about one vehicle
+type vehicleSnapshot struct {
+	location   models.VehicleLocation
+	assignment *models.VehicleRoute
+	trip       *models.Trip
+	stops      []feedStop
+	reached    int // index in stops of the last reached stop, -1 if none
+}
+
+func NewGTFSRealtimeService(vehicleRepo *repositories.VehicleRepository, routeRepo *repositories.RouteRepository,
+	gtfsRepo *repositories.GTFSRepository, presence PresencePolicy, positionSource string, timezone *time.Location) *GTFSRealtimeService {
+	return &GTFSRealtimeService{
+		vehicleRepo:    vehicleRepo,
+		routeRepo:      routeRepo,
+		gtfsRepo:       gtfsRepo,
+		geofence:       NewGeofenceService(),
+		presence:       presence,
+		positionSource: positionSource,
+		timezone:       timezone,
+	}
+}
+
+// VehiclePositions builds a full dataset VehiclePositions feed. Offline
+// vehicles are left out.
+func (s *GTFSRealtimeService) VehiclePositions(now time.Time) (*gtfsrt.FeedMessage, error) {
+	snapshots, err := s.snapshot(now)
+	if err != nil {
+		return nil, err
+	}
+
+	feed := newFeed(now)
+	for _, v := range snapshots {
+		lat, lon := v.location.Position(s.positionSource)
+
+		position := &gtfsrt.Position{
+			Latitude:  proto.Float32(float32(lat)),
+			Longitude: proto.Float32(float32(lon)),
+		}
+		if v.location.SmoothedHeading != nil {
+			position.Bearing = proto.Float32(float32(*v.location.SmoothedHeading))
+		}
+		if v.location.SmoothedSpeedKmh != nil {
+			position.Speed = proto.Float32(float32(*v.location.SmoothedSpeedKmh / 3.6))
+		}
+
+		vehicle := &gtfsrt.VehiclePosition{
+			Trip:      s.tripDescriptor(v),
+			Vehicle:   vehicleDescriptor(v.location.VehicleID),
+			Position:  position,
+			Timestamp: proto.Uint64(uint64(v.location.Timestamp)),
+		}
+
+		if stop, status, ok := s.currentStop(v, lat, lon); ok {
+			vehicle.CurrentStopSequence = proto.Uint32(uint32(stop.sequence))
+			vehicle.StopId = proto.String(stop.stopID)
+			vehicle.CurrentStatus = status.Enum()
+		}
+
+		feed.Entity = append(feed.Entity, &gtfsrt.FeedEntity{
+			Id:      proto.String(v.location.VehicleID),
+			Vehicle: vehicle,
+		})
+	}
+
+	return feed, nil
+}
+
+// TripUpdates builds a full dataset TripUpdates feed for vehicles serving a
+// GTFS trip. The delay at the last reached stop is propagated to the
+// remaining stops of the trip.
+func (s *GTFSRealtimeService) TripUpdates(now time.Time) (*gtfsrt.FeedMessage, error) {
+	snapshots, err := s.snapshot(now)
+	if err != nil {
+		return nil, err
+	}
+
+	feed := newFeed(now)
+	for _, v := range snapshots {
+		if v.trip == nil || v.reached < 0 || v.assignment.LastStopAt == nil {
+			continue
+		}
+
+		reached := v.stops[v.reached]
+		scheduled := scheduledTime(reached)
+		if scheduled == nil {
+			continue
+		}
+
+		arrivedAt := *v.assignment.LastStopAt
+		serviceDay := ServiceDayStart(arrivedAt, *scheduled, s.timezone)
+		delay := arrivedAt - (serviceDay.Unix() + int64(*scheduled))
+
+		update := &gtfsrt.TripUpdate{
+			Trip:      s.tripDescriptor(v),
+			Vehicle:   vehicleDescriptor(v.location.VehicleID),
+			Timestamp: proto.Uint64(uint64(v.location.Timestamp)),
+			Delay:     proto.Int32(int32(delay)),
+		}
+
+		for _, stop := range v.stops[v.reached:] {
+			stopUpdate := &gtfsrt.TripUpdate_StopTimeUpdate{
+				StopSequence: proto.Uint32(uint32(stop.sequence)),
+				StopId:       proto.String(stop.stopID),
+			}
+			if stop.arrival != nil {
+				stopUpdate.Arrival = stopTimeEvent(serviceDay, *stop.arrival, delay)
+			}
+			if stop.departure != nil {
+				stopUpdate.Departure = stopTimeEvent(serviceDay, *stop.departure, delay)
+			}
+			if stopUpdate.Arrival == nil && stopUpdate.Departure == nil {
+				continue
+			}
+			update.StopTimeUpdate = append(update.StopTimeUpdate, stopUpdate)
+		}
+
+		feed.Entity = append(feed.Entity, &gtfsrt.FeedEntity{
+			Id:         proto.String(v.location.VehicleID),
+			TripUpdate: update,
+		})
+	}
+
+	return feed, nil
+}
+
+// snapshot loads the latest state of every vehicle that is not offline
+func (s *GTFSRealtimeService) snapshot(now time.Time) ([]vehicleSnapshot, error) {
+	locations, err := s.vehicleRepo.GetLatestLocations()
+	if err != nil {
+		return nil, err
+	}
+
+	assignments, err := s.routeRepo.ListVehicleRoutes()
+	if err != nil {
+		return nil, err
+	}
+
+	byVehicle := make(map[string]*models.VehicleRoute, len(assignments))
+	for i := range assignments {
+		byVehicle[assignments[i].VehicleID] = &assignments[i]
+	}
+
+	// Vehicles share routes and trips, load each once per feed
+	routeStops := map[string][]models.RouteStop{}
+	trips := map[string]*models.Trip{}
+	tripStops := map[string][]models.TripStop{}
+
+	snapshots := make([]vehicleSnapshot, 0, len(locations))
+	for _, location := range locations {
+		if status, _ := s.presence.Status(location.Timestamp, now); status == PresenceOffline {
+			continue
+		}
+
+		v := vehicleSnapshot{location: location, assignment: byVehicle[location.VehicleID], reached: -1}
+		if v.assignment == nil {
+			snapshots = append(snapshots, v)
+			continue
+		}
+
+		routeID := v.assignment.RouteID
+		if _, ok := routeStops[routeID]; !ok {
+			if routeStops[routeID], err = s.routeRepo.GetRouteStops(routeID); err != nil {
+				return nil, err
+			}
+		}
+
+		if tripID := v.assignment.TripID; tripID != nil {
+			if _, ok := trips[*tripID]; !ok {
+				trip, err := s.gtfsRepo.GetTrip(*tripID)
+				if err != nil && !errors.Is(err, sql.ErrNoRows) {
+					return nil, err
+				}
+				trips[*tripID] = trip
+
+				if trip != nil {
+					if tripStops[*tripID], err = s.gtfsRepo.GetTripStops(*tripID); err != nil {
+						return nil, err
+					}
+				}
+			}
+			v.trip = trips[*tripID]
+		}
+
+		if v.trip != nil {
+			for _, stop := range tripStops[v.trip.ID] {
+				v.stops = append(v.stops, feedStop{
+					sequence:     stop.StopSequence,
+					stopID:       stopID(stop.StopCode, stop.GeofenceAreaID),
+					latitude:     stop.Latitude,
+					longitude:    stop.Longitude,
+					radiusMeters: stop.RadiusMeters,
+					arrival:      stop.ArrivalTime,
+					departure:    stop.DepartureTime,
+					areaID:       stop.GeofenceAreaID,
+				})
+			}
+		} else {
+			for _, stop := range routeStops[routeID] {
+				v.stops = append(v.stops, feedStop{
+					sequence:     stop.StopSequence,
+					stopID:       stopID(stop.StopCode, stop.GeofenceAreaID),
+					latitude:     stop.Latitude,
+					longitude:    stop.Longitude,
+					radiusMeters: stop.RadiusMeters,
+					areaID:       stop.GeofenceAreaID,
+				})
+			}
+		}
+
+		v.reached = reachedStop(v.stops, routeStops[routeID], v.assignment.LastStopSequence)
+		snapshots = append(snapshots, v)
+	}
+
+	return snapshots, nil
+}
+
+// reachedStop finds the last reached route stop in the stops of the vehicle.
+// Route stops are numbered from 1 while GTFS stop sequences may have gaps, so
+// the stop at the same position is preferred and otherwise the first stop at
+// the same halte.
+func reachedStop(stops []feedStop, routeStops []models.RouteStop, lastSequence *int) int {
+	if lastSequence == nil {
+		return -1
+	}
+
+	areaID := 0
+	for _, stop := range routeStops {
+		if stop.StopSequence == *lastSequence {
+			areaID = stop.GeofenceAreaID
+			break
+		}
+	}
+	if areaID == 0 {
+		return -1
+	}
+
+	if i := *lastSequence - 1; i < len(stops) && stops[i].areaID == areaID {
+		return i
+	}
+	for i, stop := range stops {
+		if stop.areaID == areaID {
+			return i
+		}
+	}
+	return -1
+}
+
+// currentStop returns the stop the vehicle is at, or the next stop while it
+// is travelling. Routes without a trip wrap around after the last stop.
+func (s *GTFSRealtimeService) currentStop(v vehicleSnapshot, lat, lon float64) (feedStop, gtfsrt.VehiclePosition_VehicleStopStatus, bool) {
+	if v.reached < 0 {
+		return feedStop{}, 0, false
+	}
+
+	stop := v.stops[v.reached]
+	distance := s.geofence.CalculateDistance(lat, lon, stop.latitude, stop.longitude)
+	if distance <= float64(stop.radiusMeters) {
+		return stop, gtfsrt.VehiclePosition_STOPPED_AT, true
+	}
+
+	next := v.reached + 1
+	if next == len(v.stops) {
+		if v.trip != nil {
+			return feedStop{}, 0, false
+		}
+		next = 0
+	}
+	return v.stops[next], gtfsrt.VehiclePosition_IN_TRANSIT_TO, true
+}
+
+func (s *GTFSRealtimeService) tripDescriptor(v vehicleSnapshot) *gtfsrt.TripDescriptor {
+	if v.assignment == nil {
+		return nil
+	}
+
+	descriptor := &gtfsrt.TripDescriptor{
+		RouteId: proto.String(v.assignment.RouteID),
+	}
+	if v.trip == nil {
+		return descriptor
+	}
+
+	descriptor.TripId = proto.String(v.trip.ID)
+	descriptor.DirectionId = proto.Uint32(uint32(v.trip.DirectionID))
+
+	if v.reached >= 0 && v.assignment.LastStopAt != nil {
+		if scheduled := scheduledTime(v.stops[v.reached]); scheduled != nil {
+			day := ServiceDayStart(*v.assignment.LastStopAt, *scheduled, s.timezone)
+			descriptor.StartDate = proto.String(day.Add(12 * time.Hour).Format("20060102"))
+		}
+	}
+
+	return descriptor
+}
+
+func scheduledTime(stop feedStop) *int {
+	if stop.arrival != nil {
+		return stop.arrival
+	}
+	return stop.departure
+}
+
+func stopTimeEvent(serviceDay time.Time, scheduled int, delay int64) *gtfsrt.TripUpdate_StopTimeEvent {
+	return &gtfsrt.TripUpdate_StopTimeEvent{
+		Delay: proto.Int32(int32(delay)),
+		Time:  proto.Int64(serviceDay.Unix() + int64(scheduled) + delay),
+	}
+}
+
+func vehicleDescriptor(vehicleID string) *gtfsrt.VehicleDescriptor {
+	return &gtfsrt.VehicleDescriptor{
+		Id:    proto.String(vehicleID),
+		Label: proto.String(vehicleID),
+	}
+}
+
+// stopID is the GTFS stop_id of a geofence area, falling back to its ID for
+// areas that were not imported from GTFS
+func stopID(stopCode *string, areaID int) string {
+	if stopCode != nil {
+		return *stopCode
+	}
+	return strconv.Itoa(areaID)
+}
+
+func newFeed(now time.Time) *gtfsrt.FeedMessage {
+	return &gtfsrt.FeedMessage{
+		Header: &gtfsrt.FeedHeader{
+			GtfsRealtimeVersion: proto.String("2.0"),
+			Incrementality:      gtfsrt.FeedHeader_FULL_DATASET.Enum(),
+			Timestamp:           proto.Uint64(uint64(now.Unix())),
+		},
+	}
+}
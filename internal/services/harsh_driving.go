@@ -0,0 +1,232 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/logging"
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/asaaitika/fleetmgm-tst/internal/repositories"
+)
+
+const (
+	standardGravity = 9.80665
+
+	// Speed deltas are only trusted between points closer together than this
+	maxHarshDeriveGap = 5 * time.Second
+
+	// One maneuver spans several points, later points of the same kind are
+	// not reported again within this time
+	harshEventCooldown = 10 * time.Second
+
+	// Safety scores of shorter distances are computed as if this far was driven
+	minSafetyDistanceKm = 20.0
+)
+
+// Safety score penalty per event per 100 km
+var safetyPenalties = map[string]float64{
+	models.HarshBraking:      5,
+	models.HarshAcceleration: 3,
+	models.SharpCornering:    4,
+	"speeding":               5,
+}
+
+// HarshPolicy holds the g-force thresholds for harsh driving
+type HarshPolicy struct {
+	BrakingG      float64
+	AccelerationG float64
+	CorneringG    float64
+}
+
+// HarshDrivingDetector detects harsh braking, acceleration and cornering from
+// the accelerometer, or from the change in speed and heading between points
+// when the device has none, and publishes an event for every maneuver
+type HarshDrivingDetector struct {
+	repo     *repositories.HarshEventRepository
+	policy   HarshPolicy
+	rabbitmq *RabbitMQService
+	logger   *slog.Logger
+
+	mu       sync.Mutex
+	vehicles map[string]*harshState
+}
+
+type harshState struct {
+	speed     *float64 // last measured speed in km/h
+	speedAt   int64
+	heading   *float64
+	headingAt int64
+	lastEvent map[string]int64 // by event type
+}
+
+func NewHarshDrivingDetector(repo *repositories.HarshEventRepository, policy HarshPolicy) *HarshDrivingDetector {
+	return &HarshDrivingDetector{
+		repo:     repo,
+		policy:   policy,
+		logger:   logging.New("harsh-driving"),
+		vehicles: make(map[string]*harshState),
+	}
+}
+
+// SetRabbitMQService inject rabbitmq service
+func (d *HarshDrivingDetector) SetRabbitMQService(rmq *RabbitMQService) {
+	d.rabbitmq = rmq
+}
+
+// Check looks for harsh driving at a plausible point of a vehicle. speed is
+// the speed in km/h reported with the events. accel is nil when the device
+// sends no accelerometer reading.
+func (d *HarshDrivingDetector) Check(location *models.VehicleLocation, lat, lon, speed float64,
+	accel *models.Accelerometer, driverID *string) error {
+	events := d.detect(location, lat, lon, speed, accel, driverID)
+
+	for _, event := range events {
+		if err := d.repo.InsertHarshEvent(event); err != nil {
+			return fmt.Errorf("failed to record harsh driving event: %v", err)
+		}
+
+		d.logger.Warn("Harsh driving", "vehicle_id", event.VehicleID, "event", event.EventType,
+			"g_force", event.GForce, "speed_kmh", math.Round(event.SpeedKmh), "source", event.Source)
+		d.publish(event)
+	}
+
+	return nil
+}
+
+// detect updates the state of the vehicle and returns the harsh driving
+// events at the point
+func (d *HarshDrivingDetector) detect(location *models.VehicleLocation, lat, lon, speed float64,
+	accel *models.Accelerometer, driverID *string) []*models.HarshEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, ok := d.vehicles[location.VehicleID]
+	if !ok {
+		state = &harshState{lastEvent: make(map[string]int64)}
+		d.vehicles[location.VehicleID] = state
+	}
+
+	// A speed derived from two raw fixes is far too noisy to differentiate
+	// again, only measured speeds are used
+	measured, measuredKnown := measuredSpeed(location)
+
+	longitudinal, lateral, source := 0.0, 0.0, "accelerometer"
+	if accel != nil {
+		longitudinal, lateral = accel.X, accel.Y
+	} else {
+		source = "derived"
+		if measuredKnown && state.speed != nil {
+			if elapsed, ok := deriveInterval(location.Timestamp, state.speedAt); ok {
+				longitudinal = (measured - *state.speed) / 3.6 / elapsed / standardGravity
+			}
+		}
+
+		// Lateral acceleration is speed times the rate of turn
+		if measuredKnown && state.heading != nil && location.SmoothedHeading != nil {
+			if elapsed, ok := deriveInterval(location.Timestamp, state.headingAt); ok {
+				turn := math.Remainder(*location.SmoothedHeading-*state.heading, 360) * math.Pi / 180
+				lateral = measured / 3.6 * turn / elapsed / standardGravity
+			}
+		}
+	}
+
+	if measuredKnown && location.Timestamp > state.speedAt {
+		state.speed, state.speedAt = &measured, location.Timestamp
+	}
+	if location.SmoothedHeading != nil && location.Timestamp > state.headingAt {
+		state.heading, state.headingAt = location.SmoothedHeading, location.Timestamp
+	}
+
+	var events []*models.HarshEvent
+	check := func(eventType string, gForce, threshold float64) {
+		if threshold <= 0 || gForce < threshold {
+			return
+		}
+		if last, ok := state.lastEvent[eventType]; ok &&
+			time.Duration(location.Timestamp-last)*time.Second < harshEventCooldown {
+			return
+		}
+		state.lastEvent[eventType] = location.Timestamp
+
+		events = append(events, &models.HarshEvent{
+			VehicleID: location.VehicleID,
+			DriverID:  driverID,
+			EventType: eventType,
+			GForce:    math.Round(gForce*100) / 100,
+			SpeedKmh:  speed,
+			Source:    source,
+			Timestamp: location.Timestamp,
+			Latitude:  lat,
+			Longitude: lon,
+		})
+	}
+
+	check(models.HarshBraking, -longitudinal, d.policy.BrakingG)
+	check(models.HarshAcceleration, longitudinal, d.policy.AccelerationG)
+	check(models.SharpCornering, math.Abs(lateral), d.policy.CorneringG)
+
+	return events
+}
+
+// deriveInterval returns the seconds between two points when they are close
+// enough together to derive an acceleration from
+func deriveInterval(timestamp, previous int64) (float64, bool) {
+	elapsed := timestamp - previous
+	if elapsed <= 0 || time.Duration(elapsed)*time.Second > maxHarshDeriveGap {
+		return 0, false
+	}
+	return float64(elapsed), true
+}
+
+func (d *HarshDrivingDetector) publish(harsh *models.HarshEvent) {
+	if d.rabbitmq == nil {
+		d.logger.Warn("RabbitMQ not connected, skipping event", "event", harsh.EventType, "vehicle_id", harsh.VehicleID)
+		return
+	}
+
+	details := map[string]interface{}{
+		"g_force":   harsh.GForce,
+		"speed_kmh": math.Round(harsh.SpeedKmh),
+		"source":    harsh.Source,
+	}
+	if harsh.DriverID != nil {
+		details["driver_id"] = *harsh.DriverID
+	}
+
+	event := &models.VehicleEvent{
+		EventID:   models.NewEventID(harsh.VehicleID, harsh.EventType, fmt.Sprint(harsh.Timestamp)),
+		VehicleID: harsh.VehicleID,
+		Event:     harsh.EventType,
+		Timestamp: harsh.Timestamp,
+		Location: &models.Location{
+			Latitude:  harsh.Latitude,
+			Longitude: harsh.Longitude,
+		},
+		Details: details,
+	}
+
+	// harsh_braking is published as harsh.braking, sharp_cornering as sharp.cornering
+	routingKey := strings.Replace(harsh.EventType, "_", ".", 1)
+	if err := d.rabbitmq.PublishVehicleEvent(routingKey, event); err != nil {
+		d.logger.Error("Failed to publish event", "event", harsh.EventType, "vehicle_id", harsh.VehicleID, "error", err)
+	}
+}
+
+// ApplySafetyScore sets the score of every entry, 100 minus the penalties per
+// 100 km driven, not below 0. Short distances count as minSafetyDistanceKm so
+// a single event on a short drive does not dominate the score.
+func ApplySafetyScore(scores []models.SafetyScore) {
+	for i := range scores {
+		s := &scores[i]
+		penalty := float64(s.HarshBraking)*safetyPenalties[models.HarshBraking] +
+			float64(s.HarshAcceleration)*safetyPenalties[models.HarshAcceleration] +
+			float64(s.SharpCornering)*safetyPenalties[models.SharpCornering] +
+			float64(s.Speeding)*safetyPenalties["speeding"]
+		perDistance := penalty / math.Max(s.DistanceKm, minSafetyDistanceKm) * 100
+		s.Score = math.Round(math.Max(0, 100-perDistance)*10) / 10
+	}
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+)
+
+func TestHarshDrivingDetect(t *testing.T) {
+	policy := HarshPolicy{BrakingG: 0.4, AccelerationG: 0.3, CorneringG: 0.4}
+
+	const t0 = int64(1700000000)
+	f := func(v float64) *float64 { return &v }
+
+	type point struct {
+		seconds  int64
+		device   *float64 // speed reported by the device
+		smoothed *float64
+		heading  *float64
+		accel    *models.Accelerometer
+		estimate float64 // speed passed in, may come from raw fixes
+	}
+
+	tests := []struct {
+		name       string
+		points     []point
+		want       []string // "seconds:event_type"
+		wantSource string
+	}{
+		{
+			name:       "braking from device speed",
+			points:     []point{{seconds: 0, device: f(50)}, {seconds: 1, device: f(30)}},
+			want:       []string{"1:harsh_braking"},
+			wantSource: "derived",
+		},
+		{
+			name:       "acceleration from smoothed speed",
+			points:     []point{{seconds: 0, smoothed: f(20)}, {seconds: 2, smoothed: f(45)}},
+			want:       []string{"2:harsh_acceleration"},
+			wantSource: "derived",
+		},
+		{
+			name:   "gentle braking",
+			points: []point{{seconds: 0, device: f(50)}, {seconds: 1, device: f(45)}},
+		},
+		{
+			name:   "speed from raw fixes is not differentiated",
+			points: []point{{seconds: 0, estimate: 0}, {seconds: 1, estimate: 60}, {seconds: 2, estimate: 5}},
+		},
+		{
+			name:   "points too far apart",
+			points: []point{{seconds: 0, device: f(50)}, {seconds: 6, device: f(0)}},
+		},
+		{
+			name: "accelerometer point without speed keeps the last measured speed",
+			points: []point{
+				{seconds: 0, device: f(50)},
+				{seconds: 1, accel: &models.Accelerometer{X: -0.1}, estimate: 0},
+				{seconds: 2, device: f(40)},
+			},
+		},
+		{
+			name:       "accelerometer braking",
+			points:     []point{{seconds: 0, accel: &models.Accelerometer{X: -0.5}}},
+			want:       []string{"0:harsh_braking"},
+			wantSource: "accelerometer",
+		},
+		{
+			name:       "accelerometer cornering to the right",
+			points:     []point{{seconds: 0, accel: &models.Accelerometer{Y: -0.45}}},
+			want:       []string{"0:sharp_cornering"},
+			wantSource: "accelerometer",
+		},
+		{
+			name: "one maneuver is reported once",
+			points: []point{
+				{seconds: 0, device: f(60)}, {seconds: 1, device: f(40)}, {seconds: 2, device: f(20)},
+				{seconds: 4, device: f(20)}, {seconds: 6, device: f(20)}, {seconds: 8, device: f(20)},
+				{seconds: 10, device: f(20)}, {seconds: 11, device: f(0)},
+			},
+			want:       []string{"1:harsh_braking", "11:harsh_braking"},
+			wantSource: "derived",
+		},
+		{
+			// 10 m/s turning 30° in a second is about 0.53 g
+			name: "sharp cornering from smoothed heading",
+			points: []point{
+				{seconds: 0, smoothed: f(36), heading: f(0)},
+				{seconds: 1, smoothed: f(36), heading: f(30)},
+			},
+			want:       []string{"1:sharp_cornering"},
+			wantSource: "derived",
+		},
+		{
+			name: "cornering across north",
+			points: []point{
+				{seconds: 0, smoothed: f(36), heading: f(350)},
+				{seconds: 1, smoothed: f(36), heading: f(20)},
+			},
+			want:       []string{"1:sharp_cornering"},
+			wantSource: "derived",
+		},
+		{
+			name: "no cornering without a measured speed",
+			points: []point{
+				{seconds: 0, heading: f(0), estimate: 36},
+				{seconds: 1, heading: f(90), estimate: 36},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewHarshDrivingDetector(nil, policy)
+
+			var got []string
+			for _, p := range tt.points {
+				location := &models.VehicleLocation{
+					VehicleID:        "B1234XYZ",
+					Timestamp:        t0 + p.seconds,
+					SpeedKmh:         p.device,
+					SmoothedSpeedKmh: p.smoothed,
+					SmoothedHeading:  p.heading,
+				}
+				speed := p.estimate
+				if s, ok := measuredSpeed(location); ok {
+					speed = s
+				}
+
+				for _, event := range d.detect(location, -6.2, 106.8, speed, p.accel, nil) {
+					got = append(got, fmt.Sprintf("%d:%s", event.Timestamp-t0, event.EventType))
+					if event.Source != tt.wantSource {
+						t.Errorf("event %s source = %s, want %s", event.EventType, event.Source, tt.wantSource)
+					}
+				}
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHarshDrivingVehiclesIndependent(t *testing.T) {
+	d := NewHarshDrivingDetector(nil, HarshPolicy{BrakingG: 0.4, AccelerationG: 0.3, CorneringG: 0.4})
+	f := func(v float64) *float64 { return &v }
+
+	// The second vehicle's first point must not be compared to the first one
+	d.detect(&models.VehicleLocation{VehicleID: "A", Timestamp: 100, SpeedKmh: f(60)}, 0, 0, 60, nil, nil)
+	events := d.detect(&models.VehicleLocation{VehicleID: "B", Timestamp: 101, SpeedKmh: f(0)}, 0, 0, 0, nil, nil)
+	if len(events) != 0 {
+		t.Errorf("got %d events for a first point, want none", len(events))
+	}
+}
+
+func TestApplySafetyScore(t *testing.T) {
+	tests := []struct {
+		name  string
+		score models.SafetyScore
+		want  float64
+	}{
+		{"no events", models.SafetyScore{DistanceKm: 150}, 100},
+		{"no events and no distance", models.SafetyScore{}, 100},
+		{"two brakings in 100 km", models.SafetyScore{HarshBraking: 2, DistanceKm: 100}, 90},
+		{"two brakings in 200 km", models.SafetyScore{HarshBraking: 2, DistanceKm: 200}, 95},
+		{"one of each in 100 km", models.SafetyScore{
+			HarshBraking: 1, HarshAcceleration: 1, SharpCornering: 1, Speeding: 1, DistanceKm: 100}, 83},
+		{"short drive counts as the minimum distance", models.SafetyScore{HarshBraking: 1, DistanceKm: 2}, 75},
+		{"score does not go below 0", models.SafetyScore{Speeding: 50, DistanceKm: 100}, 0},
+		{"rounded to one decimal", models.SafetyScore{HarshAcceleration: 1, DistanceKm: 70}, 95.7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scores := []models.SafetyScore{tt.score}
+			ApplySafetyScore(scores)
+			if scores[0].Score != tt.want {
+				t.Errorf("score = %v, want %v", scores[0].Score, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/logging"
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/asaaitika/fleetmgm-tst/internal/repositories"
+)
+
+const (
+	HeadwayOK       = "ok"
+	HeadwayBunching = "bunching"
+	HeadwayGap      = "gap"
+)
+
+// HeadwayPolicy holds the thresholds for the time between consecutive
+// vehicles of a route at the same stop
+type HeadwayPolicy struct {
+	Bunching time.Duration // closer than this is bunching
+	Gap      time.Duration // further apart than this is a gap
+}
+
+// Status classifies a headway
+func (p HeadwayPolicy) Status(headway time.Duration) string {
+	switch {
+	case headway < p.Bunching:
+		return HeadwayBunching
+	case headway > p.Gap:
+		return HeadwayGap
+	default:
+		return HeadwayOK
+	}
+}
+
+// HeadwayMonitor compares every stop arrival with the previous arrival of
+// another vehicle of the same route and publishes headway_bunching /
+// headway_gap events when the headway is outside the policy.
+// The previous arrival is read from the stop visits, which hold the arrivals
+// of every subscriber replica, so vehicles of the same route handled by
+// different replicas are still compared with each other.
+type HeadwayMonitor struct {
+	policy   HeadwayPolicy
+	window   time.Duration // arrivals longer ago are not compared with
+	repo     *repositories.StopVisitRepository
+	rabbitmq *RabbitMQService
+	logger   *slog.Logger
+}
+
+func NewHeadwayMonitor(policy HeadwayPolicy, repo *repositories.StopVisitRepository, window time.Duration) *HeadwayMonitor {
+	return &HeadwayMonitor{
+		policy: policy,
+		window: window,
+		repo:   repo,
+		logger: logging.New("headway-monitor"),
+	}
+}
+
+// SetRabbitMQService inject rabbitmq service
+func (m *HeadwayMonitor) SetRabbitMQService(rmq *RabbitMQService) {
+	m.rabbitmq = rmq
+}
+
+// Arrival checks the headway of a vehicle arriving at a stop of its route,
+// after its stop visit was recorded. driverID is the driver on duty, nil when
+// unknown.
+func (m *HeadwayMonitor) Arrival(vehicleID string, driverID *string, progress *models.RouteProgress, area models.GeofenceArea, timestamp int64) error {
+	since := timestamp - int64(m.window.Seconds())
+	previous, err := m.repo.GetPreviousRouteArrival(progress.RouteID, progress.StopSequence, timestamp, since)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get previous arrival: %v", err)
+	}
+
+	// A vehicle coming back to the same stop, e.g. after a loop, has no headway
+	if previous.VehicleID == vehicleID {
+		return nil
+	}
+
+	headway := time.Duration(timestamp-previous.ArrivedAt) * time.Second
+	status := m.policy.Status(headway)
+	if status == HeadwayOK {
+		return nil
+	}
+
+	threshold := m.policy.Bunching
+	if status == HeadwayGap {
+		threshold = m.policy.Gap
+	}
+
+	m.logger.Warn("Headway "+status, "route_id", progress.RouteID, "area", area.Name, "vehicle_id", vehicleID,
+		"previous_vehicle_id", previous.VehicleID, "headway", headway.String())
+
+	details := map[string]interface{}{
+		"route_id":            progress.RouteID,
+		"stop_sequence":       progress.StopSequence,
+		"area_name":           area.Name,
+		"previous_vehicle_id": previous.VehicleID,
+		"headway_seconds":     int64(headway.Seconds()),
+		"threshold_seconds":   int64(threshold.Seconds()),
+	}
+	if driverID != nil {
+		details["driver_id"] = *driverID
+	}
+
+	eventType := "headway_" + status
+	m.publish("headway."+status, &models.VehicleEvent{
+		EventID:   models.NewEventID(vehicleID, eventType, progress.RouteID, fmt.Sprint(progress.StopSequence), fmt.Sprint(timestamp)),
+		VehicleID: vehicleID,
+		Event:     eventType,
+		Timestamp: timestamp,
+		Location: &models.Location{
+			Latitude:  area.CenterLatitude,
+			Longitude: area.CenterLongitude,
+		},
+		Details: details,
+	})
+	return nil
+}
+
+func (m *HeadwayMonitor) publish(routingKey string, event *models.VehicleEvent) {
+	if m.rabbitmq == nil {
+		m.logger.Warn("RabbitMQ not connected, skipping event", "event", event.Event, "vehicle_id", event.VehicleID)
+		return
+	}
+
+	if err := m.rabbitmq.PublishVehicleEvent(routingKey, event); err != nil {
+		m.logger.Error("Failed to publish event", "event", event.Event, "vehicle_id", event.VehicleID, "error", err)
+	}
+}
@@ -0,0 +1,229 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/logging"
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/asaaitika/fleetmgm-tst/internal/repositories"
+)
+
+// IdlePolicy holds the thresholds for idling
+type IdlePolicy struct {
+	MaxSpeedKmh float64       // slower than this is standing still
+	MinDuration time.Duration // how long a vehicle must stand still
+}
+
+// IdleDetector tracks vehicles standing still with their engine running
+// outside terminals and depots and publishes idle_start / idle_end events.
+// Points without ignition are treated as engine running, so devices that do
+// not report it still get excessive stops detected.
+type IdleDetector struct {
+	repo        *repositories.IdleRepository
+	vehicleRepo *repositories.VehicleRepository
+	geofence    *GeofenceService
+	policy      IdlePolicy
+	cacheTTL    time.Duration
+	rabbitmq    *RabbitMQService
+	logger      *slog.Logger
+
+	mu            sync.Mutex
+	areas         []models.GeofenceArea // terminals and depots
+	areasLoadedAt time.Time
+	vehicles      map[string]*idleState
+}
+
+type idleState struct {
+	stillSince int64 // first point standing still, zero while moving
+	idling     bool  // idle_start was sent
+	event      models.IdleEvent
+}
+
+func NewIdleDetector(repo *repositories.IdleRepository, vehicleRepo *repositories.VehicleRepository,
+	policy IdlePolicy, cacheTTL time.Duration) *IdleDetector {
+	return &IdleDetector{
+		repo:        repo,
+		vehicleRepo: vehicleRepo,
+		geofence:    NewGeofenceService(),
+		policy:      policy,
+		cacheTTL:    cacheTTL,
+		logger:      logging.New("idle-detector"),
+		vehicles:    make(map[string]*idleState),
+	}
+}
+
+// SetRabbitMQService inject rabbitmq service
+func (d *IdleDetector) SetRabbitMQService(rmq *RabbitMQService) {
+	d.rabbitmq = rmq
+}
+
+// Seed restores idle periods still open, e.g. at startup, so they are closed
+// when the vehicle moves on
+func (d *IdleDetector) Seed(events []models.IdleEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, event := range events {
+		d.vehicles[event.VehicleID] = &idleState{
+			stillSince: event.StartedAt,
+			idling:     true,
+			event:      event,
+		}
+	}
+}
+
+// Check looks for idling at a plausible point of a vehicle. speed is in km/h
+// and only used when speedKnown is set.
+func (d *IdleDetector) Check(location *models.VehicleLocation, lat, lon, speed float64, speedKnown bool, driverID *string) error {
+	ignitionOff := location.Ignition != nil && !*location.Ignition
+
+	// Without a speed the state is kept until the next point with one
+	if !speedKnown && !ignitionOff {
+		return nil
+	}
+
+	areas, err := d.loadAreas()
+	if err != nil {
+		return fmt.Errorf("failed to get geofence areas: %v", err)
+	}
+
+	allowed := false
+	for _, area := range areas {
+		if d.geofence.CalculateDistance(lat, lon, area.CenterLatitude, area.CenterLongitude) <= float64(area.RadiusMeters) {
+			allowed = true
+			break
+		}
+	}
+
+	still := !ignitionOff && !allowed && speed < d.policy.MaxSpeedKmh
+
+	d.mu.Lock()
+	state, ok := d.vehicles[location.VehicleID]
+	if !ok {
+		state = &idleState{}
+		d.vehicles[location.VehicleID] = state
+	}
+
+	var ended, started *models.IdleEvent
+	if still {
+		if state.stillSince == 0 {
+			state.stillSince = location.Timestamp
+		}
+
+		stillFor := time.Duration(location.Timestamp-state.stillSince) * time.Second
+		if !state.idling && stillFor >= d.policy.MinDuration {
+			state.idling = true
+			state.event = models.IdleEvent{
+				VehicleID: location.VehicleID,
+				DriverID:  driverID,
+				StartedAt: state.stillSince,
+				Latitude:  lat,
+				Longitude: lon,
+				Ignition:  location.Ignition,
+			}
+			event := state.event
+			started = &event
+		}
+	} else {
+		if state.idling {
+			event := state.event
+			event.EndedAt = &location.Timestamp
+			ended = &event
+		}
+		*state = idleState{}
+	}
+	d.mu.Unlock()
+
+	if ended != nil {
+		if err := d.repo.EndIdle(ended.VehicleID, *ended.EndedAt); err != nil {
+			return fmt.Errorf("failed to record end of idling: %v", err)
+		}
+
+		d.logger.Info("Vehicle stopped idling", "vehicle_id", ended.VehicleID,
+			"idle_seconds", *ended.EndedAt-ended.StartedAt)
+		d.publish("idle.end", "idle_end", ended, lat, lon, *ended.EndedAt)
+	}
+
+	if started != nil {
+		if err := d.repo.StartIdle(started); err != nil {
+			return fmt.Errorf("failed to record idling: %v", err)
+		}
+
+		d.logger.Warn("Vehicle idling outside terminals and depots", "vehicle_id", started.VehicleID,
+			"idle_seconds", location.Timestamp-started.StartedAt)
+		d.publish("idle.start", "idle_start", started, lat, lon, location.Timestamp)
+	}
+
+	return nil
+}
+
+// loadAreas returns the terminals and depots, cached for cacheTTL
+func (d *IdleDetector) loadAreas() ([]models.GeofenceArea, error) {
+	d.mu.Lock()
+	areas, loadedAt := d.areas, d.areasLoadedAt
+	d.mu.Unlock()
+
+	if !loadedAt.IsZero() && time.Since(loadedAt) < d.cacheTTL {
+		return areas, nil
+	}
+
+	all, err := d.vehicleRepo.GetGeofenceAreas()
+	if err != nil {
+		return nil, err
+	}
+
+	areas = []models.GeofenceArea{}
+	for _, area := range all {
+		if area.AreaType == models.AreaTerminal || area.AreaType == models.AreaDepot {
+			areas = append(areas, area)
+		}
+	}
+
+	d.mu.Lock()
+	d.areas, d.areasLoadedAt = areas, time.Now()
+	d.mu.Unlock()
+
+	return areas, nil
+}
+
+func (d *IdleDetector) publish(routingKey, eventType string, idle *models.IdleEvent, lat, lon float64, timestamp int64) {
+	if d.rabbitmq == nil {
+		d.logger.Warn("RabbitMQ not connected, skipping event", "event", eventType, "vehicle_id", idle.VehicleID)
+		return
+	}
+
+	ignition := "unknown"
+	if idle.Ignition != nil {
+		ignition = "on"
+	}
+
+	details := map[string]interface{}{
+		"started_at": idle.StartedAt,
+		"ignition":   ignition,
+	}
+	if idle.DriverID != nil {
+		details["driver_id"] = *idle.DriverID
+	}
+	if idle.EndedAt != nil {
+		details["duration_seconds"] = *idle.EndedAt - idle.StartedAt
+	}
+
+	event := &models.VehicleEvent{
+		EventID:   models.NewEventID(idle.VehicleID, eventType, fmt.Sprint(idle.StartedAt)),
+		VehicleID: idle.VehicleID,
+		Event:     eventType,
+		Timestamp: timestamp,
+		Location: &models.Location{
+			Latitude:  lat,
+			Longitude: lon,
+		},
+		Details: details,
+	}
+
+	if err := d.rabbitmq.PublishVehicleEvent(routingKey, event); err != nil {
+		d.logger.Error("Failed to publish event", "event", eventType, "vehicle_id", idle.VehicleID, "error", err)
+	}
+}
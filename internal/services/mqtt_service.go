@@ -1,49 +1,213 @@
 package services
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
+	"net/url"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/asaaitika/fleetmgm-tst/internal/logging"
+	"github.com/asaaitika/fleetmgm-tst/internal/metrics"
 	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/asaaitika/fleetmgm-tst/internal/mqttconn"
 	"github.com/asaaitika/fleetmgm-tst/internal/repositories"
+	"github.com/asaaitika/fleetmgm-tst/internal/tracing"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type MQTTService struct {
-	client   mqtt.Client
-	repo     *repositories.VehicleRepository
-	geofence *GeofenceService
-	rabbitmq *RabbitMQService
+	client    mqtt.Client
+	repo      *repositories.VehicleRepository
+	geofence  *GeofenceService
+	rabbitmq  *RabbitMQService
+	presence  *PresenceMonitor
+	filter    *PlausibilityFilter
+	smoother  *PositionSmoother
+	routes    *RouteService
+	tracker   *GeofenceTracker
+	visits    *StopVisitRecorder
+	headways  *HeadwayMonitor
+	deviation *RouteDeviationDetector
+	speeding  *SpeedingDetector
+	idle      *IdleDetector
+	harsh     *HarshDrivingDetector
+	speeds    *SpeedEstimator
+	drivers   *DriverResolver
+
+	geofenceSource string
+	cleanSession   bool
+	shard, shards  int // handled shard out of shards, by vehicle ID
+	logger         *slog.Logger
+
+	// Unix nanoseconds of the last stored location, or of the start
+	lastStored atomic.Int64
+
+	// Subscribed topics, and handlers running or done lately, for Shutdown
+	topics      []string
+	inFlight    atomic.Int64
+	lastHandled atomic.Int64
+	draining    atomic.Bool
+
+	// Connection state: when the connection was lost (zero while connected),
+	// whether the topics are subscribed, the number of connects so far, and
+	// the outcome of the first subscribe for Subscribe
+	disconnectedAt atomic.Int64
+	subscribed     atomic.Bool
+	connects       atomic.Int64
+	firstSubscribe chan error
+
+	// Broker connection, for logs and connection events, and the longest wait
+	// between subscribe retries after a reconnect
+	clientID       string
+	broker         string
+	resubscribeMax time.Duration
 }
 
-func NewMQTTService(broker string, repo *repositories.VehicleRepository) (*MQTTService, error) {
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(broker)
-	opts.SetClientID("fleet_subscriber_001")
+// drainIdle is how long Shutdown waits without any handler running before it
+// considers the messages queued in the client handled. Paho has no way to
+// flush its router, a message read just before the UNSUBACK may still be on
+// its way from the network goroutine to the handler.
+const drainIdle = 200 * time.Millisecond
+
+// NewMQTTService prepares the client for the broker, over TLS and with
+// credentials when the connection config has them. Subscribe connects.
+func NewMQTTService(conn mqttconn.Config, repo *repositories.VehicleRepository) (*MQTTService, error) {
+	logger := logging.New("mqtt-service")
+	broker := conn.Broker
+
+	opts, err := mqttconn.ClientOptions(conn)
+	if err != nil {
+		return nil, err
+	}
 	opts.SetKeepAlive(60 * time.Second)
 	opts.SetPingTimeout(10 * time.Second)
 
+	// Messages are acknowledged by track once handled, so the ones dropped
+	// during Shutdown stay queued in a kept session
+	opts.SetAutoAckDisabled(true)
+
+	s := &MQTTService{
+		repo:           repo,
+		tracker:        NewGeofenceTracker(),
+		speeds:         NewSpeedEstimator(),
+		geofenceSource: models.PositionRaw,
+		cleanSession:   conn.CleanSession,
+		shards:         1,
+		firstSubscribe: make(chan error, 1),
+		clientID:       conn.ClientID,
+		broker:         broker,
+		resubscribeMax: conn.ReconnectMaxInterval,
+		logger:         logger,
+	}
+	if s.resubscribeMax <= 0 {
+		s.resubscribeMax = 10 * time.Minute
+	}
+	s.lastStored.Store(time.Now().UnixNano())
+
+	// The client reconnects by itself, waiting 1s after a lost connection and
+	// doubling up to the reconnect max interval. Subscriptions are made on
+	// every connect, a clean session loses them with the connection.
 	opts.OnConnect = func(client mqtt.Client) {
-		log.Println("[MQTT-SERVICE][INFO] >>> Connected to MQTT broker")
+		connect := s.connects.Add(1)
+		metrics.MQTTConnected.Set(1)
+
+		lostAt := s.disconnectedAt.Swap(0)
+		if lostAt != 0 {
+			down := time.Since(time.Unix(0, lostAt))
+			metrics.MQTTDisconnectDuration.Observe(down.Seconds())
+			logger.Warn("Reconnected to MQTT broker, no locations were received meanwhile", "broker", broker,
+				"disconnected_at", time.Unix(0, lostAt).UTC().Format(time.RFC3339),
+				"disconnected_for", down.Round(time.Millisecond).String())
+		} else {
+			logger.Info("Connected to MQTT broker", "broker", broker, "client_id", conn.ClientID,
+				"clean_session", conn.CleanSession, "tls", mqttconn.IsTLS(broker), "client_cert", conn.CertFile != "")
+		}
+
+		// The first subscribe is reported by Subscribe, a failure stops the
+		// subscriber at startup
+		err := s.subscribe()
+		if connect == 1 {
+			s.firstSubscribe <- err
+			return
+		}
+
+		if lostAt != 0 {
+			down := time.Since(time.Unix(0, lostAt))
+			s.publishConnectionEvent("mqtt.reconnected", "mqtt_reconnected", lostAt, map[string]interface{}{
+				"disconnected_at":          time.Unix(0, lostAt).UTC().Format(time.RFC3339),
+				"disconnected_for_seconds": down.Seconds(),
+				"subscribed":               err == nil,
+			})
+		}
+
+		if err != nil {
+			s.resubscribe(client, connect, err)
+		}
 	}
 
 	opts.OnConnectionLost = func(client mqtt.Client, err error) {
-		log.Printf("[MQTT-SERVICE][ERROR] >>> MQTT connection lost: %v", err)
+		lostAt := time.Now().UnixNano()
+		s.disconnectedAt.Store(lostAt)
+		s.subscribed.Store(false)
+		metrics.MQTTConnected.Set(0)
+		metrics.MQTTDisconnects.Inc()
+		logger.Warn("MQTT connection lost, reconnecting", "broker", broker, "error", err,
+			"max_interval", conn.ReconnectMaxInterval.String())
+
+		s.publishConnectionEvent("mqtt.disconnected", "mqtt_disconnected", lostAt, map[string]interface{}{
+			"error": fmt.Sprint(err),
+		})
 	}
 
-	client := mqtt.NewClient(opts)
+	// Attempts of the first connect, later ones go through the reconnecting
+	// handler. The first connect is retried while the broker is unreachable.
+	var attempts atomic.Int64
+	opts.SetConnectionAttemptHandler(func(brokerURL *url.URL, tlsCfg *tls.Config) *tls.Config {
+		if s.connects.Load() == 0 {
+			logger.Info("Connecting to MQTT broker", "broker", broker, "attempt", attempts.Add(1),
+				"retry_interval", conn.ConnectRetryInterval.String())
+		}
+		return tlsCfg
+	})
+
+	opts.SetReconnectingHandler(func(client mqtt.Client, opts *mqtt.ClientOptions) {
+		metrics.MQTTReconnectAttempts.Inc()
+		logger.Debug("Reconnecting to MQTT broker", "broker", broker)
+	})
+
+	s.client = mqtt.NewClient(opts)
+
+	return s, nil
+}
 
-	// Connect to broker
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		return nil, token.Error()
+// CheckConnection reports whether the client is connected to the broker and
+// subscribed, for readiness checks
+func (s *MQTTService) CheckConnection(ctx context.Context) error {
+	if lostAt := s.disconnectedAt.Load(); lostAt != 0 {
+		return fmt.Errorf("connection lost %s ago, reconnecting", time.Since(time.Unix(0, lostAt)).Round(time.Second))
+	}
+	if !s.client.IsConnectionOpen() {
+		return fmt.Errorf("not connected to broker")
+	}
+	if !s.subscribed.Load() {
+		return fmt.Errorf("connected but not subscribed")
 	}
+	return nil
+}
 
-	return &MQTTService{
-		client: client,
-		repo:   repo,
-	}, nil
+// IngestionLag returns the time since a location was last stored, or since
+// the service started when none was stored yet
+func (s *MQTTService) IngestionLag() time.Duration {
+	return time.Since(time.Unix(0, s.lastStored.Load()))
 }
 
 // SetGeofenceService inject geofence service
@@ -55,53 +219,469 @@ func (s *MQTTService) SetGeofenceService(geo *GeofenceService) {
 func (s *MQTTService) SetRabbitMQService(rmq *RabbitMQService) {
 	s.rabbitmq = rmq
 	if rmq != nil {
-		log.Println("[MQTT-SERVICE][INFO] >>> RabbitMQ service successfully attached")
+		s.logger.Info("RabbitMQ service successfully attached")
 	} else {
-		log.Println("[MQTT-SERVICE][WARN] >>> Nil RabbitMQ service provided")
+		s.logger.Warn("Nil RabbitMQ service provided")
+	}
+}
+
+// SetPresenceMonitor inject presence monitor
+func (s *MQTTService) SetPresenceMonitor(monitor *PresenceMonitor) {
+	s.presence = monitor
+}
+
+// SetPlausibilityFilter inject plausibility filter
+func (s *MQTTService) SetPlausibilityFilter(filter *PlausibilityFilter) {
+	s.filter = filter
+}
+
+// SetPositionSmoother inject position smoother
+func (s *MQTTService) SetPositionSmoother(smoother *PositionSmoother) {
+	s.smoother = smoother
+}
+
+// SetShard makes the service handle only the vehicles of one shard out of
+// count. Every vehicle keeps its state (plausibility, smoothing, geofence,
+// detectors) in the process handling it, so replicas must split the vehicles
+// and not the messages: each replica receives all messages and acknowledges
+// the ones of other shards without handling them.
+func (s *MQTTService) SetShard(index, count int) {
+	s.shard, s.shards = index, max(count, 1)
+	if s.shards > 1 {
+		s.logger.Info("Handling one shard of the vehicles", "shard", s.shard, "shards", s.shards)
 	}
 }
 
-// Subscribe to vehicle location topic
+// SetGeofencePositionSource selects raw or smoothed coordinates for geofencing
+func (s *MQTTService) SetGeofencePositionSource(source string) {
+	s.geofenceSource = source
+}
+
+// SetRouteService inject route service
+func (s *MQTTService) SetRouteService(routes *RouteService) {
+	s.routes = routes
+}
+
+// SetGeofenceTracker replaces the geofence state, e.g. with one seeded from
+// open stop visits
+func (s *MQTTService) SetGeofenceTracker(tracker *GeofenceTracker) {
+	s.tracker = tracker
+}
+
+// SetStopVisitRecorder inject stop visit recorder
+func (s *MQTTService) SetStopVisitRecorder(visits *StopVisitRecorder) {
+	s.visits = visits
+}
+
+// SetHeadwayMonitor inject headway monitor
+func (s *MQTTService) SetHeadwayMonitor(monitor *HeadwayMonitor) {
+	s.headways = monitor
+}
+
+// SetRouteDeviationDetector inject route deviation detector
+func (s *MQTTService) SetRouteDeviationDetector(detector *RouteDeviationDetector) {
+	s.deviation = detector
+}
+
+// SetSpeedingDetector inject speeding detector
+func (s *MQTTService) SetSpeedingDetector(detector *SpeedingDetector) {
+	s.speeding = detector
+}
+
+// SetIdleDetector inject idle detector
+func (s *MQTTService) SetIdleDetector(detector *IdleDetector) {
+	s.idle = detector
+}
+
+// SetHarshDrivingDetector inject harsh driving detector
+func (s *MQTTService) SetHarshDrivingDetector(detector *HarshDrivingDetector) {
+	s.harsh = detector
+}
+
+// SetDriverResolver inject driver resolver, it also enables the driver topic
+func (s *MQTTService) SetDriverResolver(drivers *DriverResolver) {
+	s.drivers = drivers
+}
+
+// Subscribe connects and subscribes to vehicle location topic, and to the
+// driver login topic when a driver resolver is set. The subscriptions are
+// made again after every reconnect.
 func (s *MQTTService) Subscribe() error {
-	topic := "/fleet/vehicle/+/location"
+	topics := map[string]mqtt.MessageHandler{
+		"/fleet/vehicle/+/location": s.handleMessage,
+	}
+	if s.drivers != nil {
+		topics["/fleet/vehicle/+/driver"] = s.handleDriverMessage
+	}
+
+	// Routes go in before connecting, a kept session delivers its queued
+	// messages as soon as it resumes and the client drops unrouted ones
+	for topic, handler := range topics {
+		s.client.AddRoute(topic, s.track(handler))
+		s.topics = append(s.topics, topic)
+	}
+
+	if token := s.client.Connect(); token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to connect: %v", token.Error())
+	}
+
+	// OnConnect subscribes, here and after every reconnect
+	return <-s.firstSubscribe
+}
+
+// resubscribe retries a failed subscribe after a reconnect, waiting 1s and
+// doubling up to the reconnect max interval, until it succeeds or the
+// connection is lost. The next connect subscribes again by itself.
+func (s *MQTTService) resubscribe(client mqtt.Client, connect int64, err error) {
+	for delay := time.Second; err != nil; delay = min(delay*2, s.resubscribeMax) {
+		s.logger.Error("Failed to subscribe after reconnecting, retrying", "broker", s.broker,
+			"error", err, "retry_in", delay.String())
+		time.Sleep(delay)
+
+		if s.connects.Load() != connect || !client.IsConnectionOpen() {
+			return
+		}
+		err = s.subscribe()
+	}
+}
+
+// publishConnectionEvent sends an event about the broker connection, so the
+// gap in the locations shows up next to the vehicle events
+func (s *MQTTService) publishConnectionEvent(routingKey, eventType string, lostAt int64, details map[string]interface{}) {
+	if s.rabbitmq == nil {
+		s.logger.Warn("RabbitMQ not connected, skipping event", "event", eventType)
+		return
+	}
+
+	details["broker"] = s.broker
+	event := &models.SubscriberEvent{
+		EventID:   models.NewEventID(s.clientID, eventType, fmt.Sprint(lostAt)),
+		ClientID:  s.clientID,
+		Event:     eventType,
+		Timestamp: time.Now().Unix(),
+		Details:   details,
+	}
+	if err := s.rabbitmq.PublishSubscriberEvent(routingKey, event); err != nil {
+		s.logger.Error("Failed to publish event", "event", eventType, "error", err)
+	}
+}
+
+// subscribe subscribes to the routed topics
+func (s *MQTTService) subscribe() error {
+	for _, topic := range s.topics {
+		// Subscribe with QoS 1 (at least once delivery)
+		token := s.client.Subscribe(topic, 1, nil)
 
-	// Subscribe with QoS 1 (at least once delivery)
-	token := s.client.Subscribe(topic, 1, s.handleMessage)
+		token.Wait()
+		if token.Error() != nil {
+			s.subscribed.Store(false)
+			return fmt.Errorf("failed to subscribe to %s: %v", topic, token.Error())
+		}
+		if qos, ok := token.(*mqtt.SubscribeToken).Result()[topic]; ok && qos == 0x80 {
+			s.subscribed.Store(false)
+			return fmt.Errorf("failed to subscribe to %s: refused by broker", topic)
+		}
 
-	token.Wait()
-	if token.Error() != nil {
-		return token.Error()
+		s.logger.Info("Subscribed to topic", "topic", topic)
 	}
 
-	log.Printf("[MQTT-SERVICE][INFO] >>> Subscribed to topic: %s", topic)
+	s.subscribed.Store(true)
 	return nil
 }
 
-// handleMessage process incoming MQTT messages
+// track counts a handler as in flight while it runs and acknowledges the
+// message after it. Once a kept session is draining, messages are left
+// unacknowledged, the broker sends them again when the session resumes.
+func (s *MQTTService) track(handler mqtt.MessageHandler) mqtt.MessageHandler {
+	return func(client mqtt.Client, msg mqtt.Message) {
+		s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+
+		if s.draining.Load() {
+			return
+		}
+
+		if !s.owns(msg.Topic()) {
+			metrics.MQTTMessagesOtherShard.Inc()
+			msg.Ack()
+			return
+		}
+
+		handler(client, msg)
+		msg.Ack()
+		s.lastHandled.Store(time.Now().UnixNano())
+	}
+}
+
+// OwnsVehicle reports whether a vehicle belongs to the shard of the service,
+// state loaded at startup is limited to these vehicles
+func (s *MQTTService) OwnsVehicle(vehicleID string) bool {
+	return s.shards <= 1 || shardOf(vehicleID, s.shards) == s.shard
+}
+
+// owns reports whether the vehicle of a topic belongs to the shard of the
+// service. Topics without a vehicle are left to the handler to reject.
+func (s *MQTTService) owns(topic string) bool {
+	vehicleID, ok := topicVehicleID(topic)
+	return !ok || s.OwnsVehicle(vehicleID)
+}
+
+// topicVehicleID returns the vehicle of a /fleet/vehicle/{vehicle_id}/...
+// topic
+func topicVehicleID(topic string) (string, bool) {
+	parts := strings.Split(topic, "/")
+	if len(parts) != 5 || parts[3] == "" {
+		return "", false
+	}
+	return parts[3], true
+}
+
+// Shutdown unsubscribes, so the broker stops sending, waits until the
+// messages already received are handled and disconnects. A kept session
+// stays subscribed so the broker queues messages until the restart, the ones
+// already received but not handled yet are left to it too. Handlers still
+// running when ctx ends are cut off by the disconnect.
+func (s *MQTTService) Shutdown(ctx context.Context) error {
+	defer s.Disconnect()
+
+	if !s.cleanSession {
+		s.draining.Store(true)
+		s.logger.Info("Keeping session, draining handlers", "topics", s.topics)
+	} else if len(s.topics) > 0 {
+		timeout := 5 * time.Second
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = time.Until(deadline)
+		}
+
+		token := s.client.Unsubscribe(s.topics...)
+		if !token.WaitTimeout(timeout) {
+			return fmt.Errorf("failed to unsubscribe: timed out")
+		}
+		if token.Error() != nil {
+			return fmt.Errorf("failed to unsubscribe: %v", token.Error())
+		}
+		s.logger.Info("Unsubscribed, draining received messages", "topics", s.topics)
+	}
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		idle := time.Since(time.Unix(0, s.lastHandled.Load()))
+		if s.inFlight.Load() == 0 && idle >= drainIdle {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d messages still in flight: %v", s.inFlight.Load(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleDriverMessage process driver logins and logouts from the in-bus terminal
+func (s *MQTTService) handleDriverMessage(client mqtt.Client, msg mqtt.Message) {
+	s.logger.Debug("Received message", "topic", msg.Topic())
+	metrics.MQTTMessagesReceived.WithLabelValues("driver").Inc()
+
+	// Topic is /fleet/vehicle/{vehicle_id}/driver
+	vehicleID, ok := topicVehicleID(msg.Topic())
+	if !ok {
+		s.logger.Error("Invalid driver topic", "topic", msg.Topic())
+		metrics.MQTTMessagesRejected.WithLabelValues("invalid_topic").Inc()
+		return
+	}
+	logger := s.logger.With("vehicle_id", vehicleID)
+
+	var payload models.DriverShiftPayload
+	if err := json.Unmarshal(msg.Payload(), &payload); err != nil {
+		logger.Error("Failed to parse driver payload", "error", err)
+		metrics.MQTTMessagesRejected.WithLabelValues("parse_error").Inc()
+		return
+	}
+
+	if payload.DriverID == "" {
+		logger.Error("Invalid driver payload: driver_id is required")
+		metrics.MQTTMessagesRejected.WithLabelValues("invalid_payload").Inc()
+		return
+	}
+	if payload.Timestamp <= 0 {
+		payload.Timestamp = time.Now().Unix()
+	}
+
+	if err := s.drivers.Handle(vehicleID, &payload); err != nil {
+		logger.Error("Failed to handle driver message", "driver_id", payload.DriverID, "action", payload.Action, "error", err)
+	}
+}
+
+// handleMessage process incoming MQTT messages. Every message is traced from
+// here to the geofence worker.
 func (s *MQTTService) handleMessage(client mqtt.Client, msg mqtt.Message) {
-	log.Printf("[MQTT-SERVICE][DEBUG] >>> Received message on topic: %s", msg.Topic())
+	s.logger.Debug("Received message", "topic", msg.Topic())
+	metrics.MQTTMessagesReceived.WithLabelValues("location").Inc()
+
+	ctx, span := tracing.Tracer().Start(context.Background(), "mqtt.handle_message",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "mqtt"),
+			attribute.String("messaging.destination.name", msg.Topic()),
+		))
+	defer span.End()
 
 	var payload models.MQTTPayload
 	err := json.Unmarshal(msg.Payload(), &payload)
 	if err != nil {
-		log.Printf("[MQTT-SERVICE][ERROR] >>> Failed to parse payload: %v", err)
+		s.logger.ErrorContext(ctx, "Failed to parse payload", "topic", msg.Topic(), "error", err)
+		metrics.MQTTMessagesRejected.WithLabelValues("parse_error").Inc()
+		tracing.RecordError(span, err)
 		return
 	}
 
+	span.SetAttributes(
+		attribute.String("vehicle.id", payload.VehicleID),
+		attribute.Int64("vehicle.timestamp", payload.Timestamp),
+	)
+	logger := s.logger.With("vehicle_id", payload.VehicleID)
+
 	if err := s.validatePayload(&payload); err != nil {
-		log.Printf("[MQTT-SERVICE][ERROR] >>> Invalid payload: %v", err)
+		logger.ErrorContext(ctx, "Invalid payload", "error", err)
+		metrics.MQTTMessagesRejected.WithLabelValues("invalid_payload").Inc()
+		tracing.RecordError(span, err)
+		return
+	}
+
+	// The shard is picked by the topic, a payload of another vehicle would
+	// end up in the state of the wrong process
+	if topicVehicle, _ := topicVehicleID(msg.Topic()); topicVehicle != payload.VehicleID {
+		err := fmt.Errorf("vehicle_id %q does not match topic %s", payload.VehicleID, msg.Topic())
+		logger.ErrorContext(ctx, "Invalid payload", "error", err)
+		metrics.MQTTMessagesRejected.WithLabelValues("topic_mismatch").Inc()
+		tracing.RecordError(span, err)
 		return
 	}
 
-	if err := s.repo.InsertLocation(&payload); err != nil {
-		log.Printf("[MQTT-SERVICE][ERROR] >>> Failed to save to database: %v", err)
+	quality := QualityOK
+	if s.filter != nil {
+		quality, err = s.filter.Check(&payload, time.Now())
+		if err != nil {
+			logger.WarnContext(ctx, "Rejected point", "timestamp", payload.Timestamp, "error", err)
+			metrics.MQTTMessagesRejected.WithLabelValues(rejectReason(err)).Inc()
+			span.SetAttributes(attribute.String("vehicle.rejected", rejectReason(err)))
+			return
+		}
+	}
+
+	location := &models.VehicleLocation{
+		VehicleID: payload.VehicleID,
+		Latitude:  payload.Latitude,
+		Longitude: payload.Longitude,
+		Timestamp: payload.Timestamp,
+		Quality:   quality,
+		SpeedKmh:  payload.Speed,
+		Ignition:  payload.Ignition,
+		DriverID:  payload.DriverID,
+	}
+
+	// The shift logged on the vehicle wins over a driver_id in the payload
+	if s.drivers != nil {
+		driverID, err := s.drivers.Resolve(payload.VehicleID, payload.Timestamp)
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to resolve driver", "error", err)
+		} else if driverID != nil {
+			location.DriverID = driverID
+		}
+	}
+
+	// Only plausible points feed the filter, flagged ones would drag it off track
+	if s.smoother != nil && quality == QualityOK {
+		s.smoother.Smooth(location)
+	}
+
+	if err := s.insertLocation(ctx, location); err != nil {
+		logger.ErrorContext(ctx, "Failed to save to database", "error", err)
+		metrics.MQTTMessagesRejected.WithLabelValues("db_error").Inc()
 		return
 	}
+	metrics.LocationsStored.WithLabelValues(quality).Inc()
+	s.lastStored.Store(time.Now().UnixNano())
+	span.SetAttributes(attribute.String("vehicle.quality", quality))
 
-	log.Printf("[MQTT-SERVICE][INFO] >>> Saved location for vehicle: %s", payload.VehicleID)
+	logger.InfoContext(ctx, "Saved location", "timestamp", payload.Timestamp, "quality", quality)
+
+	if s.presence != nil {
+		s.presence.Touch(payload.VehicleID, location.DriverID, payload.Timestamp)
+	}
+
+	// Flagged points are kept for analysis only
+	if quality != QualityOK {
+		logger.WarnContext(ctx, "Point flagged, skipping geofence check", "quality", quality)
+		return
+	}
 
 	if s.geofence != nil {
-		s.checkGeofence(&payload)
+		s.checkGeofence(ctx, location)
+	}
+
+	lat, lon := location.Position(s.geofenceSource)
+	speed, speedKnown := s.speeds.Estimate(location, lat, lon)
+
+	if s.deviation != nil {
+		if err := s.deviation.Check(location.VehicleID, location.DriverID, lat, lon, location.Timestamp); err != nil {
+			logger.ErrorContext(ctx, "Failed to check route deviation", "error", err)
+		}
+	}
+
+	// Without a speed the detectors keep their state until the next point with one
+	if s.speeding != nil && speedKnown {
+		if err := s.speeding.Check(location, lat, lon, speed, location.DriverID); err != nil {
+			logger.ErrorContext(ctx, "Failed to check speeding", "error", err)
+		}
+	}
+
+	if s.idle != nil {
+		if err := s.idle.Check(location, lat, lon, speed, speedKnown, location.DriverID); err != nil {
+			logger.ErrorContext(ctx, "Failed to check idling", "error", err)
+		}
+	}
+
+	// Harsh driving derives acceleration from measured speeds only, speed is
+	// just reported with the event
+	if s.harsh != nil && (speedKnown || payload.Accelerometer != nil) {
+		if err := s.harsh.Check(location, lat, lon, speed, payload.Accelerometer, location.DriverID); err != nil {
+			logger.ErrorContext(ctx, "Failed to check harsh driving", "error", err)
+		}
+	}
+}
+
+// insertLocation stores a location in its own span
+func (s *MQTTService) insertLocation(ctx context.Context, location *models.VehicleLocation) error {
+	_, span := tracing.Tracer().Start(ctx, "db.insert_location", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.collection.name", "vehicle_locations"),
+		))
+	defer span.End()
+
+	start := time.Now()
+	err := s.repo.InsertLocation(location)
+	metrics.DBInsertDuration.WithLabelValues("vehicle_locations").Observe(metrics.Since(start))
+	tracing.RecordError(span, err)
+
+	return err
+}
+
+// rejectReason labels a point rejected by the plausibility filter
+func rejectReason(err error) string {
+	switch {
+	case errors.Is(err, ErrFutureTimestamp):
+		return "future_timestamp"
+	case errors.Is(err, ErrDuplicatePoint):
+		return "duplicate"
+	case errors.Is(err, ErrStalePoint):
+		return "stale"
+	default:
+		return "filter_error"
 	}
 }
 
@@ -124,52 +704,145 @@ func (s *MQTTService) validatePayload(payload *models.MQTTPayload) error {
 		return fmt.Errorf("invalid timestamp")
 	}
 
+	if payload.Speed != nil && (*payload.Speed < 0 || *payload.Speed > 300) {
+		return fmt.Errorf("speed out of range")
+	}
+
+	if a := payload.Accelerometer; a != nil && (math.Abs(a.X) > 16 || math.Abs(a.Y) > 16 || math.Abs(a.Z) > 16) {
+		return fmt.Errorf("accelerometer out of range")
+	}
+
 	return nil
 }
 
-// checkGeofence checks if vehicle entered geofence area
-func (s *MQTTService) checkGeofence(payload *models.MQTTPayload) {
+// checkGeofence detects geofence entries and exits. Events are only sent on
+// a transition, not for every point inside an area.
+func (s *MQTTService) checkGeofence(ctx context.Context, location *models.VehicleLocation) {
+	ctx, span := tracing.Tracer().Start(ctx, "geofence.check")
+	defer span.End()
+
+	lat, lon := location.Position(s.geofenceSource)
+	metrics.GeofenceEvaluations.Inc()
+	logger := s.logger.With("vehicle_id", location.VehicleID)
+
 	areas, err := s.repo.GetGeofenceAreas()
 	if err != nil {
-		log.Printf("[MQTT-SERVICE][ERROR] >>> Failed to get geofence areas: %v", err)
+		logger.ErrorContext(ctx, "Failed to get geofence areas", "error", err)
+		tracing.RecordError(span, err)
 		return
 	}
 
+	byID := make(map[int]models.GeofenceArea, len(areas))
+	inside := map[int]bool{}
+	held := map[int]bool{}
 	for _, area := range areas {
+		byID[area.ID] = area
+
 		distance := s.geofence.CalculateDistance(
-			payload.Latitude, payload.Longitude,
+			lat, lon,
 			area.CenterLatitude, area.CenterLongitude,
 		)
 
 		// Check if within radius
 		if distance <= float64(area.RadiusMeters) {
-			log.Printf("[MQTT-SERVICE][ALERT] >>> Vehicle %s entered geofence: %s (%.2fm away)",
-				payload.VehicleID, area.Name, distance)
-
-			if s.rabbitmq != nil {
-				event := models.GeofenceEvent{
-					VehicleID: payload.VehicleID,
-					Event:     "geofence_entry",
-					Location: models.Location{
-						Latitude:  payload.Latitude,
-						Longitude: payload.Longitude,
-					},
-					Timestamp: payload.Timestamp,
-					AreaName:  area.Name,
-				}
-
-				if err := s.rabbitmq.PublishEvent(&event); err != nil {
-					log.Printf("[MQTT-SERVICE][ERROR] >>> Failed to publish event: %v", err)
-				}
-			} else {
-				log.Printf("[MQTT-SERVICE][WARN] >>> RabbitMQ not connected, skipping event publish")
+			inside[area.ID] = true
+		}
+		if distance <= float64(area.RadiusMeters)+GeofenceExitMargin {
+			held[area.ID] = true
+		}
+	}
+
+	entered, exited := s.tracker.Update(location.VehicleID, location.Timestamp, inside, held)
+	span.SetAttributes(
+		attribute.Int("geofence.areas", len(areas)),
+		attribute.Int("geofence.entered", len(entered)),
+		attribute.Int("geofence.exited", len(exited)),
+	)
+
+	for _, exit := range exited {
+		area, ok := byID[exit.AreaID]
+		if !ok {
+			continue
+		}
+
+		logging.Alert(ctx, logger, "Vehicle exited geofence", "area", area.Name)
+
+		if s.visits != nil {
+			if err := s.visits.Depart(location.VehicleID, area.ID, exit.LastInside); err != nil {
+				logger.ErrorContext(ctx, "Failed to record departure", "area", area.Name, "error", err)
+			}
+		}
+
+		s.publishGeofenceEvent(ctx, "geofence_exit", location, lat, lon, area, nil)
+	}
+
+	for _, areaID := range entered {
+		area := byID[areaID]
+
+		logging.Alert(ctx, logger, "Vehicle entered geofence", "area", area.Name)
+
+		var progress *models.RouteProgress
+		if s.routes != nil {
+			progress, err = s.routes.StopArrival(location.VehicleID, area.ID, location.Timestamp)
+			if err != nil {
+				logger.ErrorContext(ctx, "Failed to update route progress", "area", area.Name, "error", err)
+			} else if progress != nil {
+				logger.InfoContext(ctx, "Vehicle at route stop", "route_id", progress.RouteID,
+					"stop_sequence", progress.StopSequence, "stops_total", progress.StopsTotal)
+			}
+		}
+
+		if s.visits != nil {
+			visit, err := s.visits.Arrive(location.VehicleID, location.DriverID, area.ID, location.Timestamp, progress)
+			if err != nil {
+				logger.ErrorContext(ctx, "Failed to record arrival", "area", area.Name, "error", err)
+			} else if visit.ArrivalDelay != nil {
+				logger.InfoContext(ctx, "Vehicle arrived at stop", "area", area.Name, "delay_seconds", *visit.ArrivalDelay)
+			}
+		}
+
+		if s.headways != nil && progress != nil {
+			if err := s.headways.Arrival(location.VehicleID, location.DriverID, progress, area, location.Timestamp); err != nil {
+				logger.ErrorContext(ctx, "Failed to check headway", "area", area.Name, "error", err)
 			}
 		}
+
+		s.publishGeofenceEvent(ctx, "geofence_entry", location, lat, lon, area, progress)
+	}
+}
+
+func (s *MQTTService) publishGeofenceEvent(ctx context.Context, eventType string, location *models.VehicleLocation,
+	lat, lon float64, area models.GeofenceArea, progress *models.RouteProgress) {
+	metrics.GeofenceHits.WithLabelValues(eventType).Inc()
+
+	if s.rabbitmq == nil {
+		s.logger.WarnContext(ctx, "RabbitMQ not connected, skipping event publish", "event", eventType,
+			"vehicle_id", location.VehicleID)
+		return
+	}
+
+	event := models.GeofenceEvent{
+		EventID:   models.NewEventID(location.VehicleID, eventType, area.Name, fmt.Sprint(location.Timestamp)),
+		VehicleID: location.VehicleID,
+		Event:     eventType,
+		Location: models.Location{
+			Latitude:  lat,
+			Longitude: lon,
+		},
+		Timestamp: location.Timestamp,
+		AreaName:  area.Name,
+		DriverID:  location.DriverID,
+		Route:     progress,
+	}
+
+	if err := s.rabbitmq.PublishEvent(ctx, &event); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to publish event", "event", eventType, "vehicle_id", location.VehicleID,
+			"error", err)
 	}
 }
 
 // Disconnect from MQTT broker
 func (s *MQTTService) Disconnect() {
 	s.client.Disconnect(250)
-	log.Println("[MQTT-SERVICE][INFO] >>> Disconnected from MQTT broker")
+	s.logger.Info("Disconnected from MQTT broker")
 }
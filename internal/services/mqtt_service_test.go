@@ -0,0 +1,122 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/logging"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// testMessage is an MQTT message that records its acknowledgement
+type testMessage struct {
+	topic string
+	acked bool
+}
+
+func (m *testMessage) Duplicate() bool   { return false }
+func (m *testMessage) Qos() byte         { return 1 }
+func (m *testMessage) Retained() bool    { return false }
+func (m *testMessage) Topic() string     { return m.topic }
+func (m *testMessage) MessageID() uint16 { return 1 }
+func (m *testMessage) Payload() []byte   { return nil }
+func (m *testMessage) Ack()              { m.acked = true }
+
+// newShardService returns a service without client handling one shard
+func newShardService(index, count int) *MQTTService {
+	s := &MQTTService{logger: logging.New("mqtt-service")}
+	s.SetShard(index, count)
+	return s
+}
+
+func TestMQTTServiceShards(t *testing.T) {
+	tests := []struct {
+		name   string
+		shards int
+	}{
+		{"single shard", 1},
+		{"two shards", 2},
+		{"five shards", 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled := make(map[string][]int) // topic -> shards that handled it
+			shards := make([]*MQTTService, tt.shards)
+			handlers := make([]mqtt.MessageHandler, tt.shards)
+			for i := range handlers {
+				shards[i] = newShardService(i, tt.shards)
+				handlers[i] = shards[i].track(func(client mqtt.Client, msg mqtt.Message) {
+					handled[msg.Topic()] = append(handled[msg.Topic()], i)
+				})
+			}
+
+			var topics []string
+			for v := range 50 {
+				topics = append(topics, fmt.Sprintf("/fleet/vehicle/B%d/location", v), fmt.Sprintf("/fleet/vehicle/B%d/driver", v))
+			}
+
+			for _, topic := range topics {
+				for i, handler := range handlers {
+					msg := &testMessage{topic: topic}
+					handler(nil, msg)
+					if !msg.acked {
+						t.Errorf("shard %d did not acknowledge %s", i, topic)
+					}
+				}
+			}
+
+			for v := range 50 {
+				location := handled[fmt.Sprintf("/fleet/vehicle/B%d/location", v)]
+				driver := handled[fmt.Sprintf("/fleet/vehicle/B%d/driver", v)]
+				if len(location) != 1 {
+					t.Fatalf("vehicle B%d handled by shards %v, want exactly one", v, location)
+				}
+				if len(driver) != 1 || driver[0] != location[0] {
+					t.Errorf("vehicle B%d: driver topic handled by %v, location by %v", v, driver, location)
+				}
+				// Seeding at startup uses the same ownership
+				for i, s := range shards {
+					if owns := s.OwnsVehicle(fmt.Sprintf("B%d", v)); owns != (i == location[0]) {
+						t.Errorf("shard %d OwnsVehicle(B%d) = %v, handled by shard %d", i, v, owns, location[0])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMQTTServiceInvalidTopicHandled(t *testing.T) {
+	// Topics without a vehicle go to the handler on every shard, which
+	// rejects them
+	for i := range 3 {
+		s := newShardService(i, 3)
+
+		called := false
+		s.track(func(client mqtt.Client, msg mqtt.Message) { called = true })(nil, &testMessage{topic: "/fleet/vehicle//location"})
+		if !called {
+			t.Errorf("shard %d skipped a topic without vehicle", i)
+		}
+	}
+}
+
+func TestTopicVehicleID(t *testing.T) {
+	tests := []struct {
+		topic  string
+		want   string
+		wantOK bool
+	}{
+		{"/fleet/vehicle/B1234XYZ/location", "B1234XYZ", true},
+		{"/fleet/vehicle/B1234XYZ/driver", "B1234XYZ", true},
+		{"/fleet/vehicle//location", "", false},
+		{"/fleet/vehicle/B1/extra/location", "", false},
+		{"fleet/vehicle/B1/location", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := topicVehicleID(tt.topic)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("topicVehicleID(%q) = %q, %v, want %q, %v", tt.topic, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
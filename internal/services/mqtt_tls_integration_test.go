@@ -0,0 +1,544 @@
+//go:build integration
+
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/mqttconn"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// Run with: go test -tags integration ./internal/services/
+
+const (
+	testBrokerUser     = "fleet"
+	testBrokerPassword = "s3cret"
+)
+
+// testCA is a throwaway certificate authority
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string // PEM of the CA certificate
+}
+
+func newTestCA(t *testing.T, dir, name string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse CA certificate: %v", err)
+	}
+
+	file := filepath.Join(dir, name+".crt")
+	writePEM(t, file, "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key, file: file}
+}
+
+// issue signs a server or client certificate and writes it with its key to
+// dir, it returns the certificate and key files
+func (ca *testCA) issue(t *testing.T, dir, name string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("failed to generate serial: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	if usage == x509.ExtKeyUsageServerAuth {
+		template.DNSNames = []string{"localhost"}
+		template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("failed to create certificate %s: %v", name, err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key %s: %v", name, err)
+	}
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, file, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", file, err)
+	}
+}
+
+// testBroker is a minimal MQTT 3.1.1 broker over mutual TLS. It checks the
+// credentials of CONNECT and grants every SUBSCRIBE, enough to run the
+// subscriber's connect and subscribe against it.
+type testBroker struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	conns    map[net.Conn]bool
+	connects []string // client IDs of the CONNECT packets received
+	topics   []string // topics subscribed by accepted clients
+	refuse   int      // number of next SUBSCRIBE packets to refuse
+}
+
+// startTestBroker listens on addr, a free port when it is empty
+func startTestBroker(t *testing.T, addr string, ca *testCA, certFile, keyFile string) *testBroker {
+	t.Helper()
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("failed to load broker certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	listener, err := tls.Listen("tcp", addr, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	b := &testBroker{listener: listener, conns: make(map[net.Conn]bool)}
+	t.Cleanup(func() { listener.Close() })
+	go b.serve()
+	return b
+}
+
+func (b *testBroker) URL() string {
+	return "ssl://" + b.listener.Addr().String()
+}
+
+func (b *testBroker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+// handle serves one client until it disconnects. The TLS handshake, and with
+// it the client certificate check, runs on the first read.
+func (b *testBroker) handle(conn net.Conn) {
+	b.mu.Lock()
+	b.conns[conn] = true
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, conn)
+		b.mu.Unlock()
+		conn.Close()
+	}()
+
+	packet, err := packets.ReadPacket(conn)
+	if err != nil {
+		return
+	}
+	connect, ok := packet.(*packets.ConnectPacket)
+	if !ok {
+		return
+	}
+
+	b.mu.Lock()
+	b.connects = append(b.connects, connect.ClientIdentifier)
+	b.mu.Unlock()
+
+	connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	if connect.Username != testBrokerUser || string(connect.Password) != testBrokerPassword {
+		connack.ReturnCode = packets.ErrRefusedBadUsernameOrPassword
+		connack.Write(conn)
+		return
+	}
+	if err := connack.Write(conn); err != nil {
+		return
+	}
+
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+
+		switch p := packet.(type) {
+		case *packets.SubscribePacket:
+			b.mu.Lock()
+			refuse := b.refuse > 0
+			if refuse {
+				b.refuse--
+			} else {
+				b.topics = append(b.topics, p.Topics...)
+			}
+			b.mu.Unlock()
+
+			suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			suback.MessageID = p.MessageID
+			for _, qos := range p.Qoss {
+				if refuse {
+					qos = 0x80
+				}
+				suback.ReturnCodes = append(suback.ReturnCodes, qos)
+			}
+			err = suback.Write(conn)
+		case *packets.UnsubscribePacket:
+			unsuback := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			unsuback.MessageID = p.MessageID
+			err = unsuback.Write(conn)
+		case *packets.PingreqPacket:
+			err = packets.NewControlPacket(packets.Pingresp).Write(conn)
+		case *packets.DisconnectPacket:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// dropClients closes every client connection, as a broker restart would
+func (b *testBroker) dropClients() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for conn := range b.conns {
+		conn.Close()
+	}
+}
+
+func (b *testBroker) seen() (connects, topics []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.connects...), append([]string(nil), b.topics...)
+}
+
+func TestMQTTServiceTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCA(t, dir, "fleet-test-ca")
+	serverCert, serverKey := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, dir, "client", x509.ExtKeyUsageClientAuth)
+
+	// A second CA, the broker must not accept its certificates and the
+	// subscriber must not trust a broker verified against it
+	otherCA := newTestCA(t, dir, "other-ca")
+	intruderCert, intruderKey := otherCA.issue(t, dir, "intruder", x509.ExtKeyUsageClientAuth)
+
+	broker := startTestBroker(t, "", ca, serverCert, serverKey)
+
+	tests := []struct {
+		name        string
+		modify      func(cfg *mqttconn.Config)
+		wantErr     string // empty when Subscribe must succeed
+		wantConnect bool   // the broker read a CONNECT, so TLS was accepted
+	}{
+		{
+			name:        "mutual TLS with password",
+			modify:      func(cfg *mqttconn.Config) {},
+			wantConnect: true,
+		},
+		{
+			name:    "broker verified against wrong CA",
+			modify:  func(cfg *mqttconn.Config) { cfg.CAFile = otherCA.file },
+			wantErr: "certificate signed by unknown authority",
+		},
+		{
+			name: "no client certificate",
+			modify: func(cfg *mqttconn.Config) {
+				cfg.CertFile = ""
+				cfg.KeyFile = ""
+			},
+			wantErr: "failed to connect",
+		},
+		{
+			name: "client certificate from other CA",
+			modify: func(cfg *mqttconn.Config) {
+				cfg.CertFile = intruderCert
+				cfg.KeyFile = intruderKey
+			},
+			wantErr: "failed to connect",
+		},
+		{
+			name:        "bad password",
+			modify:      func(cfg *mqttconn.Config) { cfg.Password = "wrong" },
+			wantErr:     "bad user name or password",
+			wantConnect: true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := mqttconn.Config{
+				Broker:       broker.URL(),
+				ClientID:     fmt.Sprintf("fleet_tls_test_%d", i),
+				CleanSession: true,
+				Username:     testBrokerUser,
+				Password:     testBrokerPassword,
+				CAFile:       ca.file,
+				CertFile:     clientCert,
+				KeyFile:      clientKey,
+			}
+			tt.modify(&cfg)
+
+			s, err := NewMQTTService(cfg, nil)
+			if err != nil {
+				t.Fatalf("NewMQTTService: %v", err)
+			}
+			defer s.Disconnect()
+
+			done := make(chan error, 1)
+			go func() { done <- s.Subscribe() }()
+
+			select {
+			case err = <-done:
+			case <-time.After(15 * time.Second):
+				t.Fatal("Subscribe did not return")
+			}
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Subscribe: %v", err)
+				}
+				if err := s.CheckConnection(t.Context()); err != nil {
+					t.Errorf("CheckConnection: %v", err)
+				}
+			} else {
+				if err == nil {
+					t.Fatalf("Subscribe succeeded, want error containing %q", tt.wantErr)
+				}
+				if !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Subscribe error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				if s.CheckConnection(t.Context()) == nil {
+					t.Error("CheckConnection succeeded without a connection")
+				}
+			}
+
+			connects, topics := broker.seen()
+			connected := false
+			for _, id := range connects {
+				if id == cfg.ClientID {
+					connected = true
+				}
+			}
+			if connected != tt.wantConnect {
+				t.Errorf("broker read CONNECT = %v, want %v", connected, tt.wantConnect)
+			}
+
+			if tt.wantErr == "" && !slices.Contains(topics, "/fleet/vehicle/+/location") {
+				t.Errorf("broker subscriptions = %v, want the location topic", topics)
+			}
+		})
+	}
+}
+
+// testServiceConfig returns a working connection config for the broker
+func testServiceConfig(t *testing.T, broker string, ca *testCA, certFile, keyFile string) mqttconn.Config {
+	return mqttconn.Config{
+		Broker:       broker,
+		ClientID:     "fleet_tls_test_" + t.Name(),
+		CleanSession: true,
+		Username:     testBrokerUser,
+		Password:     testBrokerPassword,
+		CAFile:       ca.file,
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+
+		ReconnectMaxInterval: 2 * time.Second,
+	}
+}
+
+// TestMQTTServiceConnectRetry checks that a broker that is down at startup
+// is waited for
+func TestMQTTServiceConnectRetry(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "fleet-test-ca")
+	serverCert, serverKey := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, dir, "client", x509.ExtKeyUsageClientAuth)
+
+	// Reserve a port for the broker started later
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	cfg := testServiceConfig(t, "ssl://"+addr, ca, clientCert, clientKey)
+	cfg.ConnectRetryInterval = 200 * time.Millisecond
+
+	s, err := NewMQTTService(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewMQTTService: %v", err)
+	}
+	defer s.Disconnect()
+
+	done := make(chan error, 1)
+	go func() { done <- s.Subscribe() }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Subscribe returned before the broker was up: %v", err)
+	case <-time.After(time.Second):
+	}
+	if s.CheckConnection(t.Context()) == nil {
+		t.Error("CheckConnection succeeded before the broker was up")
+	}
+
+	startTestBroker(t, addr, ca, serverCert, serverKey)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Subscribe did not return after the broker came up")
+	}
+	if err := s.CheckConnection(t.Context()); err != nil {
+		t.Errorf("CheckConnection: %v", err)
+	}
+}
+
+// TestMQTTServiceResubscribe checks that a subscribe refused after a
+// reconnect is retried instead of leaving the subscriber connected without
+// subscriptions
+func TestMQTTServiceResubscribe(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "fleet-test-ca")
+	serverCert, serverKey := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, dir, "client", x509.ExtKeyUsageClientAuth)
+	broker := startTestBroker(t, "", ca, serverCert, serverKey)
+
+	s, err := NewMQTTService(testServiceConfig(t, broker.URL(), ca, clientCert, clientKey), nil)
+	if err != nil {
+		t.Fatalf("NewMQTTService: %v", err)
+	}
+	defer s.Disconnect()
+
+	if err := s.Subscribe(); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	broker.mu.Lock()
+	broker.refuse = 2
+	broker.mu.Unlock()
+	broker.dropClients()
+
+	// Reconnect after 1s, then retries after 1s and 2s
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		broker.mu.Lock()
+		refused := broker.refuse == 0
+		broker.mu.Unlock()
+		if refused && s.CheckConnection(t.Context()) == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("not subscribed again after the refused subscribes: %v", s.CheckConnection(t.Context()))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	_, topics := broker.seen()
+	if len(topics) != 2 {
+		t.Errorf("broker subscriptions = %v, want the location topic before and after the reconnect", topics)
+	}
+}
+
+// TestClientOptionsTLSFiles checks the TLS file errors reported before any
+// connection is made
+func TestClientOptionsTLSFiles(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "fleet-test-ca")
+	clientCert, clientKey := ca.issue(t, dir, "client", x509.ExtKeyUsageClientAuth)
+
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cfg     mqttconn.Config
+		wantErr string
+	}{
+		{"valid", mqttconn.Config{CAFile: ca.file, CertFile: clientCert, KeyFile: clientKey}, ""},
+		{"missing CA bundle", mqttconn.Config{CAFile: filepath.Join(dir, "missing.crt")}, "failed to read CA bundle"},
+		{"CA bundle without certificates", mqttconn.Config{CAFile: empty}, "no certificates found"},
+		{"key does not match certificate", mqttconn.Config{CertFile: clientCert, KeyFile: filepath.Join(dir, "fleet-test-ca.crt")}, "failed to load client certificate"},
+		{"TLS files ignored without TLS", mqttconn.Config{Broker: "tcp://127.0.0.1:1883", CAFile: empty}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cfg.Broker == "" {
+				tt.cfg.Broker = "ssl://127.0.0.1:8883"
+			}
+			_, err := mqttconn.ClientOptions(tt.cfg)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("ClientOptions: %v", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("ClientOptions succeeded, want error containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Errorf("ClientOptions error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/logging"
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/asaaitika/fleetmgm-tst/internal/repositories"
+)
+
+// Quality flags stored with every location
+const (
+	QualityOK               = "ok"
+	QualityOutOfOrder       = "out_of_order"
+	QualityImplausibleSpeed = "implausible_speed"
+)
+
+var (
+	ErrFutureTimestamp = errors.New("timestamp is in the future")
+	ErrDuplicatePoint  = errors.New("duplicate point")
+	ErrStalePoint      = errors.New("point is older than the out-of-order window")
+)
+
+type PlausibilityConfig struct {
+	MaxSpeedKmh   float64       // speed above this between two points is a GPS jump
+	MaxClockSkew  time.Duration // how far in the future a timestamp may be
+	MaxOutOfOrder time.Duration // how late an out-of-order point may arrive and still be stored
+	JumpReset     int           // consecutive consistent jumps after which the new position is trusted
+}
+
+// String describes the filter settings for logging
+func (c PlausibilityConfig) String() string {
+	return fmt.Sprintf("max speed %.0f km/h, clock skew %s, out-of-order window %s",
+		c.MaxSpeedKmh, c.MaxClockSkew, c.MaxOutOfOrder)
+}
+
+// PlausibilityFilter checks every point against the last accepted point of the
+// same vehicle. Impossible points are rejected, suspicious points are stored
+// with a quality flag so they are kept for analysis but not used for geofencing.
+type PlausibilityFilter struct {
+	cfg      PlausibilityConfig
+	repo     *repositories.VehicleRepository
+	geofence *GeofenceService
+	logger   *slog.Logger
+
+	mu      sync.Mutex // guards the map, each anchor has its own lock
+	anchors map[string]*plausibilityAnchor
+}
+
+type plausibilityAnchor struct {
+	mu      sync.Mutex
+	loaded  bool                    // the last point was looked up in the database
+	point   *models.VehicleLocation // last accepted point, nil before the first one
+	pending *models.VehicleLocation
+	jumps   int
+}
+
+func NewPlausibilityFilter(cfg PlausibilityConfig, repo *repositories.VehicleRepository) *PlausibilityFilter {
+	return &PlausibilityFilter{
+		cfg:      cfg,
+		repo:     repo,
+		geofence: NewGeofenceService(),
+		logger:   logging.New("plausibility-filter"),
+		anchors:  make(map[string]*plausibilityAnchor),
+	}
+}
+
+// Check returns the quality flag for the payload, or an error when the point
+// must be rejected
+func (f *PlausibilityFilter) Check(payload *models.MQTTPayload, now time.Time) (string, error) {
+	if payload.Timestamp > now.Add(f.cfg.MaxClockSkew).Unix() {
+		return "", ErrFutureTimestamp
+	}
+
+	point := models.VehicleLocation{
+		VehicleID: payload.VehicleID,
+		Latitude:  payload.Latitude,
+		Longitude: payload.Longitude,
+		Timestamp: payload.Timestamp,
+	}
+
+	// Only points of the same vehicle wait for each other, including for the
+	// database lookup of its last point
+	anchor := f.anchor(payload.VehicleID)
+	anchor.mu.Lock()
+	defer anchor.mu.Unlock()
+
+	if !anchor.loaded {
+		anchor.point = f.loadLastPoint(payload.VehicleID)
+		anchor.loaded = true
+	}
+	if anchor.point == nil {
+		anchor.point = &point
+		return QualityOK, nil
+	}
+
+	last := *anchor.point
+	if point.Timestamp == last.Timestamp {
+		return "", ErrDuplicatePoint
+	}
+
+	if point.Timestamp < last.Timestamp {
+		if time.Duration(last.Timestamp-point.Timestamp)*time.Second > f.cfg.MaxOutOfOrder {
+			return "", ErrStalePoint
+		}
+		return QualityOutOfOrder, nil
+	}
+
+	if f.speedKmh(&last, &point) <= f.cfg.MaxSpeedKmh {
+		anchor.point = &point
+		anchor.pending = nil
+		anchor.jumps = 0
+		return QualityOK, nil
+	}
+
+	// The jump may be real if the device keeps reporting consistently from the
+	// new position (e.g. the first accepted point was the bad one)
+	if anchor.pending != nil && f.speedKmh(anchor.pending, &point) <= f.cfg.MaxSpeedKmh {
+		anchor.jumps++
+	} else {
+		anchor.jumps = 1
+	}
+	anchor.pending = &point
+
+	if f.cfg.JumpReset > 0 && anchor.jumps >= f.cfg.JumpReset {
+		f.logger.Warn("Consistent points away from last fix, trusting new position",
+			"vehicle_id", payload.VehicleID, "points", anchor.jumps)
+		anchor.point = &point
+		anchor.pending = nil
+		anchor.jumps = 0
+		return QualityOK, nil
+	}
+
+	return QualityImplausibleSpeed, nil
+}
+
+// anchor returns the state of a vehicle, created empty the first time the
+// vehicle is seen
+func (f *PlausibilityFilter) anchor(vehicleID string) *plausibilityAnchor {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	anchor, ok := f.anchors[vehicleID]
+	if !ok {
+		anchor = &plausibilityAnchor{}
+		f.anchors[vehicleID] = anchor
+	}
+	return anchor
+}
+
+// loadLastPoint returns the last stored location of a vehicle, so a restart
+// keeps checking against it, or nil when there is none
+func (f *PlausibilityFilter) loadLastPoint(vehicleID string) *models.VehicleLocation {
+	if f.repo == nil {
+		return nil
+	}
+
+	last, err := f.repo.GetLastLocation(vehicleID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			f.logger.Error("Failed to load last location", "vehicle_id", vehicleID, "error", err)
+		}
+		return nil
+	}
+	return last
+}
+
+func (f *PlausibilityFilter) speedKmh(from, to *models.VehicleLocation) float64 {
+	dt := to.Timestamp - from.Timestamp
+	if dt <= 0 {
+		return 0
+	}
+
+	distance := f.geofence.CalculateDistance(from.Latitude, from.Longitude, to.Latitude, to.Longitude)
+	return distance / float64(dt) * 3.6
+}
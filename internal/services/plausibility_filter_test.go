@@ -0,0 +1,107 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+)
+
+func TestPlausibilityFilterCheck(t *testing.T) {
+	cfg := PlausibilityConfig{
+		MaxSpeedKmh:   150,
+		MaxClockSkew:  30 * time.Second,
+		MaxOutOfOrder: 5 * time.Minute,
+		JumpReset:     3,
+	}
+
+	const t0 = int64(1700000000)
+	now := time.Unix(t0+1000, 0)
+
+	// 0.001° of latitude is about 111 m, 11 m/s (40 km/h) over 10 s
+	at := func(seconds int64, lat float64) models.MQTTPayload {
+		return models.MQTTPayload{VehicleID: "B1234XYZ", Latitude: -6.2 + lat, Longitude: 106.8, Timestamp: t0 + seconds}
+	}
+
+	type result struct {
+		quality string
+		err     error
+	}
+	ok := result{QualityOK, nil}
+
+	tests := []struct {
+		name   string
+		points []models.MQTTPayload
+		want   []result
+	}{
+		{"first point", []models.MQTTPayload{at(0, 0)}, []result{ok}},
+		{"normal drive", []models.MQTTPayload{at(0, 0), at(10, 0.001), at(20, 0.002)}, []result{ok, ok, ok}},
+		{"duplicate timestamp", []models.MQTTPayload{at(0, 0), at(0, 0.0001)},
+			[]result{ok, {"", ErrDuplicatePoint}}},
+		{"late point within the window", []models.MQTTPayload{at(0, 0), at(-60, -0.001)},
+			[]result{ok, {QualityOutOfOrder, nil}}},
+		{"late point past the window", []models.MQTTPayload{at(0, 0), at(-301, -0.001)},
+			[]result{ok, {"", ErrStalePoint}}},
+		{"out-of-order point keeps the anchor", []models.MQTTPayload{at(0, 0), at(-60, -0.001), at(10, 0.001)},
+			[]result{ok, {QualityOutOfOrder, nil}, ok}},
+		{"future timestamp past the clock skew", []models.MQTTPayload{at(1031, 0)},
+			[]result{{"", ErrFutureTimestamp}}},
+		{"future timestamp within the clock skew", []models.MQTTPayload{at(1020, 0)}, []result{ok}},
+		{"teleport", []models.MQTTPayload{at(0, 0), at(10, 0.1)},
+			[]result{ok, {QualityImplausibleSpeed, nil}}},
+		{"teleport does not move the anchor", []models.MQTTPayload{at(0, 0), at(10, 0.1), at(20, 0.001)},
+			[]result{ok, {QualityImplausibleSpeed, nil}, ok}},
+		{"consistent points after a jump are trusted",
+			[]models.MQTTPayload{at(0, 0), at(10, 0.1), at(20, 0.101), at(30, 0.102), at(40, 0.103)},
+			[]result{ok, {QualityImplausibleSpeed, nil}, {QualityImplausibleSpeed, nil}, ok, ok}},
+		{"scattered jumps are never trusted",
+			[]models.MQTTPayload{at(0, 0), at(10, 0.1), at(20, -0.1), at(30, 0.1), at(40, -0.1)},
+			[]result{ok, {QualityImplausibleSpeed, nil}, {QualityImplausibleSpeed, nil},
+				{QualityImplausibleSpeed, nil}, {QualityImplausibleSpeed, nil}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := NewPlausibilityFilter(cfg, nil)
+
+			for i, point := range tt.points {
+				quality, err := filter.Check(&point, now)
+				if quality != tt.want[i].quality || !errors.Is(err, tt.want[i].err) {
+					t.Fatalf("point %d: Check() = %q, %v, want %q, %v",
+						i, quality, err, tt.want[i].quality, tt.want[i].err)
+				}
+			}
+		})
+	}
+}
+
+func TestPlausibilityFilterVehiclesAreIndependent(t *testing.T) {
+	filter := NewPlausibilityFilter(PlausibilityConfig{MaxSpeedKmh: 150, MaxOutOfOrder: time.Minute}, nil)
+	now := time.Unix(1700001000, 0)
+
+	var wg sync.WaitGroup
+	for v := 0; v < 8; v++ {
+		wg.Add(1)
+		go func(v int) {
+			defer wg.Done()
+			for i := int64(0); i < 50; i++ {
+				// Every vehicle drives far from the others, only its own
+				// previous point counts
+				payload := models.MQTTPayload{
+					VehicleID: fmt.Sprintf("B%d", v),
+					Latitude:  -6.2 + float64(v) + float64(i)*0.0005,
+					Longitude: 106.8,
+					Timestamp: 1700000000 + i*5,
+				}
+				if quality, err := filter.Check(&payload, now); quality != QualityOK || err != nil {
+					t.Errorf("vehicle %d point %d: Check() = %q, %v", v, i, quality, err)
+					return
+				}
+			}
+		}(v)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+)
+
+const metersPerDegreeLat = 111320.0
+
+type SmoothingConfig struct {
+	GPSNoiseMeters float64       // standard deviation of a raw GPS fix
+	AccelNoise     float64       // expected acceleration noise in m/s²
+	MaxGap         time.Duration // the filter restarts after a gap longer than this
+}
+
+// PositionSmoother runs a constant-velocity Kalman filter per vehicle.
+// East and north are filtered independently in a local tangent plane around
+// the first fix, which is accurate enough at city scale.
+type PositionSmoother struct {
+	cfg SmoothingConfig
+
+	mu      sync.Mutex
+	filters map[string]*kalmanTrack
+}
+
+type kalmanTrack struct {
+	originLat, originLon float64
+	ts                   int64
+	east, north          kalmanAxis
+}
+
+// kalmanAxis holds position/velocity state and its covariance for one axis
+type kalmanAxis struct {
+	pos, vel      float64
+	p00, p01, p11 float64
+}
+
+func NewPositionSmoother(cfg SmoothingConfig) *PositionSmoother {
+	return &PositionSmoother{
+		cfg:     cfg,
+		filters: make(map[string]*kalmanTrack),
+	}
+}
+
+// Smooth feeds an accepted location into the vehicle's filter and fills in
+// the smoothed position, speed and heading
+func (s *PositionSmoother) Smooth(location *models.VehicleLocation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.cfg.GPSNoiseMeters * s.cfg.GPSNoiseMeters
+
+	track, ok := s.filters[location.VehicleID]
+	dt := 0.0
+	if ok {
+		dt = float64(location.Timestamp - track.ts)
+	}
+
+	if !ok || dt <= 0 || time.Duration(dt)*time.Second > s.cfg.MaxGap {
+		track = &kalmanTrack{
+			originLat: location.Latitude,
+			originLon: location.Longitude,
+			ts:        location.Timestamp,
+			east:      kalmanAxis{p00: r, p11: 100},
+			north:     kalmanAxis{p00: r, p11: 100},
+		}
+		s.filters[location.VehicleID] = track
+	} else {
+		east, north := track.toLocal(location.Latitude, location.Longitude)
+		q := s.cfg.AccelNoise * s.cfg.AccelNoise
+		track.east.step(east, dt, q, r)
+		track.north.step(north, dt, q, r)
+		track.ts = location.Timestamp
+	}
+
+	lat, lon := track.toGeo(track.east.pos, track.north.pos)
+	speed := math.Hypot(track.east.vel, track.north.vel) * 3.6
+	heading := math.Mod(math.Atan2(track.east.vel, track.north.vel)*180/math.Pi+360, 360)
+
+	location.SmoothedLatitude = &lat
+	location.SmoothedLongitude = &lon
+	location.SmoothedSpeedKmh = &speed
+	location.SmoothedHeading = &heading
+}
+
+// step runs one predict/update cycle with measurement z after dt seconds
+func (a *kalmanAxis) step(z, dt, q, r float64) {
+	// Predict: x = F x, P = F P F' + Q
+	a.pos += a.vel * dt
+	p00 := a.p00 + dt*(2*a.p01+dt*a.p11) + q*dt*dt*dt*dt/4
+	p01 := a.p01 + dt*a.p11 + q*dt*dt*dt/2
+	p11 := a.p11 + q*dt*dt
+
+	// Update with the position measurement
+	innovation := z - a.pos
+	s := p00 + r
+	k0 := p00 / s
+	k1 := p01 / s
+
+	a.pos += k0 * innovation
+	a.vel += k1 * innovation
+	a.p00 = (1 - k0) * p00
+	a.p01 = (1 - k0) * p01
+	a.p11 = p11 - k1*p01
+}
+
+func (t *kalmanTrack) toLocal(lat, lon float64) (float64, float64) {
+	east := (lon - t.originLon) * metersPerDegreeLat * math.Cos(t.originLat*math.Pi/180)
+	north := (lat - t.originLat) * metersPerDegreeLat
+	return east, north
+}
+
+func (t *kalmanTrack) toGeo(east, north float64) (float64, float64) {
+	lat := t.originLat + north/metersPerDegreeLat
+	lon := t.originLon + east/(metersPerDegreeLat*math.Cos(t.originLat*math.Pi/180))
+	return lat, lon
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+)
+
+var smootherTestConfig = SmoothingConfig{GPSNoiseMeters: 10, AccelNoise: 1.5, MaxGap: time.Minute}
+
+const smootherOriginLat, smootherOriginLon = -6.2, 106.8
+
+// fixAt returns a location east and north meters away from the test origin
+func fixAt(seconds int64, east, north float64) *models.VehicleLocation {
+	return &models.VehicleLocation{
+		VehicleID: "B1234XYZ",
+		Latitude:  smootherOriginLat + north/metersPerDegreeLat,
+		Longitude: smootherOriginLon + east/(metersPerDegreeLat*math.Cos(smootherOriginLat*math.Pi/180)),
+		Timestamp: 1700000000 + seconds,
+	}
+}
+
+// smoothedOffset returns the smoothed position as meters east and north of
+// the test origin
+func smoothedOffset(location *models.VehicleLocation) (float64, float64) {
+	east := (*location.SmoothedLongitude - smootherOriginLon) * metersPerDegreeLat * math.Cos(smootherOriginLat*math.Pi/180)
+	north := (*location.SmoothedLatitude - smootherOriginLat) * metersPerDegreeLat
+	return east, north
+}
+
+func angleDiff(a, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), 360)
+	return math.Min(d, 360-d)
+}
+
+func TestPositionSmootherFirstFix(t *testing.T) {
+	smoother := NewPositionSmoother(smootherTestConfig)
+	location := fixAt(0, 0, 0)
+	smoother.Smooth(location)
+
+	if *location.SmoothedLatitude != location.Latitude || *location.SmoothedLongitude != location.Longitude {
+		t.Errorf("first fix smoothed to %f,%f, want the raw position", *location.SmoothedLatitude, *location.SmoothedLongitude)
+	}
+	if *location.SmoothedSpeedKmh != 0 {
+		t.Errorf("first fix speed = %f, want 0", *location.SmoothedSpeedKmh)
+	}
+}
+
+func TestPositionSmootherConvergence(t *testing.T) {
+	tests := []struct {
+		name        string
+		headingDeg  float64
+		speedMS     float64
+		wantHeading float64
+	}{
+		{"north", 0, 10, 0},
+		{"east", 90, 10, 90},
+		{"south", 180, 15, 180},
+		{"west", 270, 8, 270},
+		{"north-east", 45, 12, 45},
+		{"north-west", 315, 12, 315},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			smoother := NewPositionSmoother(smootherTestConfig)
+			rad := tt.headingDeg * math.Pi / 180
+
+			var last *models.VehicleLocation
+			for i := int64(0); i <= 40; i++ {
+				distance := tt.speedMS * float64(i*5)
+				last = fixAt(i*5, distance*math.Sin(rad), distance*math.Cos(rad))
+				smoother.Smooth(last)
+			}
+
+			if got, want := *last.SmoothedSpeedKmh, tt.speedMS*3.6; math.Abs(got-want) > 0.5 {
+				t.Errorf("speed = %.2f km/h, want %.2f", got, want)
+			}
+			if d := angleDiff(*last.SmoothedHeading, tt.wantHeading); d > 1 {
+				t.Errorf("heading = %.1f°, want %.1f°", *last.SmoothedHeading, tt.wantHeading)
+			}
+			if *last.SmoothedHeading < 0 || *last.SmoothedHeading >= 360 {
+				t.Errorf("heading %.1f° outside [0, 360)", *last.SmoothedHeading)
+			}
+
+			distance := tt.speedMS * 200
+			east, north := smoothedOffset(last)
+			if miss := math.Hypot(east-distance*math.Sin(rad), north-distance*math.Cos(rad)); miss > 1 {
+				t.Errorf("smoothed position %.1f m off the track", miss)
+			}
+		})
+	}
+}
+
+func TestPositionSmootherReducesNoise(t *testing.T) {
+	smoother := NewPositionSmoother(smootherTestConfig)
+	noise := rand.New(rand.NewSource(42))
+
+	var rawErr, smoothErr float64
+	var samples int
+	// Fixes every 2 s (the default publish interval), with 10 m of noise
+	for i := int64(0); i <= 150; i++ {
+		north := 10 * float64(i*2)
+		noiseEast, noiseNorth := noise.NormFloat64()*10, noise.NormFloat64()*10
+		location := fixAt(i*2, noiseEast, north+noiseNorth)
+		smoother.Smooth(location)
+
+		// Skip the first fixes while the filter settles
+		if i < 20 {
+			continue
+		}
+		east, smoothedNorth := smoothedOffset(location)
+		rawErr += noiseEast*noiseEast + noiseNorth*noiseNorth
+		smoothErr += east*east + (smoothedNorth-north)*(smoothedNorth-north)
+		samples++
+	}
+
+	rawRMS, smoothRMS := math.Sqrt(rawErr/float64(samples)), math.Sqrt(smoothErr/float64(samples))
+	if smoothRMS >= rawRMS*0.8 {
+		t.Errorf("smoothed error %.1f m, raw error %.1f m, want at least 20%% less", smoothRMS, rawRMS)
+	}
+}
+
+func TestPositionSmootherResets(t *testing.T) {
+	tests := []struct {
+		name      string
+		nextAt    int64 // seconds after the last fix of a 10 m/s drive north
+		wantReset bool
+	}{
+		{"regular interval", 5, false},
+		{"gap at the limit", 60, false},
+		{"gap past the limit", 61, true},
+		{"same timestamp", 0, true},
+		{"older timestamp", -5, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			smoother := NewPositionSmoother(smootherTestConfig)
+			for i := int64(0); i <= 20; i++ {
+				smoother.Smooth(fixAt(i*5, 0, 10*float64(i*5)))
+			}
+
+			// The next fix is 30 m to the east of the track, a restarted
+			// filter takes it as is
+			next := fixAt(100+tt.nextAt, 30, 10*float64(100+tt.nextAt))
+			smoother.Smooth(next)
+
+			reset := *next.SmoothedLatitude == next.Latitude && *next.SmoothedLongitude == next.Longitude &&
+				*next.SmoothedSpeedKmh == 0
+			if reset != tt.wantReset {
+				t.Errorf("reset = %v, want %v (speed %.1f km/h)", reset, tt.wantReset, *next.SmoothedSpeedKmh)
+			}
+		})
+	}
+}
+
+func TestPositionSmootherVehiclesAreIndependent(t *testing.T) {
+	smoother := NewPositionSmoother(smootherTestConfig)
+	for i := int64(0); i <= 20; i++ {
+		smoother.Smooth(fixAt(i*5, 0, 10*float64(i*5)))
+	}
+
+	other := fixAt(105, 500, 500)
+	other.VehicleID = "B5678ABC"
+	smoother.Smooth(other)
+	if *other.SmoothedSpeedKmh != 0 || *other.SmoothedLatitude != other.Latitude {
+		t.Error("a new vehicle must start its own filter")
+	}
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/logging"
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+)
+
+const (
+	PresenceOnline  = "online"
+	PresenceStale   = "stale"
+	PresenceOffline = "offline"
+)
+
+// PresencePolicy holds the silence thresholds used to classify a vehicle
+type PresencePolicy struct {
+	StaleAfter   time.Duration
+	OfflineAfter time.Duration
+}
+
+// Status classifies a vehicle by the age of its last reported point
+func (p PresencePolicy) Status(lastSeen int64, now time.Time) (string, time.Duration) {
+	age := now.Sub(time.Unix(lastSeen, 0))
+	if age < 0 {
+		age = 0
+	}
+
+	switch {
+	case age >= p.OfflineAfter:
+		return PresenceOffline, age
+	case age >= p.StaleAfter:
+		return PresenceStale, age
+	default:
+		return PresenceOnline, age
+	}
+}
+
+// PresenceMonitor tracks the last-seen time of every vehicle and publishes
+// vehicle_offline / vehicle_online events when the offline threshold is crossed
+type PresenceMonitor struct {
+	policy   PresencePolicy
+	rabbitmq *RabbitMQService
+	logger   *slog.Logger
+
+	mu       sync.Mutex
+	lastSeen map[string]int64
+	drivers  map[string]string // driver on duty at the last point, by vehicle
+	offline  map[string]bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewPresenceMonitor(policy PresencePolicy) *PresenceMonitor {
+	return &PresenceMonitor{
+		policy:   policy,
+		logger:   logging.New("presence-monitor"),
+		lastSeen: make(map[string]int64),
+		drivers:  make(map[string]string),
+		offline:  make(map[string]bool),
+	}
+}
+
+// SetRabbitMQService inject rabbitmq service
+func (m *PresenceMonitor) SetRabbitMQService(rmq *RabbitMQService) {
+	m.rabbitmq = rmq
+}
+
+// Seed loads known last-seen times, e.g. from the database at startup.
+// Vehicles that are already silent are marked offline without an event.
+func (m *PresenceMonitor) Seed(lastSeen map[string]int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for vehicleID, ts := range lastSeen {
+		m.lastSeen[vehicleID] = ts
+		if status, _ := m.policy.Status(ts, now); status == PresenceOffline {
+			m.offline[vehicleID] = true
+		}
+	}
+}
+
+// Touch records a new point of a vehicle and publishes vehicle_online
+// when the vehicle was offline. driverID is the driver on duty, nil when
+// unknown.
+func (m *PresenceMonitor) Touch(vehicleID string, driverID *string, ts int64) {
+	m.mu.Lock()
+	previous, known := m.lastSeen[vehicleID]
+	if ts > previous {
+		m.lastSeen[vehicleID] = ts
+		if driverID != nil {
+			m.drivers[vehicleID] = *driverID
+		} else {
+			delete(m.drivers, vehicleID)
+		}
+	}
+	wasOffline := m.offline[vehicleID]
+	delete(m.offline, vehicleID)
+	m.mu.Unlock()
+
+	if !wasOffline {
+		return
+	}
+
+	details := map[string]interface{}{}
+	if known {
+		details["offline_seconds"] = ts - previous
+	}
+	if driverID != nil {
+		details["driver_id"] = *driverID
+	}
+
+	m.logger.Info("Vehicle is back online", "vehicle_id", vehicleID)
+	m.publish("vehicle.online", &models.VehicleEvent{
+		EventID:   models.NewEventID(vehicleID, "vehicle_online", fmt.Sprint(ts)),
+		VehicleID: vehicleID,
+		Event:     "vehicle_online",
+		Timestamp: ts,
+		Details:   details,
+	})
+}
+
+// Start checks for silent vehicles on every interval until Stop is called
+func (m *PresenceMonitor) Start(interval time.Duration) {
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+
+	go func() {
+		defer close(m.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.check(time.Now())
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+
+	m.logger.Info("Started", "stale_after", m.policy.StaleAfter.String(), "offline_after", m.policy.OfflineAfter.String())
+}
+
+// Stop stops the background check
+func (m *PresenceMonitor) Stop() {
+	if m.stop == nil {
+		return
+	}
+	close(m.stop)
+	<-m.done
+}
+
+func (m *PresenceMonitor) check(now time.Time) {
+	var events []*models.VehicleEvent
+
+	m.mu.Lock()
+	for vehicleID, ts := range m.lastSeen {
+		if m.offline[vehicleID] {
+			continue
+		}
+
+		status, age := m.policy.Status(ts, now)
+		if status != PresenceOffline {
+			continue
+		}
+
+		m.offline[vehicleID] = true
+		details := map[string]interface{}{
+			"last_seen":     ts,
+			"last_seen_age": int64(age.Seconds()),
+		}
+		// The driver on duty when the vehicle was last seen
+		if driverID, ok := m.drivers[vehicleID]; ok {
+			details["driver_id"] = driverID
+		}
+		events = append(events, &models.VehicleEvent{
+			EventID:   models.NewEventID(vehicleID, "vehicle_offline", fmt.Sprint(ts)),
+			VehicleID: vehicleID,
+			Event:     "vehicle_offline",
+			Timestamp: now.Unix(),
+			Details:   details,
+		})
+	}
+	m.mu.Unlock()
+
+	for _, event := range events {
+		m.logger.Warn("Vehicle went offline", "vehicle_id", event.VehicleID, "last_seen_age", event.Details["last_seen_age"])
+		m.publish("vehicle.offline", event)
+	}
+}
+
+func (m *PresenceMonitor) publish(routingKey string, event *models.VehicleEvent) {
+	if m.rabbitmq == nil {
+		m.logger.Warn("RabbitMQ not connected, skipping event", "event", event.Event, "vehicle_id", event.VehicleID)
+		return
+	}
+
+	if err := m.rabbitmq.PublishVehicleEvent(routingKey, event); err != nil {
+		m.logger.Error("Failed to publish event", "event", event.Event, "vehicle_id", event.VehicleID, "error", err)
+	}
+}
@@ -1,18 +1,29 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
+	"github.com/asaaitika/fleetmgm-tst/internal/logging"
+	"github.com/asaaitika/fleetmgm-tst/internal/metrics"
 	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/asaaitika/fleetmgm-tst/internal/tracing"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// publishConfirmTimeout bounds how long a publish waits for the broker to
+// confirm the event
+const publishConfirmTimeout = 5 * time.Second
+
 type RabbitMQService struct {
 	conn    *amqp.Connection
 	channel *amqp.Channel
+	logger  *slog.Logger
 }
 
 func NewRabbitMQService(url string) (*RabbitMQService, error) {
@@ -27,74 +38,111 @@ func NewRabbitMQService(url string) (*RabbitMQService, error) {
 		return nil, fmt.Errorf("failed to open channel: %v", err)
 	}
 
-	// Declare exchange
-	err = ch.ExchangeDeclare(
-		"fleet.events", // exchange name
-		"topic",        // type
-		true,           // durable
-		false,          // auto-deleted
-		false,          // internal
-		false,          // no-wait
-		nil,            // arguments
-	)
-	if err != nil {
+	// Every event is confirmed by the broker before it counts as published
+	if err := ch.Confirm(false); err != nil {
 		ch.Close()
 		conn.Close()
-		return nil, fmt.Errorf("failed to declare exchange: %v", err)
+		return nil, fmt.Errorf("failed to enable publisher confirms: %v", err)
 	}
 
-	// Declare queue
-	_, err = ch.QueueDeclare(
-		"geofence_alerts", // queue name
-		true,              // durable
-		false,             // delete when unused
-		false,             // exclusive
-		false,             // no-wait
-		nil,               // arguments
-	)
-	if err != nil {
-		ch.Close()
-		conn.Close()
-		return nil, fmt.Errorf("failed to declare queue: %v", err)
-	}
-
-	// Bind queue to exchange
-	err = ch.QueueBind(
-		"geofence_alerts", // queue name
-		"geofence.#",      // routing key pattern
-		"fleet.events",    // exchange
-		false,
-		nil,
-	)
-	if err != nil {
+	// Declare exchange, queue and dead-letter routing
+	if err := DeclareGeofenceTopology(ch); err != nil {
 		ch.Close()
 		conn.Close()
-		return nil, fmt.Errorf("failed to bind queue: %v", err)
+		return nil, err
 	}
 
-	log.Println("[RABBITMQ-SERVICE][INFO] >>> Connected to RabbitMQ")
+	logger := logging.New("rabbitmq-service")
+	logger.Info("Connected to RabbitMQ")
 
 	return &RabbitMQService{
 		conn:    conn,
 		channel: ch,
+		logger:  logger,
 	}, nil
 }
 
-// PublishEvent sends geofence event to RabbitMQ
-func (s *RabbitMQService) PublishEvent(event *models.GeofenceEvent) error {
+// PublishEvent sends geofence event to RabbitMQ, routed as geofence.entry or
+// geofence.exit. The trace context of ctx travels along in the message headers.
+func (s *RabbitMQService) PublishEvent(ctx context.Context, event *models.GeofenceEvent) error {
+	routingKey, action := "geofence.entry", "entered"
+	if event.Event == "geofence_exit" {
+		routingKey, action = "geofence.exit", "exited"
+	}
+
+	if err := s.publish(ctx, routingKey, event.EventID, event.VehicleID, event); err != nil {
+		return err
+	}
+
+	s.logger.InfoContext(ctx, "Published event", "event", event.Event, "vehicle_id", event.VehicleID,
+		"action", action, "area", event.AreaName)
+
+	return nil
+}
+
+// PublishVehicleEvent sends a vehicle event to RabbitMQ with the given routing key
+func (s *RabbitMQService) PublishVehicleEvent(routingKey string, event *models.VehicleEvent) error {
+	if err := s.publish(context.Background(), routingKey, event.EventID, event.VehicleID, event); err != nil {
+		return err
+	}
+
+	s.logger.Info("Published event", "event", event.Event, "vehicle_id", event.VehicleID)
+
+	return nil
+}
+
+// PublishSubscriberEvent sends an event about the subscriber itself to
+// RabbitMQ with the given routing key
+func (s *RabbitMQService) PublishSubscriberEvent(routingKey string, event *models.SubscriberEvent) error {
+	if err := s.publish(context.Background(), routingKey, event.EventID, "", event); err != nil {
+		return err
+	}
+
+	s.logger.Info("Published event", "event", event.Event, "client_id", event.ClientID)
+
+	return nil
+}
+
+func (s *RabbitMQService) publish(ctx context.Context, routingKey, messageID, vehicleID string, event interface{}) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "rabbitmq.publish", trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", EventsExchange),
+			attribute.String("messaging.rabbitmq.destination.routing_key", routingKey),
+			attribute.String("messaging.message.id", messageID),
+			attribute.String("vehicle.id", vehicleID),
+		))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
 	body, err := json.Marshal(event)
 	if err != nil {
+		metrics.RabbitMQPublishFailures.WithLabelValues(routingKey).Inc()
 		return fmt.Errorf("failed to marshal event: %v", err)
 	}
 
+	headers := amqp.Table{}
+	if vehicleID != "" {
+		headers["vehicle_id"] = vehicleID
+	}
+	tracing.Inject(ctx, headers)
+
+	ctx, cancel := context.WithTimeout(ctx, publishConfirmTimeout)
+	defer cancel()
+
 	// Publish message
-	err = s.channel.Publish(
-		"fleet.events",   // exchange
-		"geofence.entry", // routing key
-		false,            // mandatory
-		false,            // immediate
+	confirm, err := s.channel.PublishWithDeferredConfirmWithContext(
+		ctx,
+		EventsExchange, // exchange
+		routingKey,     // routing key
+		false,          // mandatory
+		false,          // immediate
 		amqp.Publishing{
 			ContentType:  "application/json",
+			MessageId:    messageID,
+			Headers:      headers,
 			Body:         body,
 			DeliveryMode: amqp.Persistent,
 			Timestamp:    time.Now(),
@@ -102,16 +150,35 @@ func (s *RabbitMQService) PublishEvent(event *models.GeofenceEvent) error {
 	)
 
 	if err != nil {
+		metrics.RabbitMQPublishFailures.WithLabelValues(routingKey).Inc()
 		return fmt.Errorf("failed to publish message: %v", err)
 	}
 
-	log.Printf("[RABBITMQ-SERVICE][INFO] >>> Published event: Vehicle %s entered %s",
-		event.VehicleID, event.AreaName)
+	// Waiting for the confirm means an event is never lost silently, and
+	// nothing is left in flight when the channel is closed on shutdown
+	if ok, err := confirm.WaitContext(ctx); err != nil || !ok {
+		metrics.RabbitMQPublishFailures.WithLabelValues(routingKey).Inc()
+		return fmt.Errorf("broker did not confirm event %s: %v", messageID, err)
+	}
+
+	metrics.RabbitMQPublished.WithLabelValues(routingKey).Inc()
+	return nil
+}
 
+// CheckConnection reports whether the connection and the publishing channel
+// are open, for readiness checks
+func (s *RabbitMQService) CheckConnection(ctx context.Context) error {
+	if s.conn == nil || s.conn.IsClosed() {
+		return fmt.Errorf("connection closed")
+	}
+	if s.channel == nil || s.channel.IsClosed() {
+		return fmt.Errorf("channel closed")
+	}
 	return nil
 }
 
-// Close closes RabbitMQ connection
+// Close closes the channel and the connection. Every publish waited for its
+// confirm, so the events published before are stored by the broker.
 func (s *RabbitMQService) Close() {
 	if s.channel != nil {
 		s.channel.Close()
@@ -119,5 +186,5 @@ func (s *RabbitMQService) Close() {
 	if s.conn != nil {
 		s.conn.Close()
 	}
-	log.Println("[RABBITMQ-SERVICE][INFO] >>> Disconnected from RabbitMQ")
+	s.logger.Info("Disconnected from RabbitMQ")
 }
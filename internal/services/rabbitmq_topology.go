@@ -0,0 +1,217 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	EventsExchange     = "fleet.events"
+	DeadLetterExchange = "fleet.events.dlx"
+
+	GeofenceAlertsQueue  = "geofence_alerts"
+	GeofenceParkingQueue = "geofence_alerts.parking"
+
+	// RetryCountHeader counts how many times a message was sent to a retry queue
+	RetryCountHeader = "x-retry-count"
+	// LastErrorHeader keeps the error of the last failed attempt
+	LastErrorHeader = "x-last-error"
+)
+
+// DeclareGeofenceTopology declares the events exchange, the geofence_alerts
+// queue and its dead-letter exchange with the parking-lot queue.
+// Messages rejected without requeue end up in the parking-lot queue.
+func DeclareGeofenceTopology(ch *amqp.Channel) error {
+	err := ch.ExchangeDeclare(
+		EventsExchange, // exchange name
+		"topic",        // type
+		true,           // durable
+		false,          // auto-deleted
+		false,          // internal
+		false,          // no-wait
+		nil,            // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare exchange: %v", err)
+	}
+
+	err = ch.ExchangeDeclare(
+		DeadLetterExchange, // exchange name
+		"direct",           // type
+		true,               // durable
+		false,              // auto-deleted
+		false,              // internal
+		false,              // no-wait
+		nil,                // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare dead-letter exchange: %v", err)
+	}
+
+	_, err = ch.QueueDeclare(
+		GeofenceParkingQueue, // queue name
+		true,                 // durable
+		false,                // delete when unused
+		false,                // exclusive
+		false,                // no-wait
+		nil,                  // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare parking-lot queue: %v", err)
+	}
+
+	err = ch.QueueBind(
+		GeofenceParkingQueue, // queue name
+		GeofenceParkingQueue, // routing key
+		DeadLetterExchange,   // exchange
+		false,
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to bind parking-lot queue: %v", err)
+	}
+
+	_, err = ch.QueueDeclare(
+		GeofenceAlertsQueue, // queue name
+		true,                // durable
+		false,               // delete when unused
+		false,               // exclusive
+		false,               // no-wait
+		amqp.Table{
+			"x-dead-letter-exchange":    DeadLetterExchange,
+			"x-dead-letter-routing-key": GeofenceParkingQueue,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare queue: %v", err)
+	}
+
+	err = ch.QueueBind(
+		GeofenceAlertsQueue, // queue name
+		"geofence.#",        // routing key pattern
+		EventsExchange,      // exchange
+		false,
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to bind queue: %v", err)
+	}
+
+	return nil
+}
+
+// DeclareRetryQueues declares one delay queue per retry delay. Messages wait
+// in a delay queue until their TTL expires and are then dead-lettered back to
+// geofence_alerts.
+func DeclareRetryQueues(ch *amqp.Channel, delays []time.Duration) error {
+	for _, delay := range delays {
+		_, err := ch.QueueDeclare(
+			RetryQueueName(delay), // queue name
+			true,                  // durable
+			false,                 // delete when unused
+			false,                 // exclusive
+			false,                 // no-wait
+			amqp.Table{
+				"x-message-ttl":             delay.Milliseconds(),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": GeofenceAlertsQueue,
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to declare retry queue %s: %v", RetryQueueName(delay), err)
+		}
+	}
+
+	return nil
+}
+
+// RetryQueueName returns the delay queue name for the given delay, in
+// seconds or in milliseconds when the delay is not whole seconds, so two
+// delays never share a queue with different TTLs
+func RetryQueueName(delay time.Duration) string {
+	if delay%time.Second != 0 {
+		return fmt.Sprintf("%s.retry.%dms", GeofenceAlertsQueue, delay.Milliseconds())
+	}
+	return fmt.Sprintf("%s.retry.%ds", GeofenceAlertsQueue, int(delay.Seconds()))
+}
+
+// RetryCount returns the retry count stored in the message headers
+func RetryCount(headers amqp.Table) int {
+	switch v := headers[RetryCountHeader].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+// NextRetry returns the delay before the next attempt of a message that
+// already went through the given number of retries, or parked when it has to
+// go to the parking-lot queue instead
+func NextRetry(retries, maxRetries int, delays []time.Duration) (delay time.Duration, parked bool) {
+	if retries >= maxRetries || len(delays) == 0 {
+		return 0, true
+	}
+	return delays[min(retries, len(delays)-1)], false
+}
+
+// RetryOrPark republishes a failed delivery to the next retry queue, or to the
+// parking-lot queue once maxRetries is reached, and acks the original once
+// the broker confirmed the republish. ch must be in confirm mode.
+// It returns true when the message was parked.
+func RetryOrPark(ch *amqp.Channel, msg amqp.Delivery, cause error, maxRetries int, delays []time.Duration) (bool, error) {
+	retries := RetryCount(msg.Headers)
+
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[RetryCountHeader] = int32(retries + 1)
+	headers[LastErrorHeader] = cause.Error()
+
+	exchange, routingKey := DeadLetterExchange, GeofenceParkingQueue
+	delay, parked := NextRetry(retries, maxRetries, delays)
+	if !parked {
+		exchange, routingKey = "", RetryQueueName(delay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(
+		ctx,
+		exchange,   // exchange
+		routingKey, // routing key
+		false,      // mandatory
+		false,      // immediate
+		amqp.Publishing{
+			ContentType:  msg.ContentType,
+			MessageId:    msg.MessageId,
+			Headers:      headers,
+			Body:         msg.Body,
+			DeliveryMode: amqp.Persistent,
+			Timestamp:    msg.Timestamp,
+		},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to republish message: %v", err)
+	}
+
+	// Without the confirm the broker may have dropped the copy, the original
+	// is then requeued by the caller instead of acked
+	if confirm == nil {
+		return false, fmt.Errorf("failed to republish message: channel is not in confirm mode")
+	}
+	ok, err := confirm.WaitContext(ctx)
+	if err != nil || !ok {
+		return false, fmt.Errorf("broker did not confirm republish to %s: %v", routingKey, err)
+	}
+
+	return parked, msg.Ack(false)
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestRetryQueueName(t *testing.T) {
+	tests := []struct {
+		delay time.Duration
+		want  string
+	}{
+		{5 * time.Second, "geofence_alerts.retry.5s"},
+		{2 * time.Minute, "geofence_alerts.retry.120s"},
+		{time.Second, "geofence_alerts.retry.1s"},
+		{1500 * time.Millisecond, "geofence_alerts.retry.1500ms"},
+		{250 * time.Millisecond, "geofence_alerts.retry.250ms"},
+	}
+
+	for _, tt := range tests {
+		if got := RetryQueueName(tt.delay); got != tt.want {
+			t.Errorf("RetryQueueName(%s) = %q, want %q", tt.delay, got, tt.want)
+		}
+	}
+}
+
+func TestRetryQueueNameUniquePerTTL(t *testing.T) {
+	delays := []time.Duration{time.Second, 1500 * time.Millisecond, 1999 * time.Millisecond, 2 * time.Second}
+
+	names := make(map[string]time.Duration)
+	for _, delay := range delays {
+		name := RetryQueueName(delay)
+		if other, ok := names[name]; ok {
+			t.Errorf("%s and %s share the queue %s", other, delay, name)
+		}
+		names[name] = delay
+	}
+}
+
+func TestRetryCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers amqp.Table
+		want    int
+	}{
+		{"no headers", nil, 0},
+		{"header missing", amqp.Table{"vehicle_id": "B1"}, 0},
+		{"int32 as published", amqp.Table{RetryCountHeader: int32(3)}, 3},
+		{"int64 from the broker", amqp.Table{RetryCountHeader: int64(4)}, 4},
+		{"int", amqp.Table{RetryCountHeader: 2}, 2},
+		{"unexpected type", amqp.Table{RetryCountHeader: "3"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RetryCount(tt.headers); got != tt.want {
+				t.Errorf("RetryCount() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextRetry(t *testing.T) {
+	delays := []time.Duration{5 * time.Second, 30 * time.Second, 2 * time.Minute}
+
+	tests := []struct {
+		name       string
+		retries    int
+		maxRetries int
+		delays     []time.Duration
+		wantDelay  time.Duration
+		wantParked bool
+	}{
+		{"first failure", 0, 5, delays, 5 * time.Second, false},
+		{"second failure", 1, 5, delays, 30 * time.Second, false},
+		{"last listed delay", 2, 5, delays, 2 * time.Minute, false},
+		{"past the list repeats the last delay", 4, 5, delays, 2 * time.Minute, false},
+		{"max retries reached", 5, 5, delays, 0, true},
+		{"past max retries", 7, 5, delays, 0, true},
+		{"no retries allowed", 0, 0, delays, 0, true},
+		{"no delays", 0, 5, nil, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, parked := NextRetry(tt.retries, tt.maxRetries, tt.delays)
+			if delay != tt.wantDelay || parked != tt.wantParked {
+				t.Errorf("NextRetry(%d, %d) = %s, %v, want %s, %v",
+					tt.retries, tt.maxRetries, delay, parked, tt.wantDelay, tt.wantParked)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/logging"
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+)
+
+// DeviationPolicy holds the thresholds for a vehicle leaving its route
+type DeviationPolicy struct {
+	Distance float64       // meters from the route shape before a point is off route
+	Duration time.Duration // how long a vehicle must stay off route
+}
+
+// RouteDeviationDetector measures the distance of every point to the shape of
+// the vehicle's route and publishes a route_deviation event when the vehicle
+// stays outside the corridor for longer than the policy allows, and a
+// route_rejoined event when it is back
+type RouteDeviationDetector struct {
+	routes   *RouteService
+	geofence *GeofenceService
+	policy   DeviationPolicy
+	rabbitmq *RabbitMQService
+	logger   *slog.Logger
+
+	mu       sync.Mutex
+	vehicles map[string]*deviationState
+}
+
+type deviationState struct {
+	routeID     string
+	offSince    int64 // first point off route, zero while on route
+	deviated    bool
+	maxDistance float64
+}
+
+func NewRouteDeviationDetector(routes *RouteService, policy DeviationPolicy) *RouteDeviationDetector {
+	return &RouteDeviationDetector{
+		routes:   routes,
+		geofence: NewGeofenceService(),
+		policy:   policy,
+		logger:   logging.New("route-deviation"),
+		vehicles: make(map[string]*deviationState),
+	}
+}
+
+// SetRabbitMQService inject rabbitmq service
+func (d *RouteDeviationDetector) SetRabbitMQService(rmq *RabbitMQService) {
+	d.rabbitmq = rmq
+}
+
+// Check compares a position of a vehicle with the shape of its route.
+// driverID is the driver on duty, nil when unknown.
+func (d *RouteDeviationDetector) Check(vehicleID string, driverID *string, lat, lon float64, timestamp int64) error {
+	routeID, err := d.routes.VehicleRouteID(vehicleID)
+	if err != nil {
+		return fmt.Errorf("failed to get vehicle route: %v", err)
+	}
+
+	if routeID == "" {
+		d.mu.Lock()
+		delete(d.vehicles, vehicleID)
+		d.mu.Unlock()
+		return nil
+	}
+
+	shape, lengths, err := d.routes.Shape(routeID)
+	if err != nil {
+		return fmt.Errorf("failed to get route shape: %v", err)
+	}
+	if len(shape) < 2 {
+		return nil
+	}
+
+	distance := d.geofence.ProjectOntoPolyline(shape, lengths, lat, lon, 0, math.Inf(1)).Offset
+
+	d.mu.Lock()
+	state, ok := d.vehicles[vehicleID]
+	// A new assignment starts over, the old route says nothing about the new one
+	if !ok || state.routeID != routeID {
+		state = &deviationState{routeID: routeID}
+		d.vehicles[vehicleID] = state
+	}
+
+	var eventType string
+	if distance > d.policy.Distance {
+		if state.offSince == 0 || timestamp < state.offSince {
+			state.offSince = timestamp
+		}
+		state.maxDistance = math.Max(state.maxDistance, distance)
+
+		offRoute := time.Duration(timestamp-state.offSince) * time.Second
+		if !state.deviated && offRoute >= d.policy.Duration {
+			state.deviated = true
+			eventType = "route_deviation"
+		}
+	} else if state.deviated {
+		eventType = "route_rejoined"
+	}
+
+	offSince, maxDistance := state.offSince, state.maxDistance
+	if distance <= d.policy.Distance {
+		*state = deviationState{routeID: routeID}
+	}
+	d.mu.Unlock()
+
+	if eventType == "" {
+		return nil
+	}
+
+	duration := timestamp - offSince
+	details := map[string]interface{}{
+		"route_id":            routeID,
+		"distance_meters":     math.Round(distance),
+		"threshold_meters":    d.policy.Distance,
+		"off_route_since":     offSince,
+		"off_route_seconds":   duration,
+		"max_distance_meters": math.Round(maxDistance),
+	}
+	if driverID != nil {
+		details["driver_id"] = *driverID
+	}
+
+	if eventType == "route_deviation" {
+		d.logger.Warn("Vehicle left route", "vehicle_id", vehicleID, "route_id", routeID,
+			"distance_meters", math.Round(distance), "off_route_seconds", duration)
+	} else {
+		d.logger.Info("Vehicle rejoined route", "vehicle_id", vehicleID, "route_id", routeID,
+			"off_route_seconds", duration)
+	}
+
+	d.publish(&models.VehicleEvent{
+		EventID:   models.NewEventID(vehicleID, eventType, routeID, fmt.Sprint(offSince)),
+		VehicleID: vehicleID,
+		Event:     eventType,
+		Timestamp: timestamp,
+		Location: &models.Location{
+			Latitude:  lat,
+			Longitude: lon,
+		},
+		Details: details,
+	})
+
+	return nil
+}
+
+func (d *RouteDeviationDetector) publish(event *models.VehicleEvent) {
+	if d.rabbitmq == nil {
+		d.logger.Warn("RabbitMQ not connected, skipping event", "event", event.Event, "vehicle_id", event.VehicleID)
+		return
+	}
+
+	routingKey := "route.deviation"
+	if event.Event == "route_rejoined" {
+		routingKey = "route.rejoined"
+	}
+
+	if err := d.rabbitmq.PublishVehicleEvent(routingKey, event); err != nil {
+		d.logger.Error("Failed to publish event", "event", event.Event, "vehicle_id", event.VehicleID, "error", err)
+	}
+}
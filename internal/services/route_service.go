@@ -0,0 +1,212 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/asaaitika/fleetmgm-tst/internal/repositories"
+)
+
+// RouteService interprets stop arrivals as progress along the vehicle's route
+type RouteService struct {
+	repo     *repositories.RouteRepository
+	cacheTTL time.Duration
+
+	mu       sync.Mutex
+	stops    map[string]cachedStops
+	shapes   map[string]cachedShape
+	vehicles map[string]cachedVehicleRoute
+}
+
+type cachedStops struct {
+	stops    []models.RouteStop
+	loadedAt time.Time
+}
+
+type cachedShape struct {
+	shape    []models.ShapePoint
+	lengths  []float64
+	loadedAt time.Time
+}
+
+type cachedVehicleRoute struct {
+	routeID  string // empty when the vehicle has no route
+	loadedAt time.Time
+}
+
+func NewRouteService(repo *repositories.RouteRepository, cacheTTL time.Duration) *RouteService {
+	return &RouteService{
+		repo:     repo,
+		cacheTTL: cacheTTL,
+		stops:    make(map[string]cachedStops),
+		shapes:   make(map[string]cachedShape),
+		vehicles: make(map[string]cachedVehicleRoute),
+	}
+}
+
+// Stops returns the ordered stops of a route, cached for cacheTTL
+func (s *RouteService) Stops(routeID string) ([]models.RouteStop, error) {
+	s.mu.Lock()
+	cached, ok := s.stops[routeID]
+	s.mu.Unlock()
+
+	if ok && time.Since(cached.loadedAt) < s.cacheTTL {
+		return cached.stops, nil
+	}
+
+	stops, err := s.repo.GetRouteStops(routeID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.stops[routeID] = cachedStops{stops: stops, loadedAt: time.Now()}
+	s.mu.Unlock()
+
+	return stops, nil
+}
+
+// Shape returns the shape of a route and the distance along it of every
+// point, cached for cacheTTL. Routes without a shape use their stops.
+func (s *RouteService) Shape(routeID string) ([]models.ShapePoint, []float64, error) {
+	s.mu.Lock()
+	cached, ok := s.shapes[routeID]
+	s.mu.Unlock()
+
+	if ok && time.Since(cached.loadedAt) < s.cacheTTL {
+		return cached.shape, cached.lengths, nil
+	}
+
+	shape, err := s.repo.GetRouteShape(routeID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(shape) < 2 {
+		stops, err := s.Stops(routeID)
+		if err != nil {
+			return nil, nil, err
+		}
+		shape = StopShape(stops)
+	}
+
+	lengths := NewGeofenceService().PolylineLengths(shape)
+
+	s.mu.Lock()
+	s.shapes[routeID] = cachedShape{shape: shape, lengths: lengths, loadedAt: time.Now()}
+	s.mu.Unlock()
+
+	return shape, lengths, nil
+}
+
+// VehicleRouteID returns the route a vehicle is assigned to, cached for
+// cacheTTL. It returns an empty string when the vehicle has no route.
+func (s *RouteService) VehicleRouteID(vehicleID string) (string, error) {
+	s.mu.Lock()
+	cached, ok := s.vehicles[vehicleID]
+	s.mu.Unlock()
+
+	if ok && time.Since(cached.loadedAt) < s.cacheTTL {
+		return cached.routeID, nil
+	}
+
+	routeID := ""
+	assignment, err := s.repo.GetVehicleRoute(vehicleID)
+	switch {
+	case err == nil:
+		routeID = assignment.RouteID
+	case !errors.Is(err, sql.ErrNoRows):
+		return "", err
+	}
+
+	s.mu.Lock()
+	s.vehicles[vehicleID] = cachedVehicleRoute{routeID: routeID, loadedAt: time.Now()}
+	s.mu.Unlock()
+
+	return routeID, nil
+}
+
+// StopShape uses the stops of a route as its shape, for routes drawn without one
+func StopShape(stops []models.RouteStop) []models.ShapePoint {
+	shape := make([]models.ShapePoint, len(stops))
+	for i, stop := range stops {
+		shape[i] = models.ShapePoint{Sequence: stop.StopSequence, Latitude: stop.Latitude, Longitude: stop.Longitude}
+	}
+	return shape
+}
+
+// StopArrival records that a vehicle is at the given geofence area and returns
+// its position in the route's stop sequence. It returns nil when the vehicle
+// has no route or the area is not a stop of its route.
+func (s *RouteService) StopArrival(vehicleID string, areaID int, timestamp int64) (*models.RouteProgress, error) {
+	assignment, err := s.repo.GetVehicleRoute(vehicleID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	stops, err := s.Stops(assignment.RouteID)
+	if err != nil {
+		return nil, err
+	}
+
+	sequence := NextStopSequence(stops, assignment.LastStopSequence, areaID)
+	if sequence == 0 {
+		return nil, nil
+	}
+
+	progress := &models.RouteProgress{
+		RouteID:      assignment.RouteID,
+		StopSequence: sequence,
+		StopsTotal:   len(stops),
+		TripID:       assignment.TripID,
+	}
+
+	// Still dwelling at the same stop
+	if assignment.LastStopSequence != nil && *assignment.LastStopSequence == sequence {
+		return progress, nil
+	}
+
+	if err := s.repo.UpdateRouteProgress(vehicleID, sequence, timestamp); err != nil {
+		return nil, err
+	}
+
+	return progress, nil
+}
+
+// NextStopSequence picks the stop sequence matching the area, preferring the
+// last reached stop (dwelling) and then the first match after it. A route can
+// visit the same halte more than once, e.g. on the way back, and wraps around
+// after the last stop. It returns 0 when the area is not on the route.
+func NextStopSequence(stops []models.RouteStop, lastSequence *int, areaID int) int {
+	last := 0
+	if lastSequence != nil {
+		last = *lastSequence
+	}
+
+	first, next := 0, 0
+	for _, stop := range stops {
+		if stop.GeofenceAreaID != areaID {
+			continue
+		}
+		if stop.StopSequence == last {
+			return last
+		}
+		if first == 0 {
+			first = stop.StopSequence
+		}
+		if next == 0 && stop.StopSequence > last {
+			next = stop.StopSequence
+		}
+	}
+
+	if next != 0 {
+		return next
+	}
+	return first
+}
@@ -0,0 +1,34 @@
+package services
+
+import "time"
+
+// ServiceDayStart returns the start of the GTFS service day a scheduled time
+// belongs to, which is noon minus 12h in the schedule's timezone. Trips past
+// midnight have times over 24h, so the day of the actual event and the day
+// before are tried and the closest schedule wins.
+func ServiceDayStart(actual int64, scheduled int, timezone *time.Location) time.Time {
+	local := time.Unix(actual, 0).In(timezone)
+
+	var best time.Time
+	var bestDiff int64 = -1
+	for _, offset := range []int{0, -1} {
+		noon := time.Date(local.Year(), local.Month(), local.Day()+offset, 12, 0, 0, 0, timezone)
+		start := noon.Add(-12 * time.Hour)
+
+		diff := actual - (start.Unix() + int64(scheduled))
+		if diff < 0 {
+			diff = -diff
+		}
+		if bestDiff < 0 || diff < bestDiff {
+			best, bestDiff = start, diff
+		}
+	}
+
+	return best
+}
+
+// SecondsOfDay returns the seconds since local midnight of a unix timestamp
+func SecondsOfDay(timestamp int64, timezone *time.Location) int {
+	local := time.Unix(timestamp, 0).In(timezone)
+	return local.Hour()*3600 + local.Minute()*60 + local.Second()
+}
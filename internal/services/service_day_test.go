@@ -0,0 +1,111 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServiceDayStart(t *testing.T) {
+	jakarta := time.FixedZone("WIB", 7*3600)
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skipf("timezone data not available: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		actual    time.Time
+		scheduled int
+		timezone  *time.Location
+		want      time.Time
+	}{
+		{
+			name:      "daytime stop",
+			actual:    time.Date(2026, 3, 10, 8, 5, 0, 0, jakarta),
+			scheduled: 8 * 3600,
+			timezone:  jakarta,
+			want:      time.Date(2026, 3, 10, 0, 0, 0, 0, jakarta),
+		},
+		{
+			name:      "trip past midnight belongs to the day before",
+			actual:    time.Date(2026, 3, 11, 0, 30, 0, 0, jakarta),
+			scheduled: 24*3600 + 25*60,
+			timezone:  jakarta,
+			want:      time.Date(2026, 3, 10, 0, 0, 0, 0, jakarta),
+		},
+		{
+			name:      "early trip after midnight belongs to the new day",
+			actual:    time.Date(2026, 3, 11, 0, 10, 0, 0, jakarta),
+			scheduled: 15 * 60,
+			timezone:  jakarta,
+			want:      time.Date(2026, 3, 11, 0, 0, 0, 0, jakarta),
+		},
+		{
+			name:      "arrival before midnight for a time over 24h",
+			actual:    time.Date(2026, 3, 10, 23, 55, 0, 0, jakarta),
+			scheduled: 24*3600 + 5*60,
+			timezone:  jakarta,
+			want:      time.Date(2026, 3, 10, 0, 0, 0, 0, jakarta),
+		},
+		{
+			name:      "late arrival stays on the scheduled day",
+			actual:    time.Date(2026, 3, 10, 23, 40, 0, 0, jakarta),
+			scheduled: 22 * 3600,
+			timezone:  jakarta,
+			want:      time.Date(2026, 3, 10, 0, 0, 0, 0, jakarta),
+		},
+		{
+			// Noon minus 12h is 23:00 of the day before when clocks go forward
+			name:      "daylight saving start",
+			actual:    time.Date(2026, 3, 29, 8, 2, 0, 0, amsterdam),
+			scheduled: 8 * 3600,
+			timezone:  amsterdam,
+			want:      time.Date(2026, 3, 28, 23, 0, 0, 0, amsterdam),
+		},
+		{
+			name:      "daylight saving end",
+			actual:    time.Date(2026, 10, 25, 8, 2, 0, 0, amsterdam),
+			scheduled: 8 * 3600,
+			timezone:  amsterdam,
+			want:      time.Date(2026, 10, 25, 1, 0, 0, 0, amsterdam),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ServiceDayStart(tt.actual.Unix(), tt.scheduled, tt.timezone)
+			if !got.Equal(tt.want) {
+				t.Errorf("ServiceDayStart = %s, want %s", got, tt.want)
+			}
+
+			// The scheduled time on that day is the wall clock time of the timetable
+			scheduled := got.Add(time.Duration(tt.scheduled) * time.Second).In(tt.timezone)
+			if diff := tt.actual.Sub(scheduled); diff < -2*time.Hour || diff > 2*time.Hour {
+				t.Errorf("scheduled time %s is %s away from the actual time", scheduled, diff)
+			}
+		})
+	}
+}
+
+func TestSecondsOfDay(t *testing.T) {
+	jakarta := time.FixedZone("WIB", 7*3600)
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want int
+	}{
+		{"midnight", time.Date(2026, 3, 10, 0, 0, 0, 0, jakarta), 0},
+		{"morning", time.Date(2026, 3, 10, 8, 5, 30, 0, jakarta), 8*3600 + 5*60 + 30},
+		{"last second", time.Date(2026, 3, 10, 23, 59, 59, 0, jakarta), 86399},
+		{"utc input is converted", time.Date(2026, 3, 10, 1, 0, 0, 0, time.UTC), 8 * 3600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SecondsOfDay(tt.at.Unix(), jakarta); got != tt.want {
+				t.Errorf("SecondsOfDay = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
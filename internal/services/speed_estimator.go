@@ -0,0 +1,68 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+)
+
+// Speed is only derived from consecutive points closer together than this
+const maxDerivedSpeedGap = time.Minute
+
+// SpeedEstimator gives the speed of a point. The speed reported by the device
+// is preferred, otherwise the smoothed speed or the speed between the last two
+// points of the vehicle is used.
+type SpeedEstimator struct {
+	geofence *GeofenceService
+
+	mu   sync.Mutex
+	last map[string]speedPoint
+}
+
+type speedPoint struct {
+	lat, lon  float64
+	timestamp int64
+}
+
+func NewSpeedEstimator() *SpeedEstimator {
+	return &SpeedEstimator{
+		geofence: NewGeofenceService(),
+		last:     make(map[string]speedPoint),
+	}
+}
+
+// Estimate returns the speed of a plausible point in km/h and whether it is
+// known. lat and lon are the coordinates used for the vehicle.
+func (e *SpeedEstimator) Estimate(location *models.VehicleLocation, lat, lon float64) (float64, bool) {
+	e.mu.Lock()
+	previous, ok := e.last[location.VehicleID]
+	if !ok || location.Timestamp > previous.timestamp {
+		e.last[location.VehicleID] = speedPoint{lat, lon, location.Timestamp}
+	}
+	e.mu.Unlock()
+
+	if speed, ok := measuredSpeed(location); ok {
+		return speed, true
+	}
+
+	elapsed := location.Timestamp - previous.timestamp
+	if !ok || elapsed <= 0 || time.Duration(elapsed)*time.Second > maxDerivedSpeedGap {
+		return 0, false
+	}
+
+	distance := e.geofence.CalculateDistance(previous.lat, previous.lon, lat, lon)
+	return distance / float64(elapsed) * 3.6, true
+}
+
+// measuredSpeed returns the speed reported by the device, or else the
+// smoothed speed
+func measuredSpeed(location *models.VehicleLocation) (float64, bool) {
+	if location.SpeedKmh != nil {
+		return *location.SpeedKmh, true
+	}
+	if location.SmoothedSpeedKmh != nil {
+		return *location.SmoothedSpeedKmh, true
+	}
+	return 0, false
+}
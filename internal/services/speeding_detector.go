@@ -0,0 +1,254 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/logging"
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/asaaitika/fleetmgm-tst/internal/repositories"
+)
+
+// SpeedingPolicy holds the thresholds for speeding in a speed limit zone
+type SpeedingPolicy struct {
+	ToleranceKmh float64       // speed above the limit that is still accepted
+	MinDuration  time.Duration // how long a vehicle must be too fast
+}
+
+// SpeedingDetector compares the speed of every point with the limit of the
+// zone it is in and publishes speeding_start / speeding_end events
+type SpeedingDetector struct {
+	repo     *repositories.SpeedingRepository
+	geofence *GeofenceService
+	policy   SpeedingPolicy
+	cacheTTL time.Duration
+	rabbitmq *RabbitMQService
+	logger   *slog.Logger
+
+	mu            sync.Mutex
+	zones         []speedZone
+	zonesLoadedAt time.Time
+	vehicles      map[string]*speedingState
+}
+
+type speedZone struct {
+	models.SpeedLimitZone
+	lengths []float64 // corridors only
+}
+
+type speedingState struct {
+	zoneID    int   // zone the vehicle is too fast in
+	overSince int64 // first point too fast, zero while within the limit
+	maxSpeed  float64
+	speeding  bool // speeding_start was sent
+	event     models.SpeedingEvent
+}
+
+func NewSpeedingDetector(repo *repositories.SpeedingRepository, policy SpeedingPolicy, cacheTTL time.Duration) *SpeedingDetector {
+	return &SpeedingDetector{
+		repo:     repo,
+		geofence: NewGeofenceService(),
+		policy:   policy,
+		cacheTTL: cacheTTL,
+		logger:   logging.New("speeding-detector"),
+		vehicles: make(map[string]*speedingState),
+	}
+}
+
+// SetRabbitMQService inject rabbitmq service
+func (d *SpeedingDetector) SetRabbitMQService(rmq *RabbitMQService) {
+	d.rabbitmq = rmq
+}
+
+// Seed restores speeding events still open, e.g. at startup, so they are
+// closed when the vehicle slows down
+func (d *SpeedingDetector) Seed(events []models.SpeedingEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, event := range events {
+		state := &speedingState{
+			overSince: event.StartedAt,
+			maxSpeed:  event.MaxSpeedKmh,
+			speeding:  true,
+			event:     event,
+		}
+		if event.ZoneID != nil {
+			state.zoneID = *event.ZoneID
+		}
+		d.vehicles[event.VehicleID] = state
+	}
+}
+
+// Check looks for speeding at a plausible point of a vehicle driving at speed km/h
+func (d *SpeedingDetector) Check(location *models.VehicleLocation, lat, lon, speed float64, driverID *string) error {
+	zones, err := d.loadZones()
+	if err != nil {
+		return fmt.Errorf("failed to get speed limit zones: %v", err)
+	}
+
+	d.mu.Lock()
+	state, ok := d.vehicles[location.VehicleID]
+	if !ok {
+		state = &speedingState{}
+		d.vehicles[location.VehicleID] = state
+	}
+
+	// The strictest zone applies where zones overlap
+	var zone *speedZone
+	for i := range zones {
+		if d.contains(&zones[i], lat, lon) && (zone == nil || zones[i].SpeedLimitKmh < zone.SpeedLimitKmh) {
+			zone = &zones[i]
+		}
+	}
+
+	over := zone != nil && speed > zone.SpeedLimitKmh+d.policy.ToleranceKmh
+
+	var ended, started *models.SpeedingEvent
+	if state.overSince != 0 && (!over || zone.ID != state.zoneID) {
+		if state.speeding {
+			event := state.event
+			event.EndedAt = &location.Timestamp
+			event.MaxSpeedKmh = state.maxSpeed
+			ended = &event
+		}
+		state.overSince, state.speeding, state.maxSpeed = 0, false, 0
+	}
+
+	if over {
+		if state.overSince == 0 {
+			state.zoneID, state.overSince = zone.ID, location.Timestamp
+		}
+		state.maxSpeed = math.Max(state.maxSpeed, speed)
+
+		overFor := time.Duration(location.Timestamp-state.overSince) * time.Second
+		if !state.speeding && overFor >= d.policy.MinDuration {
+			zoneID := zone.ID
+			state.speeding = true
+			state.event = models.SpeedingEvent{
+				VehicleID:     location.VehicleID,
+				DriverID:      driverID,
+				ZoneID:        &zoneID,
+				ZoneName:      zone.Name,
+				SpeedLimitKmh: zone.SpeedLimitKmh,
+				StartedAt:     state.overSince,
+				MaxSpeedKmh:   state.maxSpeed,
+				Latitude:      lat,
+				Longitude:     lon,
+			}
+			event := state.event
+			started = &event
+		}
+	}
+	d.mu.Unlock()
+
+	if ended != nil {
+		if err := d.repo.EndSpeeding(ended.VehicleID, *ended.EndedAt, ended.MaxSpeedKmh); err != nil {
+			return fmt.Errorf("failed to record end of speeding: %v", err)
+		}
+
+		d.logger.Info("Vehicle no longer speeding", "vehicle_id", ended.VehicleID, "zone", ended.ZoneName,
+			"speeding_seconds", *ended.EndedAt-ended.StartedAt, "max_speed_kmh", math.Round(ended.MaxSpeedKmh))
+		d.publish("speeding.end", "speeding_end", ended, lat, lon, *ended.EndedAt)
+	}
+
+	if started != nil {
+		if err := d.repo.StartSpeeding(started); err != nil {
+			return fmt.Errorf("failed to record speeding: %v", err)
+		}
+
+		d.logger.Warn("Vehicle speeding", "vehicle_id", started.VehicleID, "zone", started.ZoneName,
+			"speed_kmh", math.Round(speed), "speed_limit_kmh", started.SpeedLimitKmh)
+		d.publish("speeding.start", "speeding_start", started, lat, lon, location.Timestamp)
+	}
+
+	return nil
+}
+
+// contains reports whether a point lies in a zone
+func (d *SpeedingDetector) contains(zone *speedZone, lat, lon float64) bool {
+	switch zone.ZoneType {
+	case models.ZonePolygon:
+		return len(zone.Points) >= 3 && d.geofence.ContainsPoint(zone.Points, lat, lon)
+	case models.ZoneCorridor:
+		if len(zone.Points) < 2 {
+			return false
+		}
+		projection := d.geofence.ProjectOntoPolyline(zone.Points, zone.lengths, lat, lon, 0, math.Inf(1))
+		return projection.Offset <= zone.WidthMeters/2
+	default:
+		return false
+	}
+}
+
+// loadZones returns the speed limit zones, cached for cacheTTL
+func (d *SpeedingDetector) loadZones() ([]speedZone, error) {
+	d.mu.Lock()
+	zones, loadedAt := d.zones, d.zonesLoadedAt
+	d.mu.Unlock()
+
+	if !loadedAt.IsZero() && time.Since(loadedAt) < d.cacheTTL {
+		return zones, nil
+	}
+
+	loaded, err := d.repo.GetSpeedLimitZones()
+	if err != nil {
+		return nil, err
+	}
+
+	zones = make([]speedZone, len(loaded))
+	for i, zone := range loaded {
+		zones[i] = speedZone{SpeedLimitZone: zone}
+		if zone.ZoneType == models.ZoneCorridor {
+			zones[i].lengths = d.geofence.PolylineLengths(zone.Points)
+		}
+	}
+
+	d.mu.Lock()
+	d.zones, d.zonesLoadedAt = zones, time.Now()
+	d.mu.Unlock()
+
+	return zones, nil
+}
+
+func (d *SpeedingDetector) publish(routingKey, eventType string, speeding *models.SpeedingEvent, lat, lon float64, timestamp int64) {
+	if d.rabbitmq == nil {
+		d.logger.Warn("RabbitMQ not connected, skipping event", "event", eventType, "vehicle_id", speeding.VehicleID)
+		return
+	}
+
+	details := map[string]interface{}{
+		"zone_name":       speeding.ZoneName,
+		"speed_limit_kmh": speeding.SpeedLimitKmh,
+		"max_speed_kmh":   math.Round(speeding.MaxSpeedKmh),
+		"started_at":      speeding.StartedAt,
+	}
+	if speeding.ZoneID != nil {
+		details["zone_id"] = *speeding.ZoneID
+	}
+	if speeding.DriverID != nil {
+		details["driver_id"] = *speeding.DriverID
+	}
+	if speeding.EndedAt != nil {
+		details["duration_seconds"] = *speeding.EndedAt - speeding.StartedAt
+	}
+
+	event := &models.VehicleEvent{
+		EventID:   models.NewEventID(speeding.VehicleID, eventType, fmt.Sprint(speeding.StartedAt)),
+		VehicleID: speeding.VehicleID,
+		Event:     eventType,
+		Timestamp: timestamp,
+		Location: &models.Location{
+			Latitude:  lat,
+			Longitude: lon,
+		},
+		Details: details,
+	}
+
+	if err := d.rabbitmq.PublishVehicleEvent(routingKey, event); err != nil {
+		d.logger.Error("Failed to publish event", "event", eventType, "vehicle_id", speeding.VehicleID, "error", err)
+	}
+}
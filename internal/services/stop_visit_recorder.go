@@ -0,0 +1,106 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/asaaitika/fleetmgm-tst/internal/models"
+	"github.com/asaaitika/fleetmgm-tst/internal/repositories"
+)
+
+// StopVisitRecorder persists arrivals and departures at stops and matches
+// them to the GTFS schedule
+type StopVisitRecorder struct {
+	repo     *repositories.StopVisitRepository
+	timezone *time.Location
+}
+
+func NewStopVisitRecorder(repo *repositories.StopVisitRepository, timezone *time.Location) *StopVisitRecorder {
+	return &StopVisitRecorder{repo: repo, timezone: timezone}
+}
+
+// Arrive records an arrival. When the area is a stop of the vehicle's route
+// the visit is compared to the schedule of the vehicle's trip, or to the
+// closest scheduled arrival among the route's trips running that day when the
+// vehicle has no trip.
+func (r *StopVisitRecorder) Arrive(vehicleID string, driverID *string, areaID int, timestamp int64, progress *models.RouteProgress) (*models.StopVisit, error) {
+	visit := &models.StopVisit{
+		VehicleID:      vehicleID,
+		DriverID:       driverID,
+		GeofenceAreaID: areaID,
+		ArrivedAt:      timestamp,
+	}
+
+	if progress != nil {
+		visit.RouteID = &progress.RouteID
+		visit.StopSequence = &progress.StopSequence
+		visit.TripID = progress.TripID
+
+		scheduled, err := r.schedule(progress, areaID, timestamp)
+		if err != nil {
+			return nil, err
+		}
+		if scheduled != nil {
+			r.applySchedule(visit, scheduled)
+		}
+	}
+
+	if err := r.repo.InsertArrival(visit); err != nil {
+		return nil, err
+	}
+
+	return visit, nil
+}
+
+// Depart closes the open visit at the area
+func (r *StopVisitRecorder) Depart(vehicleID string, areaID int, timestamp int64) error {
+	return r.repo.RecordDeparture(vehicleID, areaID, timestamp)
+}
+
+// OpenVisits returns visits without a departure to seed a GeofenceTracker
+func (r *StopVisitRecorder) OpenVisits() ([]models.StopVisit, error) {
+	return r.repo.GetOpenVisits()
+}
+
+func (r *StopVisitRecorder) schedule(progress *models.RouteProgress, areaID int, timestamp int64) (*models.ScheduledStop, error) {
+	var scheduled *models.ScheduledStop
+	var err error
+
+	if progress.TripID != nil {
+		scheduled, err = r.repo.FindTripStop(*progress.TripID, areaID, progress.StopSequence)
+	} else {
+		local := time.Unix(timestamp, 0).In(r.timezone)
+		scheduled, err = r.repo.FindClosestRouteStop(progress.RouteID, areaID, local, SecondsOfDay(timestamp, r.timezone))
+	}
+
+	// Routes and trips without a timetable are still logged
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return scheduled, err
+}
+
+func (r *StopVisitRecorder) applySchedule(visit *models.StopVisit, scheduled *models.ScheduledStop) {
+	visit.TripID = &scheduled.TripID
+
+	reference := scheduled.ArrivalTime
+	if reference == nil {
+		reference = scheduled.DepartureTime
+	}
+	if reference == nil {
+		return
+	}
+	day := ServiceDayStart(visit.ArrivedAt, *reference, r.timezone).Unix()
+
+	if scheduled.ArrivalTime != nil {
+		arrival := day + int64(*scheduled.ArrivalTime)
+		delay := int(visit.ArrivedAt - arrival)
+		visit.ScheduledArrival = &arrival
+		visit.ArrivalDelay = &delay
+	}
+	if scheduled.DepartureTime != nil {
+		departure := day + int64(*scheduled.DepartureTime)
+		visit.ScheduledDeparture = &departure
+	}
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"hash/fnv"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// WorkerPool fans deliveries out to a fixed number of workers.
+// Deliveries with the same key always go to the same worker, so messages of
+// one vehicle are still handled in the order they were received.
+type WorkerPool struct {
+	shards []chan amqp.Delivery
+	wg     sync.WaitGroup
+}
+
+func NewWorkerPool(size, buffer int, handler func(amqp.Delivery)) *WorkerPool {
+	if size < 1 {
+		size = 1
+	}
+
+	pool := &WorkerPool{
+		shards: make([]chan amqp.Delivery, size),
+	}
+
+	for i := range pool.shards {
+		shard := make(chan amqp.Delivery, buffer)
+		pool.shards[i] = shard
+
+		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			for msg := range shard {
+				handler(msg)
+			}
+		}()
+	}
+
+	return pool
+}
+
+// Size returns the number of workers in the pool
+func (p *WorkerPool) Size() int {
+	return len(p.shards)
+}
+
+// Dispatch queues a delivery on the worker owning the given key
+func (p *WorkerPool) Dispatch(key string, msg amqp.Delivery) {
+	p.shards[p.shardFor(key)] <- msg
+}
+
+// Close stops accepting deliveries and waits until every worker is done
+func (p *WorkerPool) Close() {
+	for _, shard := range p.shards {
+		close(shard)
+	}
+	p.wg.Wait()
+}
+
+func (p *WorkerPool) shardFor(key string) int {
+	return shardOf(key, len(p.shards))
+}
+
+// shardOf returns the shard of a key out of n, the same key always gets the
+// same shard
+func shardOf(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestWorkerPoolRouting(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		vehicles []string
+		perKey   int
+	}{
+		{"single worker", 1, []string{"B1", "B2", "B3"}, 50},
+		{"fewer vehicles than workers", 8, []string{"B1", "B2"}, 100},
+		{"more vehicles than workers", 4, []string{"B1", "B2", "B3", "B4", "B5", "B6", "B7", "B8", "B9"}, 100},
+		{"zero size falls back to one worker", 0, []string{"B1", "B2"}, 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			seen := make(map[string][]int)  // vehicle -> sequence numbers in handling order
+			workers := make(map[string]int) // vehicle -> shard that handled it
+			handler := func(msg amqp.Delivery) {
+				vehicle := msg.Headers["vehicle_id"].(string)
+				mu.Lock()
+				defer mu.Unlock()
+				seen[vehicle] = append(seen[vehicle], int(msg.DeliveryTag))
+			}
+
+			pool := NewWorkerPool(tt.size, 4, handler)
+			if tt.size < 1 && pool.Size() != 1 {
+				t.Fatalf("Size() = %d, want 1", pool.Size())
+			}
+
+			for i := 0; i < tt.perKey; i++ {
+				for _, vehicle := range tt.vehicles {
+					workers[vehicle] = pool.shardFor(vehicle)
+					pool.Dispatch(vehicle, amqp.Delivery{
+						DeliveryTag: uint64(i),
+						Headers:     amqp.Table{"vehicle_id": vehicle},
+					})
+				}
+			}
+			pool.Close()
+
+			for _, vehicle := range tt.vehicles {
+				if shard := pool.shardFor(vehicle); shard != workers[vehicle] {
+					t.Errorf("%s moved from shard %d to %d", vehicle, workers[vehicle], shard)
+				}
+
+				got := seen[vehicle]
+				if len(got) != tt.perKey {
+					t.Fatalf("%s: handled %d deliveries, want %d", vehicle, len(got), tt.perKey)
+				}
+				for i, seq := range got {
+					if seq != i {
+						t.Fatalf("%s: delivery %d handled at position %d, want in order", vehicle, seq, i)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWorkerPoolSpreadsVehicles(t *testing.T) {
+	pool := NewWorkerPool(4, 1, func(amqp.Delivery) {})
+	defer pool.Close()
+
+	used := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		used[pool.shardFor(fmt.Sprintf("B%04dXYZ", i))] = true
+	}
+	if len(used) != pool.Size() {
+		t.Errorf("100 vehicles used %d of %d workers", len(used), pool.Size())
+	}
+}
@@ -0,0 +1,114 @@
+#!/usr/bin/env bash
+# Integration test of the MQTT TLS settings. Generates a throwaway CA, runs
+# mosquitto with mutual TLS and a password file, and checks that the mock
+# publisher only gets through with the CA, a client certificate and the right
+# password. The subscriber builds its connection the same way
+# (internal/mqttconn).
+#
+# Needs docker, openssl and go. Usage: ./scripts/test-mqtt-tls.sh
+set -euo pipefail
+
+ROOT=$(cd "$(dirname "$0")/.." && pwd)
+PORT=${MQTT_TLS_TEST_PORT:-18883}
+IMAGE=eclipse-mosquitto:2.0.18
+NAME=fleet_mqtt_tls_test
+WORK=$(mktemp -d)
+
+cleanup() {
+	docker rm -f "$NAME" >/dev/null 2>&1 || true
+	rm -rf "$WORK"
+}
+trap cleanup EXIT
+
+failures=0
+pass() { echo "PASS: $1"; }
+fail() { echo "FAIL: $1"; failures=$((failures + 1)); }
+
+echo "==> Generating CA, broker and client certificates in $WORK"
+cd "$WORK"
+openssl req -x509 -newkey rsa:2048 -nodes -days 1 -subj "/CN=fleet-test-ca" \
+	-keyout ca.key -out ca.crt 2>/dev/null
+openssl req -newkey rsa:2048 -nodes -subj "/CN=localhost" -keyout server.key -out server.csr 2>/dev/null
+printf "subjectAltName=DNS:localhost,IP:127.0.0.1\n" >server.ext
+openssl x509 -req -days 1 -in server.csr -CA ca.crt -CAkey ca.key -CAcreateserial \
+	-extfile server.ext -out server.crt 2>/dev/null
+openssl req -newkey rsa:2048 -nodes -subj "/CN=fleet-mock-publisher" -keyout client.key -out client.csr 2>/dev/null
+openssl x509 -req -days 1 -in client.csr -CA ca.crt -CAkey ca.key -CAcreateserial -out client.crt 2>/dev/null
+
+# A second CA, the broker must not accept its certificates
+openssl req -x509 -newkey rsa:2048 -nodes -days 1 -subj "/CN=other-ca" \
+	-keyout other-ca.key -out other-ca.crt 2>/dev/null
+openssl req -newkey rsa:2048 -nodes -subj "/CN=intruder" -keyout intruder.key -out intruder.csr 2>/dev/null
+openssl x509 -req -days 1 -in intruder.csr -CA other-ca.crt -CAkey other-ca.key -CAcreateserial \
+	-out intruder.crt 2>/dev/null
+chmod 644 ./*.key
+
+docker run --rm -v "$WORK:/work" "$IMAGE" \
+	sh -c "mosquitto_passwd -b -c /work/passwd fleet s3cret && chmod 644 /work/passwd"
+
+echo "==> Starting mosquitto on port $PORT"
+docker run -d --name "$NAME" -p "127.0.0.1:$PORT:8883" \
+	-v "$WORK:/mosquitto/certs:ro" \
+	-v "$ROOT/config/mosquitto-tls.conf:/mosquitto/config/mosquitto.conf:ro" \
+	"$IMAGE" >/dev/null
+
+for _ in $(seq 1 30); do
+	if docker logs "$NAME" 2>&1 | grep -q "listen socket on port 8883"; then
+		break
+	fi
+	sleep 0.5
+done
+
+echo "==> Building mock publisher"
+(cd "$ROOT" && go build -o "$WORK/mock-publisher" ./cmd/mock-publisher)
+
+# publish runs the mock publisher for a few seconds with the given extra
+# environment and prints its exit code: 124 when it kept publishing until the
+# timeout, 1 when it failed to connect (the connect is not retried)
+publish() {
+	env MQTT_BROKER="ssl://localhost:$PORT" \
+		MQTT_USERNAME=fleet MQTT_PASSWORD=s3cret \
+		MQTT_TLS_CA="$WORK/ca.crt" MQTT_TLS_CERT="$WORK/client.crt" MQTT_TLS_KEY="$WORK/client.key" \
+		PUBLISH_INTERVAL=500ms METRICS_ADDR=127.0.0.1:0 LOG_LEVEL=warn MQTT_CONNECT_RETRY_INTERVAL=0 \
+		"$@" timeout 5 "$WORK/mock-publisher" >"$WORK/publisher.log" 2>&1 && echo 0 || echo $?
+}
+
+echo "==> Mutual TLS with username and password"
+docker exec "$NAME" mosquitto_sub -h localhost -p 8883 \
+	--cafile /mosquitto/certs/ca.crt --cert /mosquitto/certs/client.crt --key /mosquitto/certs/client.key \
+	-u fleet -P s3cret -t '/fleet/vehicle/+/location' -C 1 -W 15 >"$WORK/received.json" 2>&1 &
+subscriber=$!
+sleep 1
+
+code=$(publish)
+wait "$subscriber" || true
+if [ "$code" = 124 ] && grep -q '"vehicle_id"' "$WORK/received.json"; then
+	pass "publisher connected over mutual TLS and the message reached the broker"
+else
+	fail "expected a published message (exit $code): $(cat "$WORK/publisher.log" "$WORK/received.json")"
+fi
+
+echo "==> Rejected connections"
+expect_rejected() {
+	local name=$1
+	shift
+	local code
+	code=$(publish "$@")
+	if [ "$code" = 1 ] && grep -q "Failed to connect" "$WORK/publisher.log"; then
+		pass "$name"
+	else
+		fail "$name (exit $code): $(cat "$WORK/publisher.log")"
+	fi
+}
+
+expect_rejected "without a client certificate" MQTT_TLS_CERT= MQTT_TLS_KEY=
+expect_rejected "with a client certificate from another CA" \
+	MQTT_TLS_CERT="$WORK/intruder.crt" MQTT_TLS_KEY="$WORK/intruder.key"
+expect_rejected "with a wrong password" MQTT_PASSWORD=wrong
+expect_rejected "without the CA bundle (system roots)" MQTT_TLS_CA=
+
+if [ "$failures" -gt 0 ]; then
+	echo "$failures check(s) failed"
+	exit 1
+fi
+echo "All MQTT TLS checks passed"